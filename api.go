@@ -1,21 +1,33 @@
 package main
 
 import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/santosh/gingo/docs"
+	log "github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
 type DomainDefinition struct {
-	Domain string `json:"domain" example:"google.com"`
-	Type   string `json:"type" example:"A"`
+	Domain  string   `json:"domain" example:"google.com"`
+	Type    string   `json:"type" example:"A"`
+	Subnets []string `json:"subnets,omitempty" example:"192.0.2.0/24"`
+	// Group - defaults to the default group, whose client subnets apply if no subnets are given
+	Group string `json:"group,omitempty" example:"critical"`
 }
 type DomainListDefinition struct {
 	Domains []DomainDefinition `json:"domains"`
@@ -29,9 +41,135 @@ type ResponseError struct {
 	Message string `json:"message" example:"error <error msg here>"`
 }
 
-type ResponseWithDomains struct {
+type ResponseWithDomainPage struct {
+	Message string            `json:"message" example:"success"`
+	Domains []DomainListEntry `json:"domains"`
+	// Total - the number of domains matching the filters
+	Total int `json:"total" example:"523114"`
+	// NextCursor - pass as cursor to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor" example:"eyJ2IjoxNzAwMDAwMDAwMDAwLCJrIjoiZGVmYXVsdCBnb29nbGUuY29tIElOIEEifQ"`
+}
+
+type DomainHistoryDefinition struct {
+	Domain     string                 `json:"domain" example:"google.com"`
+	Type       string                 `json:"type" example:"A"`
+	Subnet     string                 `json:"subnet,omitempty" example:"192.0.2.0/24"`
+	RefreshAt  int64                  `json:"refresh_at" example:"1700000010000"`
+	LastResult map[string]QueryResult `json:"last_result"`
+	History    []QueryResult          `json:"history"`
+}
+
+type ResponseWithHistory struct {
+	Message string                    `json:"message" example:"success"`
+	Domains []DomainHistoryDefinition `json:"domains"`
+}
+
+type QuarantinedDomainDefinition struct {
+	Domain        string `json:"domain" example:"google.com"`
+	Type          string `json:"type" example:"A"`
+	Subnet        string `json:"subnet,omitempty" example:"192.0.2.0/24"`
+	Failures      uint   `json:"failures" example:"10"`
+	QuarantinedAt int64  `json:"quarantined_at" example:"1700000000000"`
+	RecheckAt     int64  `json:"recheck_at" example:"1700086400000"`
+	LastError     string `json:"last_error,omitempty" example:"regular: received no rr for regular lookup (rcode SERVFAIL)"`
+}
+
+type ResponseWithQuarantine struct {
+	Message string                        `json:"message" example:"success"`
+	Domains []QuarantinedDomainDefinition `json:"domains"`
+}
+
+type TargetDefinition struct {
+	Id              string  `json:"id" example:"default"`
+	Protocol        string  `json:"protocol" example:"udp"`
+	Address         string  `json:"address" example:"127.0.0.1:53"`
+	Dnssec          bool    `json:"dnssec" example:"false"`
+	CacheProbe      bool    `json:"cache_probe" example:"false"`
+	CacheHitRatio   float64 `json:"cache_hit_ratio" example:"0.85"`
+	CacheClassified int     `json:"cache_classified" example:"1000"`
+}
+
+type ResponseWithTargets struct {
 	Message string             `json:"message" example:"success"`
-	Domains []DomainDefinition `json:"domains"`
+	Targets []TargetDefinition `json:"targets"`
+}
+
+type ResponseWithProbe struct {
+	Message string      `json:"message" example:"success"`
+	Probe   ProbeResult `json:"probe"`
+}
+
+type ResponseWithDiff struct {
+	Message    string           `json:"message" example:"success"`
+	Reference  string           `json:"reference" example:"old"`
+	Candidate  string           `json:"candidate" example:"new"`
+	Counts     map[string]int   `json:"counts"`
+	Mismatches []AnswerMismatch `json:"mismatches"`
+}
+
+type ResponseWithSchedule struct {
+	Message string                `json:"message" example:"success"`
+	Groups  []GroupScheduleStatus `json:"groups"`
+	Windows []ScheduleWindow      `json:"windows"`
+	Rules   []ScheduleRuleStatus  `json:"rules"`
+}
+
+type BoostDefinition struct {
+	// Groups - defaults to all groups
+	Groups []string `json:"groups,omitempty" example:"critical"`
+	// RateLimit - refreshes per second of each group until the deadline, 0 is unlimited
+	RateLimit uint `json:"rate_limit" example:"1000"`
+	// Deadline - unix seconds at which the boost ends, alternatively use duration_seconds
+	Deadline        int64 `json:"deadline,omitempty" example:"1700003600"`
+	DurationSeconds uint  `json:"duration_seconds,omitempty" example:"3600"`
+}
+
+type ResponseWithWindow struct {
+	Message string         `json:"message" example:"success"`
+	Window  ScheduleWindow `json:"window"`
+}
+
+type ResponseWithSchedulerState struct {
+	Message string `json:"message" example:"success"`
+	SchedulerState
+	InFlight int64 `json:"in_flight" example:"12"`
+	// Spread - overdue domains spread out at the rate limit on resume
+	Spread int `json:"spread,omitempty" example:"0"`
+}
+
+type ResponseWithDrain struct {
+	Message string `json:"message" example:"success"`
+	SchedulerState
+	Drain DrainResult `json:"drain"`
+}
+
+type ResponseWithRefreshJob struct {
+	Message string     `json:"message" example:"success"`
+	Job     RefreshJob `json:"job"`
+}
+
+type ResponseWithImport struct {
+	Message string `json:"message" example:"success"`
+	ImportResult
+}
+
+type ResponseWithSubscriptions struct {
+	Message       string         `json:"message" example:"success"`
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+type ResponseWithSubscription struct {
+	Message      string       `json:"message" example:"success"`
+	Subscription Subscription `json:"subscription"`
+}
+
+type LearnDefinition struct {
+	Names []string `json:"names" example:"host1.corp.example"`
+}
+
+type ResponseWithLearn struct {
+	Message string `json:"message" example:"success"`
+	LearnResult
 }
 
 type ResponseWithSize struct {
@@ -47,6 +185,7 @@ type ResponseWithSize struct {
 // @Produce     json
 // @Success     200  {object}  main.ResponseWithSize
 // @Failure     412  {object}  main.ResponseError
+// @Security    BearerAuth
 // @Router      /domains/random [post]
 func HandleLoadRandomDomains(c *gin.Context, dh *DomainHeap) {
 	qps_aim := 50
@@ -75,35 +214,219 @@ func HandleLoadRandomDomains(c *gin.Context, dh *DomainHeap) {
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"message": "success",
-			"size":    dh.Len(),
+			"size":    dh.QueueSize(),
 		})
 	}
 }
 
 // HandleDumpDomains godoc
-// @Summary      Return a list of domains currently in the queue
-// @Description  Responds with the queue
+// @Summary      Return a page of the domains currently in the queue
+// @Description  Responds with the matching domains of a consistent snapshot of the queue, their total number and the cursor of the next page
+// @Param 		 limit 		query 		int 	false 	"page size (default 100, max 10000)"	example(100)
+// @Param 		 cursor 	query 		string 	false 	"next_cursor of the previous page"
+// @Param 		 sort 		query 		string 	false 	"due (default), name or ttl"	example(due)
+// @Param 		 type 		query 		string 	false 	"only entries of this record type"	example(A)
+// @Param 		 group 		query 		string 	false 	"only entries of this group"	example(critical)
+// @Param 		 subnet 	query 		string 	false 	"only entries for this client subnet"	example(192.0.2.0/24)
+// @Param 		 suffix 	query 		string 	false 	"only domains equal to or below this domain"	example(example.com)
+// @Param 		 regex 		query 		string 	false 	"only domains matching this regular expression"	example(^www\.)
+// @Param 		 status 	query 		string 	false 	"only entries with this status (ok, pending, failing, quarantined)"	example(failing)
 // @Tags         syringe
 // @Produce      json
-// @Success      200  {object}  main.ResponseWithDomains
+// @Success      200  {object}  main.ResponseWithDomainPage
+// @Failure      400  {object}  main.ResponseError
+// @Security     BearerAuth
 // @Router       /domains [get]
 func HandleDumpDomains(c *gin.Context, dh *DomainHeap) {
-	var domainList []DomainDefinition
-	for _, d := range *dh {
-		domainList = append(domainList, DomainDefinition{Domain: d.Record_name, Type: d.Record_type})
+	limit := DefaultListLimit
+	if query_param := c.Query("limit"); query_param != "" {
+		var err error
+		if limit, err = strconv.Atoi(query_param); err != nil {
+			limit = -1
+		}
+	}
+	domains, total, next, err := dh.ListDomains(domainFilterFromQuery(c), c.Query("sort"), c.Query("cursor"), limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithDomainPage{Message: "success", Domains: domains, Total: total, NextCursor: next})
+}
+
+func domainFilterFromQuery(c *gin.Context) DomainFilter {
+	return DomainFilter{
+		Type:   c.Query("type"),
+		Group:  c.Query("group"),
+		Subnet: c.Query("subnet"),
+		Suffix: c.Query("suffix"),
+		Regex:  c.Query("regex"),
+		Status: c.Query("status"),
 	}
-	c.JSON(http.StatusOK, ResponseWithDomains{Domains: domainList})
 }
 
 // HandleCountDomains godoc
 // @Summary      Return the number of domains in the queue
-// @Description  Responds with the queue size
+// @Description  Responds with the number of queued domains matching the filters
+// @Param 		 type 		query 		string 	false 	"only count entries of this record type"	example(A)
+// @Param 		 group 		query 		string 	false 	"only count entries of this group"	example(critical)
+// @Param 		 subnet 	query 		string 	false 	"only count entries for this client subnet"	example(192.0.2.0/24)
+// @Param 		 suffix 	query 		string 	false 	"only count domains equal to or below this domain"	example(example.com)
+// @Param 		 regex 		query 		string 	false 	"only count domains matching this regular expression"	example(^www\.)
+// @Param 		 status 	query 		string 	false 	"only count entries with this status (ok, pending, failing, quarantined)"	example(failing)
 // @Tags         syringe
 // @Produce      json
 // @Success      200  {object}  main.ResponseWithSize
+// @Failure      400  {object}  main.ResponseError
+// @Security     BearerAuth
 // @Router       /domains/count [get]
 func HandleCountDomains(c *gin.Context, dh *DomainHeap) {
-	c.JSON(http.StatusOK, ResponseWithSize{Message: "success", Size: dh.Len()})
+	filter := domainFilterFromQuery(c)
+	if filter == (DomainFilter{}) {
+		c.JSON(http.StatusOK, ResponseWithSize{Message: "success", Size: dh.QueueSize()})
+		return
+	}
+	size, err := dh.CountDomains(filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithSize{Message: "success", Size: size})
+}
+
+// HandleDomainHistory godoc
+// @Summary      Return the last results and recent query history of a domain
+// @Description  Responds with all queued entries (types, client subnets) matching the domain
+// @Param 		 domain 	query 		string 	true 	"domain name"	example(google.com)
+// @Param 		 type 		query 		string 	false 	"only entries of this rr type"	example(A)
+// @Param 		 subnet 	query 		string 	false 	"only entries for this client subnet"	example(192.0.2.0/24)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithHistory
+// @Failure      400  {object}  main.ResponseError
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /domains/history [get]
+func HandleDomainHistory(c *gin.Context, dh *DomainHeap) {
+	name := LookupDomainName(c.Query("domain"))
+	rr_type := c.Query("type")
+	subnet := c.Query("subnet")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "query argument 'domain' is required",
+		})
+		return
+	}
+
+	var domains []DomainHistoryDefinition
+	// the ready and in-flight domains are included
+	for _, d := range dh.QueuedDomains() {
+		if !strings.EqualFold(d.Record_name, name) ||
+			(rr_type != "" && !strings.EqualFold(d.Record_type, rr_type)) ||
+			(subnet != "" && d.Client_subnet != subnet) {
+			continue
+		}
+		domains = append(domains, DomainHistoryDefinition{
+			Domain:     d.Record_name,
+			Type:       d.Record_type,
+			Subnet:     d.Client_subnet,
+			RefreshAt:  d.Refresh_at,
+			LastResult: d.Last_result,
+			History:    d.HistoryEntries(),
+		})
+	}
+	if len(domains) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": fmt.Sprintf("domain %s is not queued", name),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithHistory{Message: "success", Domains: domains})
+}
+
+// HandleListQuarantine godoc
+// @Summary      Return the quarantined domains
+// @Description  Responds with all domains which failed QuarantineAfterFailures times in a row
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithQuarantine
+// @Security     BearerAuth
+// @Router       /domains/quarantine [get]
+func HandleListQuarantine(c *gin.Context, dh *DomainHeap) {
+	domains := []QuarantinedDomainDefinition{}
+	// the ready and in-flight domains are included
+	for _, d := range dh.QueuedDomains() {
+		if !d.Quarantined {
+			continue
+		}
+		definition := QuarantinedDomainDefinition{
+			Domain:        d.Record_name,
+			Type:          d.Record_type,
+			Subnet:        d.Client_subnet,
+			Failures:      d.Consecutive_failures,
+			QuarantinedAt: d.Quarantined_at,
+			RecheckAt:     d.Refresh_at,
+		}
+		if last, ok := d.LastHistoryEntry(); ok {
+			definition.LastError = last.Error
+		}
+		domains = append(domains, definition)
+	}
+	c.JSON(http.StatusOK, ResponseWithQuarantine{Message: "success", Domains: domains})
+}
+
+// HandleReleaseQuarantine godoc
+// @Summary     Release domains from quarantine
+// @Description Released domains are queried immediately. Without body, all quarantined domains are released. Responds with the number of released domains
+// @Param 		body body main.DomainListDefinition false "domain list"
+// @Tags        syringe
+// @Produce     json
+// @Success     200  {object}  main.ResponseWithSize
+// @Failure     400  {object}  main.ResponseError
+// @Security    BearerAuth
+// @Router      /domains/quarantine/release [post]
+func HandleReleaseQuarantine(c *gin.Context, dh *DomainHeap) {
+	requestBody := &DomainListDefinition{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(requestBody); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": `invalid domain list received. example: {"domains":[{"domain":"google.de","type":"A"}]}`,
+			})
+			return
+		}
+	}
+
+	matches := func(d *Domain) bool {
+		if len(requestBody.Domains) == 0 {
+			return true
+		}
+		for _, definition := range requestBody.Domains {
+			if strings.EqualFold(d.Record_name, LookupDomainName(definition.Domain)) &&
+				(definition.Type == "" || strings.EqualFold(d.Record_type, definition.Type)) &&
+				(len(definition.Subnets) == 0 || slices.Contains(definition.Subnets, d.Client_subnet)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	released := 0
+	HeapDo(dh, func(h *DomainHeap) {
+		for _, d := range *h {
+			if !d.Quarantined || !matches(d) {
+				continue
+			}
+			d.Release()
+			d.RefreshInSeconds(0)
+			released++
+		}
+		heap.Init(h)
+	})
+	log.Info("released ", released, " domains from quarantine")
+	c.JSON(http.StatusOK, ResponseWithSize{Message: "success", Size: released})
 }
 
 // HandleAddDomains godoc
@@ -114,6 +437,7 @@ func HandleCountDomains(c *gin.Context, dh *DomainHeap) {
 // @Produce     json
 // @Success     200  {object}  main.ResponseWithSize
 // @Failure     400  {object}  main.ResponseError
+// @Security    BearerAuth
 // @Router      /domains [post]
 func HandleAddDomains(c *gin.Context, dh *DomainHeap) {
 	requestBody := &DomainListDefinition{}
@@ -127,25 +451,629 @@ func HandleAddDomains(c *gin.Context, dh *DomainHeap) {
 	var domainList []Domain
 	for i := 0; i < len(requestBody.Domains); i++ {
 		// we need to validate the input before we push it onto the heap
-		domain := Domain{Record_name: requestBody.Domains[i].Domain, Record_type: requestBody.Domains[i].Type, Refresh_at: 0}
-		if !domain.Validate() {
+		domain := Domain{Record_name: requestBody.Domains[i].Domain, Record_type: requestBody.Domains[i].Type, Refresh_at: 0, Group: requestBody.Domains[i].Group}
+		group, err := FindGroup(domain.Group)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("invalid domain in domain list. %s for domain %s", err, requestBody.Domains[i].Domain),
+			})
+			return
+		}
+		subnets := requestBody.Domains[i].Subnets
+		if len(subnets) == 0 {
+			subnets = group.ClientSubnets
+		}
+		for _, subnet := range subnets {
+			if err := ValidateClientSubnet(subnet); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("invalid domain in domain list. %s for domain %s", err, requestBody.Domains[i].Domain),
+				})
+				return
+			}
+		}
+		if err := domain.Normalize(); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"message": fmt.Sprintf("invalid domain in domain list. Unknown type %s for domain %s", requestBody.Domains[i].Type, requestBody.Domains[i].Domain),
+				"message": fmt.Sprintf("invalid domain in domain list. %s for domain %s", err, requestBody.Domains[i].Domain),
 			})
 			return
 		}
-		domainList = append(domainList, domain)
+		if err := CheckExcluded(domain.Record_name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": fmt.Sprintf("invalid domain in domain list. %s for domain %s", err, requestBody.Domains[i].Domain),
+			})
+			return
+		}
+		domainList = append(domainList, ExpandClientSubnets(domain, subnets)...)
 	}
 	// append after validating
 	for _, d := range domainList {
-		HeapPush(dh, d)
+		dh.AddDomain(d)
+	}
+	c.JSON(http.StatusOK, ResponseWithSize{Message: "success", Size: dh.QueueSize()})
+}
+
+// HandleImportDomains godoc
+// @Summary     Import domains into the queue
+// @Description Reads one NDJSON domain definition or domains file entry ('<domain> <type> [ecs=<subnet>,...] [group=<name>]') per line. Invalid lines are skipped and reported. Mode merge adds the domains which are not queued yet, mode replace also removes the queued domains which are not imported, unless a line failed. Mode replace requires the admin scope.
+// @Param 		format 	query 		string 	false 	"ndjson or text, defaults to ndjson for json content types and text otherwise"	example(text)
+// @Param 		mode 	query 		string 	false 	"merge (default) or replace"	example(merge)
+// @Param 		body body string true "one domain per line"
+// @Tags        syringe
+// @Accept      application/x-ndjson,plain
+// @Produce     json
+// @Success     200  {object}  main.ResponseWithImport
+// @Failure     400  {object}  main.ResponseWithImport
+// @Security    BearerAuth
+// @Router      /domains/import [post]
+func HandleImportDomains(c *gin.Context, dh *DomainHeap) {
+	format := c.Query("format")
+	if format == "" {
+		format = ImportFormatText
+		if contentType := c.ContentType(); strings.Contains(contentType, "json") {
+			format = ImportFormatNdjson
+		}
+	}
+	result, err := dh.ImportDomains(c.Request.Body, format, c.DefaultQuery("mode", ImportModeMerge))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseWithImport{Message: err.Error(), ImportResult: result})
+		return
+	}
+	log.Info("imported domains: ", result.Added, " added, ", result.Removed, " removed, ", result.Skipped, " skipped, ", result.ErrorsTotal, " invalid")
+	c.JSON(http.StatusOK, ResponseWithImport{Message: "success", ImportResult: result})
+}
+
+// HandleExportDomains godoc
+// @Summary     Export the queued domains
+// @Description Responds with one NDJSON domain definition or domains file entry per line and queue entry, which can be imported by another instance. Derived entries (companion types, targets) are not exported.
+// @Param 		format 	query 		string 	false 	"ndjson (default) or text"	example(text)
+// @Param 		type 	query 		string 	false 	"only export entries of this record type"	example(A)
+// @Param 		group 	query 		string 	false 	"only export entries of this group"	example(critical)
+// @Param 		subnet 	query 		string 	false 	"only export entries for this client subnet"	example(192.0.2.0/24)
+// @Param 		suffix 	query 		string 	false 	"only export domains equal to or below this domain"	example(example.com)
+// @Param 		regex 	query 		string 	false 	"only export domains matching this regular expression"	example(^www\.)
+// @Param 		status 	query 		string 	false 	"only export entries with this status (ok, pending, failing, quarantined)"	example(failing)
+// @Tags        syringe
+// @Produce     application/x-ndjson,plain
+// @Success     200  {object}  main.DomainDefinition
+// @Failure     400  {object}  main.ResponseError
+// @Security    BearerAuth
+// @Router      /domains/export [get]
+func HandleExportDomains(c *gin.Context, dh *DomainHeap) {
+	format := c.DefaultQuery("format", ImportFormatNdjson)
+	if format != ImportFormatNdjson && format != ImportFormatText {
+		c.JSON(http.StatusBadRequest, ResponseError{Message: fmt.Sprintf("unknown format %s (ndjson, text)", format)})
+		return
+	}
+	match, err := domainFilterFromQuery(c).Matcher()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ResponseError{Message: err.Error()})
+		return
+	}
+	if format == ImportFormatNdjson {
+		c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+	}
+	c.Status(http.StatusOK)
+	writer := bufio.NewWriter(c.Writer)
+	for _, domain := range dh.QueuedDomains() {
+		// derived domains are derived again by the importing instance
+		if domain.Parent == "" && match(&domain) {
+			writer.WriteString(ExportLine(format, domain) + "\n")
+		}
+	}
+	writer.Flush()
+}
+
+// HandleRefreshDomains godoc
+// @Summary     Refresh queued domains now
+// @Description Moves the matching domains to the front of the queue. They are dispatched within the rate limits. Responds with a job to track the progress.
+// @Param 		body body main.RefreshSelector true "names, suffix, regex and/or group"
+// @Tags        syringe
+// @Produce     json
+// @Success     200  {object}  main.ResponseWithRefreshJob
+// @Failure     400  {object}  main.ResponseError
+// @Security    BearerAuth
+// @Router      /domains/refresh [post]
+func HandleRefreshDomains(c *gin.Context, dh *DomainHeap) {
+	requestBody := &RefreshSelector{}
+	if err := c.ShouldBindJSON(requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": `invalid refresh request received. example: {"suffix":"example.com","group":"critical"}`,
+		})
+		return
+	}
+	match, err := requestBody.Matcher()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithRefreshJob{Message: "success", Job: dh.ForceRefresh(match)})
+}
+
+// HandleRefreshJob godoc
+// @Summary      Return the progress of a refresh job
+// @Description  Responds with the number of matched and already refreshed domains
+// @Param 		 id 	path 		string 	true 	"job id"	example(refresh-1)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithRefreshJob
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /domains/refresh/{id} [get]
+func HandleRefreshJob(c *gin.Context) {
+	job, ok := refreshJobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "unknown refresh job " + c.Param("id"),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithRefreshJob{Message: "success", Job: job})
+}
+
+// HandleEvents godoc
+// @Summary      Stream events as server-sent events
+// @Description  Streams dispatch, result, error, add and remove events as they happen. Subscribers which don't keep up are disconnected.
+// @Param 		 domain 	query 		string 	false 	"only events of domains matching this shell pattern"	example(*.example.com)
+// @Param 		 group 		query 		string 	false 	"only events of this group"	example(critical)
+// @Param 		 target 	query 		string 	false 	"only events of this target (result and error events)"	example(default)
+// @Param 		 type 		query 		string 	false 	"comma separated event types (dispatch,result,error,add,remove)"	example(result,error)
+// @Tags         syringe
+// @Produce      text/event-stream
+// @Success      200  {object}  main.Event
+// @Failure      400  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /events [get]
+func HandleEvents(c *gin.Context) {
+	filter := EventFilter{
+		DomainPattern: c.Query("domain"),
+		Group:         c.Query("group"),
+		Target:        c.Query("target"),
+	}
+	if _, err := path.Match(filter.DomainPattern, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid domain pattern: " + err.Error(),
+		})
+		return
+	}
+	if types := c.Query("type"); types != "" {
+		for _, eventType := range strings.Split(types, ",") {
+			if !slices.Contains(EventTypes, eventType) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": fmt.Sprintf("unknown event type %s, valid types: %s", eventType, strings.Join(EventTypes, ",")),
+				})
+				return
+			}
+			filter.Types = append(filter.Types, eventType)
+		}
+	}
+
+	ch, unsubscribe := events.Subscribe(filter)
+	defer unsubscribe()
+	// send the headers right away, clients should not wait for the first event
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// HandleListTargets godoc
+// @Summary      Return the configured targets
+// @Description  Responds with the targets and their current cache hit ratio
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithTargets
+// @Security     BearerAuth
+// @Router       /targets [get]
+func HandleListTargets(c *gin.Context) {
+	targetList := []TargetDefinition{}
+	for _, target := range targets {
+		ratio, classified := target.CacheHitRatio()
+		targetList = append(targetList, TargetDefinition{
+			Id:              target.Id,
+			Protocol:        target.Protocol,
+			Address:         target.Address,
+			Dnssec:          target.Dnssec,
+			CacheProbe:      target.CacheProbe,
+			CacheHitRatio:   ratio,
+			CacheClassified: classified,
+		})
+	}
+	c.JSON(http.StatusOK, ResponseWithTargets{Message: "success", Targets: targetList})
+}
+
+// HandleProbeTarget godoc
+// @Summary      Check which fraction of the queued domains is already cached on a target
+// @Description  Sends non-recursive (RD=0) queries, which don't cause recursion, for a sample of the queue. Responds with the cached fraction per group and whether it meets the threshold. Requires the write scope.
+// @Param 		 id 		path 		string 	true 	"target id"		example(default)
+// @Param 		 sample 	query 		int 	false 	"number of domains to probe, 0 probes the whole queue (default ProbeSampleSize)"	minimum(0) example(100)
+// @Param 		 threshold 	query 		number 	false 	"minimum cached fraction to pass (default ProbeThreshold)"	example(0.9)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithProbe
+// @Failure      404  {object}  main.ResponseError
+// @Failure      412  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /targets/{id}/probe [get]
+func HandleProbeTarget(c *gin.Context, dh *DomainHeap) {
+	target, err := FindTarget(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	sample := int(resolverConfiguration.ProbeSampleSize)
+	if query_param_sample := c.Query("sample"); query_param_sample != "" {
+		sample, err = strconv.Atoi(query_param_sample)
+		if err != nil || sample < 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{
+				"message": "query argument 'sample' must be a positive integer or 0",
+			})
+			return
+		}
+	}
+	threshold := resolverConfiguration.ProbeThreshold
+	if query_param_threshold := c.Query("threshold"); query_param_threshold != "" {
+		threshold, err = strconv.ParseFloat(query_param_threshold, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{
+				"message": "query argument 'threshold' must be a number between 0 and 1",
+			})
+			return
+		}
+	}
+
+	var domains []Domain
+	HeapDo(dh, func(h *DomainHeap) {
+		for _, d := range *h {
+			domains = append(domains, *d)
+		}
+	})
+	result, err := ProbeTarget(c.Request.Context(), target, SampleDomains(domains, sample), threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithProbe{Message: "success", Probe: *result})
+}
+
+// HandleDiff godoc
+// @Summary      Return the comparison counts and recent mismatches between reference and candidate target
+// @Description  Responds with the number of matches, mismatches and errors and the most recent mismatches
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithDiff
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /diff [get]
+func HandleDiff(c *gin.Context) {
+	if differ == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "diff mode is not configured (Diff.Reference, Diff.Candidate)",
+		})
+		return
+	}
+	mismatches, counts := differ.Examples()
+	c.JSON(http.StatusOK, ResponseWithDiff{
+		Message:    "success",
+		Reference:  differ.Reference.Id,
+		Candidate:  differ.Candidate.Id,
+		Counts:     counts,
+		Mismatches: mismatches,
+	})
+}
+
+// HandleExportDiff godoc
+// @Summary      Export the recent mismatches between reference and candidate target
+// @Description  Responds with one json object per line
+// @Tags         syringe
+// @Produce      application/x-ndjson
+// @Success      200  {object}  main.AnswerMismatch
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /diff/export [get]
+func HandleExportDiff(c *gin.Context) {
+	if differ == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "diff mode is not configured (Diff.Reference, Diff.Candidate)",
+		})
+		return
+	}
+	mismatches, _ := differ.Examples()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for _, mismatch := range mismatches {
+		encoder.Encode(mismatch)
+	}
+}
+
+// HandleScheduleStatus godoc
+// @Summary      Return the effective rate limits of the groups and the schedule windows
+// @Description  Responds with the state of every group, the open windows (rules and boosts) and the configured rules
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithSchedule
+// @Security     BearerAuth
+// @Router       /schedule [get]
+func HandleScheduleStatus(c *gin.Context) {
+	groupStatus, windows, rules := schedule.Status()
+	c.JSON(http.StatusOK, ResponseWithSchedule{Message: "success", Groups: groupStatus, Windows: windows, Rules: rules})
+}
+
+// HandleStartBoost godoc
+// @Summary      Start a boost window
+// @Description  Changes the rate limit of the groups until the deadline. Responds with the opened window.
+// @Param 		 body body main.BoostDefinition true "boost"
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithWindow
+// @Failure      400  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /schedule/boosts [post]
+func HandleStartBoost(c *gin.Context) {
+	requestBody := &BoostDefinition{}
+	if err := c.ShouldBindJSON(requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": `invalid boost received. example: {"groups":["critical"],"rate_limit":1000,"duration_seconds":3600}`,
+		})
+		return
+	}
+	deadline := time.Unix(requestBody.Deadline, 0)
+	if requestBody.DurationSeconds > 0 {
+		deadline = time.Now().Add(time.Duration(requestBody.DurationSeconds) * time.Second)
+	}
+	window, err := schedule.Boost(requestBody.Groups, requestBody.RateLimit, deadline)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithWindow{Message: "success", Window: window})
+}
+
+// HandleEndBoost godoc
+// @Summary      End a boost window before its deadline
+// @Param 		 id 	path 		string 	true 	"boost id"	example(boost-1)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.Response
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /schedule/boosts/{id} [delete]
+func HandleEndBoost(c *gin.Context) {
+	if !schedule.EndBoost(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "unknown boost " + c.Param("id"),
+		})
+		return
 	}
-	c.JSON(http.StatusOK, ResponseWithSize{Message: "success", Size: dh.Len()})
+	c.JSON(http.StatusOK, Response{Message: "success"})
+}
+
+// HandleListSubscriptions godoc
+// @Summary      List the subscription rules
+// @Description  Responds with the rules enrolling learned names and the number of names each rule enrolled
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithSubscriptions
+// @Security     BearerAuth
+// @Router       /subscriptions [get]
+func HandleListSubscriptions(c *gin.Context) {
+	c.JSON(http.StatusOK, ResponseWithSubscriptions{Message: "success", Subscriptions: subscriptions.List()})
+}
+
+// HandleAddSubscription godoc
+// @Summary      Add a subscription rule
+// @Description  The rule does not query on its own, learned names matching its pattern are enrolled with its types and group. Responds with the added rule.
+// @Param 		 body body main.SubscriptionDefinition true "subscription"
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithSubscription
+// @Failure      400  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /subscriptions [post]
+func HandleAddSubscription(c *gin.Context) {
+	requestBody := &SubscriptionDefinition{}
+	if err := c.ShouldBindJSON(requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": `invalid subscription received. example: {"pattern":"*.corp.example","types":["A","AAAA"],"group":"corp"}`,
+		})
+		return
+	}
+	subscription, err := subscriptions.Add(*requestBody, SubscriptionSourceApi)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithSubscription{Message: "success", Subscription: subscription})
+}
+
+// HandleRemoveSubscription godoc
+// @Summary      Remove a subscription rule
+// @Description  The names enrolled by the rule stay queued
+// @Param 		 id 	path 		string 	true 	"subscription id"	example(subscription-1)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.Response
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /subscriptions/{id} [delete]
+func HandleRemoveSubscription(c *gin.Context) {
+	if !subscriptions.Remove(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "unknown subscription " + c.Param("id"),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Message: "success"})
+}
+
+// HandleLearnDomains godoc
+// @Summary      Learn observed names
+// @Description  Enrolls the names matching a subscription rule with the types, group and subnets of the rule. Responds with the number of names by outcome.
+// @Param 		 body body main.LearnDefinition true "observed names"
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithLearn
+// @Failure      400  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /domains/learn [post]
+func HandleLearnDomains(c *gin.Context, dh *DomainHeap) {
+	requestBody := &LearnDefinition{}
+	if err := c.ShouldBindJSON(requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": `invalid names received. example: {"names":["host1.corp.example"]}`,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseWithLearn{Message: "success", LearnResult: dh.Learn(requestBody.Names)})
+}
+
+// schedulerControlTarget returns the target of the target query parameter, nil
+// if not set. Responds with 404 and returns false if the target is unknown.
+func schedulerControlTarget(c *gin.Context) (*Target, bool) {
+	id := c.Query("target")
+	if id == "" {
+		return nil, true
+	}
+	target, err := FindTarget(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	return target, true
+}
+
+func schedulerStateResponse(target *Target) ResponseWithSchedulerState {
+	inFlight := scheduler.InFlight()
+	if target != nil {
+		inFlight = target.InFlight()
+	}
+	return ResponseWithSchedulerState{Message: "success", SchedulerState: CurrentSchedulerState(), InFlight: inFlight}
+}
+
+// HandleSchedulerState godoc
+// @Summary      Return the pause state of the scheduler and the targets
+// @Description  Responds with whether dispatching is paused, the paused targets and the refreshes in flight
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithSchedulerState
+// @Security     BearerAuth
+// @Router       /scheduler [get]
+func HandleSchedulerState(c *gin.Context) {
+	c.JSON(http.StatusOK, schedulerStateResponse(nil))
+}
+
+// HandlePauseScheduler godoc
+// @Summary      Pause sending refreshes
+// @Description  Stops dispatching refreshes (or only sending them to the target). In-flight refreshes finish. The state survives restarts if a StateFile is configured.
+// @Param 		 target 	query 		string 	false 	"only pause this target"	example(resolver1)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithSchedulerState
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /scheduler/pause [post]
+func HandlePauseScheduler(c *gin.Context) {
+	target, ok := schedulerControlTarget(c)
+	if !ok {
+		return
+	}
+	Pause(target)
+	c.JSON(http.StatusOK, schedulerStateResponse(target))
+}
+
+// HandleResumeScheduler godoc
+// @Summary      Resume sending refreshes
+// @Description  Continues dispatching refreshes (or sending them to the target). Domains of the groups using the target which became due while paused are spread out at the rate limit of their group.
+// @Param 		 target 	query 		string 	false 	"only resume this target"	example(resolver1)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithSchedulerState
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /scheduler/resume [post]
+func HandleResumeScheduler(c *gin.Context) {
+	target, ok := schedulerControlTarget(c)
+	if !ok {
+		return
+	}
+	spread := Resume(target)
+	response := schedulerStateResponse(target)
+	response.Spread = spread
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleDrainScheduler godoc
+// @Summary      Pause sending refreshes and wait for the in-flight refreshes
+// @Description  Pauses like /scheduler/pause and responds once no refreshes (to the target) are in flight or the timeout expired
+// @Param 		 target 			query 		string 	false 	"only drain this target"	example(resolver1)
+// @Param 		 timeout_seconds 	query 		int 	false 	"give up waiting after value seconds (default 30)"	example(30)
+// @Tags         syringe
+// @Produce      json
+// @Success      200  {object}  main.ResponseWithDrain
+// @Failure      400  {object}  main.ResponseError
+// @Failure      404  {object}  main.ResponseError
+// @Security     BearerAuth
+// @Router       /scheduler/drain [post]
+func HandleDrainScheduler(c *gin.Context) {
+	target, ok := schedulerControlTarget(c)
+	if !ok {
+		return
+	}
+	timeout := 30
+	if query_param := c.Query("timeout_seconds"); query_param != "" {
+		var err error
+		timeout, err = strconv.Atoi(query_param)
+		if err != nil || timeout <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "timeout_seconds must be a positive integer",
+			})
+			return
+		}
+	}
+	Pause(target)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	result := Drain(ctx, target)
+	c.JSON(http.StatusOK, ResponseWithDrain{Message: "success", SchedulerState: CurrentSchedulerState(), Drain: result})
 }
 
 func SetupRouter() *gin.Engine {
 	router := gin.Default()
+	router.GET("/healthz", HandleHealthz)
+	router.GET("/readyz", HandleReadyz)
 	v1 := router.Group("/api/v1")
+	v1.Use(ApiAuth(""))
 	{
 		v1.GET("/domains", func(c *gin.Context) {
 			HandleDumpDomains(c, dh)
@@ -153,12 +1081,51 @@ func SetupRouter() *gin.Engine {
 		v1.GET("/domains/count", func(c *gin.Context) {
 			HandleCountDomains(c, dh)
 		})
+		v1.GET("/domains/history", func(c *gin.Context) {
+			HandleDomainHistory(c, dh)
+		})
+		v1.GET("/domains/quarantine", func(c *gin.Context) {
+			HandleListQuarantine(c, dh)
+		})
+		v1.POST("/domains/quarantine/release", func(c *gin.Context) {
+			HandleReleaseQuarantine(c, dh)
+		})
 		v1.POST("/domains/random", func(c *gin.Context) {
 			HandleLoadRandomDomains(c, dh)
 		})
 		v1.POST("/domains", func(c *gin.Context) {
 			HandleAddDomains(c, dh)
 		})
+		v1.POST("/domains/import", func(c *gin.Context) {
+			HandleImportDomains(c, dh)
+		})
+		v1.GET("/domains/export", func(c *gin.Context) {
+			HandleExportDomains(c, dh)
+		})
+		v1.POST("/domains/learn", func(c *gin.Context) {
+			HandleLearnDomains(c, dh)
+		})
+		v1.POST("/domains/refresh", func(c *gin.Context) {
+			HandleRefreshDomains(c, dh)
+		})
+		v1.GET("/domains/refresh/:id", HandleRefreshJob)
+		v1.GET("/targets", HandleListTargets)
+		v1.GET("/events", HandleEvents)
+		v1.GET("/diff", HandleDiff)
+		v1.GET("/diff/export", HandleExportDiff)
+		v1.GET("/targets/:id/probe", func(c *gin.Context) {
+			HandleProbeTarget(c, dh)
+		})
+		v1.GET("/subscriptions", HandleListSubscriptions)
+		v1.POST("/subscriptions", HandleAddSubscription)
+		v1.DELETE("/subscriptions/:id", HandleRemoveSubscription)
+		v1.GET("/schedule", HandleScheduleStatus)
+		v1.POST("/schedule/boosts", HandleStartBoost)
+		v1.DELETE("/schedule/boosts/:id", HandleEndBoost)
+		v1.GET("/scheduler", HandleSchedulerState)
+		v1.POST("/scheduler/pause", HandlePauseScheduler)
+		v1.POST("/scheduler/resume", HandleResumeScheduler)
+		v1.POST("/scheduler/drain", HandleDrainScheduler)
 	}
 
 	return router
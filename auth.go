@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Scopes are hierarchical, a token with scope write may also read and a
+// token with scope admin may do everything
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var scopeLevels = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// adminRoutes - routes which require the admin scope, other GET routes require
+// read (except writeRoutes), all other routes write
+var adminRoutes = []string{
+	"POST /api/v1/domains/quarantine/release",
+	"POST /api/v1/schedule/boosts",
+	"DELETE /api/v1/schedule/boosts/:id",
+	"POST /api/v1/scheduler/pause",
+	"POST /api/v1/scheduler/resume",
+	"POST /api/v1/scheduler/drain",
+}
+
+// writeRoutes - GET routes which send queries and require the write scope
+var writeRoutes = []string{
+	"GET /api/v1/targets/:id/probe",
+}
+
+var apiAuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "syringe",
+	Name:      "api_auth_failures_total",
+	Help:      "The total number of rejected api requests by reason (missing, invalid, forbidden, certificate)",
+},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.Register(apiAuthFailures)
+}
+
+// ApiTokenConfiguration - an api token, only the sha256 hash of the token is configured
+type ApiTokenConfiguration struct {
+	Name string `yaml:"Name"`
+	// Hash - 'sha256:<hex>' of the token, see 'syringe token'
+	Hash   string   `yaml:"Hash"`
+	Scopes []string `yaml:"Scopes"`
+}
+
+// ApiToken - a configured token
+type ApiToken struct {
+	Name  string
+	hash  []byte
+	level int
+}
+
+// apiTokens - no tokens means the api is open
+var apiTokens []ApiToken
+
+// SetupApiTokens builds the tokens of the configuration and the ApiTokenFile
+func SetupApiTokens(config *ResolverConfiguration) ([]ApiToken, error) {
+	tokenConfigurations := slices.Clone(config.ApiTokens)
+	if config.ApiTokenFile != "" {
+		data, err := os.ReadFile(config.ApiTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		var fileTokens []ApiTokenConfiguration
+		if err := yaml.Unmarshal(data, &fileTokens); err != nil {
+			return nil, fmt.Errorf("%s: %w", config.ApiTokenFile, err)
+		}
+		tokenConfigurations = append(tokenConfigurations, fileTokens...)
+	}
+
+	var tokens []ApiToken
+	for i, tc := range tokenConfigurations {
+		if tc.Name == "" {
+			tc.Name = fmt.Sprintf("token-%d", i)
+		}
+		hexHash, ok := strings.CutPrefix(tc.Hash, "sha256:")
+		hash, err := hex.DecodeString(hexHash)
+		if !ok || err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("api token %s: Hash must be 'sha256:<64 hex digits>'", tc.Name)
+		}
+		token := ApiToken{Name: tc.Name, hash: hash}
+		for _, scope := range tc.Scopes {
+			level, ok := scopeLevels[scope]
+			if !ok {
+				return nil, fmt.Errorf("api token %s: unknown scope %s (read, write, admin)", tc.Name, scope)
+			}
+			token.level = max(token.level, level)
+		}
+		if token.level == 0 {
+			return nil, fmt.Errorf("api token %s has no scopes", tc.Name)
+		}
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		log.Warn("no ApiTokens configured, the api is not protected")
+	}
+	return tokens, nil
+}
+
+// HashApiToken returns the configuration value for a token
+func HashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// FindApiToken returns the configured token matching the presented token
+func FindApiToken(presented string) (ApiToken, bool) {
+	sum := sha256.Sum256([]byte(presented))
+	for _, token := range apiTokens {
+		if subtle.ConstantTimeCompare(sum[:], token.hash) == 1 {
+			return token, true
+		}
+	}
+	return ApiToken{}, false
+}
+
+// RequiredScope returns the scope a request to the route needs. An import in
+// mode replace, which removes domains, requires admin.
+func RequiredScope(method string, route string, query url.Values) string {
+	if slices.Contains(adminRoutes, method+" "+route) {
+		return ScopeAdmin
+	}
+	if method+" "+route == "POST /api/v1/domains/import" && query.Get("mode") == ImportModeReplace {
+		return ScopeAdmin
+	}
+	if slices.Contains(writeRoutes, method+" "+route) {
+		return ScopeWrite
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// ApiAuth rejects requests without a bearer token having the scope required
+// by the route. If fixedScope is set, it is required for every route.
+func ApiAuth(fixedScope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(apiTokens) == 0 {
+			return
+		}
+		scope := fixedScope
+		if scope == "" {
+			scope = RequiredScope(c.Request.Method, c.FullPath(), c.Request.URL.Query())
+		}
+
+		presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || presented == "" {
+			rejectApiRequest(c, http.StatusUnauthorized, "missing", "missing bearer token")
+			return
+		}
+		token, ok := FindApiToken(presented)
+		if !ok {
+			rejectApiRequest(c, http.StatusUnauthorized, "invalid", "invalid bearer token")
+			return
+		}
+		if token.level < scopeLevels[scope] {
+			rejectApiRequest(c, http.StatusForbidden, "forbidden", fmt.Sprintf("token %s lacks scope %s", token.Name, scope))
+			return
+		}
+		c.Set("api_token", token.Name)
+	}
+}
+
+func rejectApiRequest(c *gin.Context, status int, reason string, message string) {
+	log.Warn("rejected api request ", c.Request.Method, " ", c.Request.URL.Path, " from ", c.ClientIP(), ": ", message)
+	apiAuthFailures.With(prometheus.Labels{"reason": reason}).Inc()
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Bearer realm="syringe"`)
+	}
+	c.AbortWithStatusJSON(status, ResponseError{Message: message})
+}
+
+// RunTokenCommand generates a random token and prints it together with the
+// configuration entry holding its hash
+func RunTokenCommand(args []string) int {
+	if len(args) < 1 {
+		log.Error("usage: syringe token <name> [scope ...] (scopes: read, write, admin - default read)")
+		return 2
+	}
+	scopes := args[1:]
+	if len(scopes) == 0 {
+		scopes = []string{ScopeRead}
+	}
+	for _, scope := range scopes {
+		if _, ok := scopeLevels[scope]; !ok {
+			log.Error("unknown scope ", scope)
+			return 2
+		}
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		log.Error(err)
+		return 2
+	}
+	token := hex.EncodeToString(random)
+	entry, _ := yaml.Marshal([]ApiTokenConfiguration{{Name: args[0], Hash: HashApiToken(token), Scopes: scopes}})
+	fmt.Fprintln(os.Stdout, "token: "+token)
+	fmt.Fprintln(os.Stdout, "add to ApiTokens (or the ApiTokenFile):")
+	fmt.Fprint(os.Stdout, string(entry))
+	return 0
+}
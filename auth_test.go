@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		route  string
+		query  string
+		want   string
+	}{
+		{"GET", "/api/v1/domains", "", ScopeRead},
+		{"POST", "/api/v1/domains", "", ScopeWrite},
+		{"GET", "/api/v1/targets/:id/probe", "", ScopeWrite},
+		{"POST", "/api/v1/domains/import", "", ScopeWrite},
+		{"POST", "/api/v1/domains/import", "mode=merge", ScopeWrite},
+		{"POST", "/api/v1/domains/import", "mode=replace", ScopeAdmin},
+		{"POST", "/api/v1/scheduler/pause", "", ScopeAdmin},
+		{"DELETE", "/api/v1/schedule/boosts/:id", "", ScopeAdmin},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		if got := RequiredScope(test.method, test.route, query); got != test.want {
+			t.Errorf("RequiredScope(%s %s?%s) = %s, want %s", test.method, test.route, test.query, got, test.want)
+		}
+	}
+}
+
+func TestSetupApiTokens(t *testing.T) {
+	config := *resolverConfiguration
+	config.ApiTokens = []ApiTokenConfiguration{{Name: "reader", Hash: HashApiToken("secret"), Scopes: []string{ScopeRead}}}
+	config.ApiTokenFile = filepath.Join(t.TempDir(), "tokens.yml")
+	file := "- Name: operator\n  Hash: " + HashApiToken("other") + "\n  Scopes: [read, admin]\n"
+	if err := os.WriteFile(config.ApiTokenFile, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := SetupApiTokens(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].level != scopeLevels[ScopeRead] || tokens[1].Name != "operator" || tokens[1].level != scopeLevels[ScopeAdmin] {
+		t.Errorf("tokens = %+v", tokens)
+	}
+
+	invalid := []ApiTokenConfiguration{
+		{Name: "plain", Hash: "secret", Scopes: []string{ScopeRead}},
+		{Name: "short", Hash: "sha256:abcd", Scopes: []string{ScopeRead}},
+		{Name: "unknown scope", Hash: HashApiToken("secret"), Scopes: []string{"root"}},
+		{Name: "no scopes", Hash: HashApiToken("secret")},
+	}
+	for _, tc := range invalid {
+		config := *resolverConfiguration
+		config.ApiTokens = []ApiTokenConfiguration{tc}
+		if _, err := SetupApiTokens(&config); err == nil {
+			t.Errorf("token %s was accepted", tc.Name)
+		}
+	}
+}
+
+// withApiTokens protects the api with a read, a write and an admin token
+func withApiTokens(t *testing.T) {
+	previous := apiTokens
+	config := *resolverConfiguration
+	config.ApiTokenFile = ""
+	config.ApiTokens = []ApiTokenConfiguration{
+		{Name: "reader", Hash: HashApiToken("read-token"), Scopes: []string{ScopeRead}},
+		{Name: "writer", Hash: HashApiToken("write-token"), Scopes: []string{ScopeWrite}},
+		{Name: "admin", Hash: HashApiToken("admin-token"), Scopes: []string{ScopeAdmin}},
+	}
+	tokens, err := SetupApiTokens(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiTokens = tokens
+	t.Cleanup(func() { apiTokens = previous })
+}
+
+// apiRequest sends a request to the api router and returns the recorded response
+func apiRequest(t *testing.T, method string, target string, body string, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	SetupRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestApiAuth(t *testing.T) {
+	resetQueue(t)
+	withApiTokens(t)
+	tests := []struct {
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{"GET", "/api/v1/domains/count", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/domains/count", "wrong-token", http.StatusUnauthorized},
+		{"GET", "/api/v1/domains/count", "read-token", http.StatusOK},
+		{"GET", "/api/v1/targets/unknown/probe", "read-token", http.StatusForbidden},
+		{"GET", "/api/v1/targets/unknown/probe", "write-token", http.StatusNotFound},
+		{"POST", "/api/v1/domains/import?mode=merge", "read-token", http.StatusForbidden},
+		{"POST", "/api/v1/domains/import?mode=merge", "write-token", http.StatusOK},
+		{"POST", "/api/v1/domains/import?mode=replace", "write-token", http.StatusForbidden},
+		{"POST", "/api/v1/domains/import?mode=replace", "admin-token", http.StatusOK},
+		{"GET", "/healthz", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := apiRequest(t, test.method, test.target, "", test.token)
+		if w.Code != test.want {
+			t.Errorf("%s %s with %q: status %d, want %d (%s)", test.method, test.target, test.token, w.Code, test.want, w.Body)
+		}
+	}
+}
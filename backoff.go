@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxBackoffDoublings - the delay stops doubling after this many failures,
+// which bounds it without BackoffMaxSeconds
+const maxBackoffDoublings = 16
+
+var (
+	quarantinedDomains = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "quarantined_domains",
+		Help:      "The current number of quarantined domains",
+	})
+	domainQueryFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "domain_query_failures_total",
+		Help:      "The total number of refreshes in which no target resolved the domain",
+	})
+)
+
+func init() {
+	prometheus.Register(quarantinedDomains)
+	prometheus.Register(domainQueryFailures)
+}
+
+// RecordSuccess resets the failure counter and releases the domain from quarantine
+func (domain *Domain) RecordSuccess() {
+	if domain.Quarantined {
+		log.WithContext(domain.traceContext()).Info("releasing ", domain.ToString(), " from quarantine after successful recheck")
+	}
+	domain.Release()
+}
+
+// RecordFailure counts a failed refresh and returns the delay until the next
+// attempt. The delay starts at fallback_ttl (or StaticDelaySeconds) and doubles
+// with every consecutive failure up to BackoffMaxSeconds (at most
+// maxBackoffDoublings times). After
+// QuarantineAfterFailures failures the domain is only rechecked every
+// QuarantineRecheckSeconds.
+func (domain *Domain) RecordFailure(config *ResolverConfiguration, fallback_ttl uint) uint {
+	domain.Consecutive_failures++
+	domainQueryFailures.Inc()
+
+	if config.QuarantineAfterFailures > 0 && domain.Consecutive_failures >= config.QuarantineAfterFailures {
+		if !domain.Quarantined {
+			domain.Quarantined = true
+			domain.Quarantined_at = time.Now().UnixMilli()
+			quarantinedDomains.Inc()
+			log.WithContext(domain.traceContext()).Warn("quarantining ", domain.ToString(), " after ", domain.Consecutive_failures, " consecutive failures")
+		}
+		return config.QuarantineRecheckSeconds
+	}
+
+	delay := fallback_ttl
+	if delay == 0 {
+		delay = config.StaticDelaySeconds
+	}
+	for i := uint(1); i < domain.Consecutive_failures && i <= maxBackoffDoublings; i++ {
+		delay *= 2
+		if config.BackoffMaxSeconds > 0 && delay >= config.BackoffMaxSeconds {
+			return config.BackoffMaxSeconds
+		}
+	}
+	if config.BackoffMaxSeconds > 0 && delay > config.BackoffMaxSeconds {
+		return config.BackoffMaxSeconds
+	}
+	return delay
+}
+
+// Dropped updates the quarantine gauge and the refresh jobs for a domain
+// which leaves the queue
+func (domain Domain) Dropped() {
+	if domain.Quarantined {
+		quarantinedDomains.Dec()
+	}
+	refreshJobs.Complete(&domain)
+}
+
+// Release resets the failure state of the domain
+func (domain *Domain) Release() {
+	if domain.Quarantined {
+		quarantinedDomains.Dec()
+	}
+	domain.Consecutive_failures = 0
+	domain.Quarantined = false
+	domain.Quarantined_at = 0
+}
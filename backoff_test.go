@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordFailureDoublesTheDelay(t *testing.T) {
+	config := *resolverConfiguration
+	config.BackoffMaxSeconds = 100
+	config.StaticDelaySeconds = 30
+	config.QuarantineAfterFailures = 0
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+	for _, want := range []uint{10, 20, 40, 80, 100, 100} {
+		if got := domain.RecordFailure(&config, 10); got != want {
+			t.Errorf("delay after %d failures = %d, want %d", domain.Consecutive_failures, got, want)
+		}
+	}
+	domain.RecordSuccess()
+	if got := domain.RecordFailure(&config, 0); got != config.StaticDelaySeconds {
+		t.Errorf("delay after a success = %d, want StaticDelaySeconds (%d)", got, config.StaticDelaySeconds)
+	}
+}
+
+func TestRecordFailureWithoutBackoffMaximum(t *testing.T) {
+	config := *resolverConfiguration
+	config.BackoffMaxSeconds = 0
+	config.QuarantineAfterFailures = 0
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+	var delay uint
+	for i := 0; i < 200; i++ {
+		delay = domain.RecordFailure(&config, 10)
+	}
+	if want := uint(10) << maxBackoffDoublings; delay != want {
+		t.Errorf("delay after 200 failures = %d, want %d", delay, want)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	config := *resolverConfiguration
+	config.QuarantineAfterFailures = 3
+	config.QuarantineRecheckSeconds = 86400
+	before := testutil.ToFloat64(quarantinedDomains)
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+
+	domain.RecordFailure(&config, 10)
+	domain.RecordFailure(&config, 10)
+	if domain.Quarantined {
+		t.Fatal("quarantined before QuarantineAfterFailures failures")
+	}
+	if got := domain.RecordFailure(&config, 10); got != 86400 || !domain.Quarantined {
+		t.Fatalf("delay = %d, quarantined = %v, want the recheck delay of a quarantined domain", got, domain.Quarantined)
+	}
+	domain.RecordFailure(&config, 10)
+	if got := testutil.ToFloat64(quarantinedDomains) - before; got != 1 {
+		t.Errorf("quarantined domains = %v, want 1", got)
+	}
+	domain.RecordSuccess()
+	if domain.Quarantined || domain.Consecutive_failures != 0 {
+		t.Errorf("a successful recheck did not release the domain")
+	}
+	if got := testutil.ToFloat64(quarantinedDomains) - before; got != 0 {
+		t.Errorf("quarantined domains after the release = %v, want 0", got)
+	}
+}
+
+func TestRemovingAQuarantinedDomainUpdatesTheGauge(t *testing.T) {
+	resetQueue(t)
+	config := *resolverConfiguration
+	config.QuarantineAfterFailures = 1
+	before := testutil.ToFloat64(quarantinedDomains)
+
+	quarantined := Domain{Record_name: "quarantined.example", Record_type: "A"}
+	quarantined.RecordFailure(&config, 10)
+	ready := Domain{Record_name: "ready.example", Record_type: "A"}
+	ready.RecordFailure(&config, 10)
+	if err := dh.AddDomain(quarantined); err != nil {
+		t.Fatal(err)
+	}
+	// a quarantined copy outside of the heap is dropped by the scheduler
+	scheduler.ready[DefaultGroupName] = []Domain{ready}
+	scheduler.mu.Lock()
+	scheduler.outside[ready.Key()] = ready
+	scheduler.mu.Unlock()
+	if got := testutil.ToFloat64(quarantinedDomains) - before; got != 2 {
+		t.Fatalf("quarantined domains = %v, want 2", got)
+	}
+
+	dh.RemoveDomains(func(d *Domain) bool { return true })
+	if got := testutil.ToFloat64(quarantinedDomains) - before; got != 1 {
+		t.Errorf("quarantined domains after the removal = %v, want the ready one (1)", got)
+	}
+	scheduler.next(0)
+	if got := testutil.ToFloat64(quarantinedDomains) - before; got != 0 {
+		t.Errorf("quarantined domains after the scheduler dropped the ready one = %v, want 0", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+var (
+	cacheResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "target_cache_responses_total",
+		Help:      "The total number of responses classified as served from cache (hit) or recursed (miss)",
+	},
+		[]string{"target", "result"},
+	)
+	cacheHitRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "target_cache_hit_ratio",
+		Help:      "The ratio of cache hits within the last CacheHitRatioWindow classified responses",
+	},
+		[]string{"target"},
+	)
+)
+
+func init() {
+	prometheus.Register(cacheResponses)
+	prometheus.Register(cacheHitRatio)
+}
+
+// CacheWindow - a sliding window over the last cache classifications of a target
+type CacheWindow struct {
+	mu      sync.Mutex
+	results []bool
+	next    int
+	hits    int
+}
+
+func NewCacheWindow(size uint) *CacheWindow {
+	if size == 0 {
+		size = 1
+	}
+	return &CacheWindow{results: make([]bool, 0, size)}
+}
+
+// Add records a classification and returns the current hit ratio
+func (w *CacheWindow) Add(hit bool) float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.results) < cap(w.results) {
+		w.results = append(w.results, hit)
+	} else {
+		if w.results[w.next] {
+			w.hits--
+		}
+		w.results[w.next] = hit
+		w.next = (w.next + 1) % len(w.results)
+	}
+	if hit {
+		w.hits++
+	}
+	return float64(w.hits) / float64(len(w.results))
+}
+
+// Ratio returns the hit ratio and the number of classifications it is based on
+func (w *CacheWindow) Ratio() (float64, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.results) == 0 {
+		return 0, 0
+	}
+	return float64(w.hits) / float64(len(w.results)), len(w.results)
+}
+
+// ProbeCache sends a non-recursive (RD=0) query for the domain, a resolver
+// only answers it from its cache. Returns true if the answer was cached.
+func (domain *Domain) ProbeCache(target *Target) (bool, error) {
+	m, err := target.NewDomainQuery(domain)
+	if err != nil {
+		return false, err
+	}
+	m.RecursionDesired = false
+	r, _, err := target.Exchange(domain.traceContext(), domain.GroupName(), m)
+	if err != nil {
+		return false, err
+	}
+	return r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0, nil
+}
+
+// TrackCache classifies whether the target answered r from its cache.
+// Heuristics in order: the result of a non-recursive probe, an answer ttl
+// below the highest ttl observed earlier (the cached record is aging) and a
+// response time below the latency threshold of the target.
+func (domain *Domain) TrackCache(target *Target, r *dns.Msg, rtt time.Duration, probed bool, probe_hit bool) {
+	answer_ttl, answers := uint(0), 0
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		if answers == 0 || uint(rr.Header().Ttl) > answer_ttl {
+			answer_ttl = uint(rr.Header().Ttl)
+		}
+		answers++
+	}
+	if answers == 0 {
+		return
+	}
+	if domain.Original_ttl == nil {
+		domain.Original_ttl = make(map[string]uint)
+	}
+	original_ttl, seen := domain.Original_ttl[target.Id]
+	if !seen || answer_ttl > original_ttl {
+		domain.Original_ttl[target.Id] = answer_ttl
+	}
+
+	var hit bool
+	switch {
+	case probed:
+		hit = probe_hit
+	case seen && answer_ttl != original_ttl:
+		hit = answer_ttl < original_ttl
+	default:
+		hit = rtt < target.CacheHitLatency
+	}
+
+	domain.cache = CacheMiss
+	if hit {
+		domain.cache = CacheHit
+	}
+	cacheResponses.With(prometheus.Labels{"target": target.Id, "result": domain.cache}).Inc()
+	cacheHitRatio.With(prometheus.Labels{"target": target.Id}).Set(target.cacheWindow.Add(hit))
+}
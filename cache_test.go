@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	dns "github.com/miekg/dns"
+)
+
+func TestCacheWindowSlides(t *testing.T) {
+	w := NewCacheWindow(4)
+	for _, hit := range []bool{true, true, false, false} {
+		w.Add(hit)
+	}
+	if ratio, n := w.Ratio(); ratio != 0.5 || n != 4 {
+		t.Errorf("ratio = %v of %d, want 0.5 of 4", ratio, n)
+	}
+	// the two hits leave the window
+	w.Add(false)
+	if ratio := w.Add(false); ratio != 0 {
+		t.Errorf("ratio = %v, want 0", ratio)
+	}
+	if ratio, n := NewCacheWindow(0).Ratio(); ratio != 0 || n != 0 {
+		t.Errorf("ratio of an empty window = %v of %d", ratio, n)
+	}
+}
+
+func TestProbeCacheSendsNonRecursiveQueries(t *testing.T) {
+	address := startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		// only cached.example is in the cache
+		if !r.RecursionDesired && r.Question[0].Name == "cached.example." {
+			m.Answer = append(m.Answer, mustRR(t, "cached.example. 300 IN A 192.0.2.1"))
+		}
+		w.WriteMsg(m)
+	})
+	target := newTestTarget(t, TargetConfiguration{Address: address})
+
+	for name, want := range map[string]bool{"cached.example": true, "uncached.example": false} {
+		domain := &Domain{Record_name: name, Record_type: "A"}
+		hit, err := domain.ProbeCache(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hit != want {
+			t.Errorf("ProbeCache(%s) = %v, want %v", name, hit, want)
+		}
+	}
+}
+
+func TestTrackCacheClassifiesResponses(t *testing.T) {
+	target := newTestTarget(t, TargetConfiguration{Id: "track-cache", CacheHitLatencyMilliseconds: 5})
+	response := func(ttl string) *dns.Msg {
+		return &dns.Msg{Answer: []dns.RR{mustRR(t, "example.com. "+ttl+" IN A 192.0.2.1")}}
+	}
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+	tests := []struct {
+		name      string
+		r         *dns.Msg
+		rtt       time.Duration
+		probed    bool
+		probe_hit bool
+		want      string
+	}{
+		{"slow first answer", response("300"), 50 * time.Millisecond, false, false, CacheMiss},
+		{"aging ttl", response("250"), 50 * time.Millisecond, false, false, CacheHit},
+		{"fast answer with the original ttl", response("300"), time.Millisecond, false, false, CacheHit},
+		{"probe miss beats the aging ttl", response("100"), time.Millisecond, true, false, CacheMiss},
+	}
+	for _, test := range tests {
+		domain.cache = ""
+		domain.TrackCache(target, test.r, test.rtt, test.probed, test.probe_hit)
+		if domain.cache != test.want {
+			t.Errorf("%s: classified as %q, want %q", test.name, domain.cache, test.want)
+		}
+	}
+	if domain.Original_ttl[target.Id] != 300 {
+		t.Errorf("original ttl = %d, want the highest ttl seen (300)", domain.Original_ttl[target.Id])
+	}
+	if ratio, n := target.cacheWindow.Ratio(); n != 4 || ratio != 0.5 {
+		t.Errorf("hit ratio = %v of %d, want 0.5 of 4", ratio, n)
+	}
+
+	domain.cache = ""
+	domain.TrackCache(target, &dns.Msg{}, time.Millisecond, false, false)
+	if domain.cache != "" {
+		t.Errorf("an empty answer was classified as %q", domain.cache)
+	}
+}
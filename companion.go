@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of derived domains, see Domain.Derived
+const (
+	DerivedCompanion = "companion"
+	DerivedTarget    = "target"
+)
+
+// targetTypes - the types enrolled for names derived from HTTPS, SVCB and MX answers
+var targetTypes = []string{"A", "AAAA"}
+
+var derivedDomains = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "syringe",
+	Name:      "derived_domains_total",
+	Help:      "The total number of enrolled derived domains by kind (companion, target)",
+},
+	[]string{"kind"},
+)
+
+func init() {
+	prometheus.Register(derivedDomains)
+}
+
+// AddCompanions enrolls the companion types of the group of an explicitly
+// added domain, unless they are queued already
+func (dh *DomainHeap) AddCompanions(parent Domain) {
+	group, err := FindGroup(parent.Group)
+	if err != nil {
+		return
+	}
+	for _, rr_type := range group.CompanionTypes {
+		if rr_type == parent.Record_type {
+			continue
+		}
+		companion := Domain{
+			Record_name:   parent.Record_name,
+			Record_type:   rr_type,
+			Refresh_at:    parent.Refresh_at,
+			Client_subnet: parent.Client_subnet,
+			Group:         parent.Group,
+			Parent:        parent.Key(),
+			Derived:       DerivedCompanion,
+		}
+		if dh.addDerived(companion) {
+			derivedDomains.With(prometheus.Labels{"kind": DerivedCompanion}).Inc()
+		}
+	}
+}
+
+// AnswerTargets returns the target names of the HTTPS and SVCB records and
+// the exchanges of the MX records of a response
+func AnswerTargets(response *dns.Msg) []string {
+	if response == nil {
+		return nil
+	}
+	var names []string
+	for _, rr := range response.Answer {
+		var name string
+		switch record := rr.(type) {
+		case *dns.HTTPS:
+			name = record.Target
+		case *dns.SVCB:
+			name = record.Target
+		case *dns.MX:
+			name = record.Mx
+		default:
+			continue
+		}
+		// '.' is the owner name itself (or no mail for MX)
+		if name != "." {
+			names = append(names, strings.TrimSuffix(name, "."))
+		}
+	}
+	return names
+}
+
+// DeriveTargets enrolls the names an HTTPS, SVCB or MX answer of an explicitly
+// added domain or a companion points to, if its group derives targets. The
+// derived domains belong to the explicitly added domain.
+func (dh *DomainHeap) DeriveTargets(parent *Domain, group *Group) {
+	if !group.DeriveTargets || parent.Derived == DerivedTarget {
+		return
+	}
+	root := parent.Key()
+	if parent.Parent != "" {
+		root = parent.Parent
+	}
+	switch parent.RecordType() {
+	case dns.TypeHTTPS, dns.TypeSVCB, dns.TypeMX:
+	default:
+		return
+	}
+	for _, target := range AnswerTargets(parent.response) {
+		name, err := NormalizeDomainName(target)
+		if err != nil {
+			log.WithContext(parent.traceContext()).Debug("not deriving ", target, " from ", parent.ToString(), ": ", err)
+			continue
+		}
+		if name == parent.Record_name {
+			continue
+		}
+		for _, rr_type := range targetTypes {
+			derived := Domain{
+				Record_name:   name,
+				Record_type:   rr_type,
+				Client_subnet: parent.Client_subnet,
+				Group:         parent.Group,
+				Parent:        root,
+				Derived:       DerivedTarget,
+			}
+			if dh.addDerived(derived) {
+				derivedDomains.With(prometheus.Labels{"kind": DerivedTarget}).Inc()
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	dns "github.com/miekg/dns"
+)
+
+// queuedEntry returns the queued domain with the key
+func queuedEntry(t *testing.T, key string) Domain {
+	t.Helper()
+	for _, d := range dh.QueuedDomains() {
+		if d.Key() == key {
+			return d
+		}
+	}
+	t.Fatalf("%s is not queued", key)
+	return Domain{}
+}
+
+func TestAddDomainEnrollsCompanions(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "web", CompanionTypes: []string{"AAAA", "HTTPS", "A"}})
+	if err := dh.AddDomain(Domain{Record_name: "example.com", Record_type: "A", Group: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"web example.com IN A", "web example.com IN AAAA", "web example.com IN HTTPS"}
+	if keys := queuedKeyList(); !slices.Equal(keys, want) {
+		t.Fatalf("queue = %v, want %v", keys, want)
+	}
+	if companion := queuedEntry(t, "web example.com IN AAAA"); companion.Derived != DerivedCompanion || companion.Parent != "web example.com IN A" {
+		t.Errorf("companion derived %q from %q", companion.Derived, companion.Parent)
+	}
+
+	// adding it again queues no duplicates
+	dh.AddDomain(Domain{Record_name: "example.com", Record_type: "A", Group: "web"})
+	if size := dh.QueueSize(); size != 3 {
+		t.Errorf("%d queued domains after adding the domain twice, want 3", size)
+	}
+	// removing the parent removes its companions
+	if removed := dh.RemoveDomains(func(d *Domain) bool { return d.Key() == "web example.com IN A" }); removed != 3 {
+		t.Errorf("%d domains removed with the parent, want 3", removed)
+	}
+}
+
+func TestAddDomainPromotesDerivedDomains(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "web", CompanionTypes: []string{"AAAA"}})
+	dh.AddDomain(Domain{Record_name: "example.com", Record_type: "A", Group: "web"})
+
+	if err := dh.AddDomain(Domain{Record_name: "example.com", Record_type: "AAAA", Group: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if size := dh.QueueSize(); size != 2 {
+		t.Errorf("%d queued domains, want the explicit entry in place of the companion", size)
+	}
+	if d := queuedEntry(t, "web example.com IN AAAA"); d.Parent != "" || d.Derived != "" {
+		t.Errorf("explicitly added entry derived %q from %q", d.Derived, d.Parent)
+	}
+	// the explicitly added entry outlives its former parent
+	dh.RemoveDomains(func(d *Domain) bool { return d.Key() == "web example.com IN A" })
+	if keys := queuedKeyList(); !slices.Equal(keys, []string{"web example.com IN AAAA"}) {
+		t.Errorf("queue = %v, want the explicitly added entry", keys)
+	}
+}
+
+func TestAddDomainPromotesReadyDerivedDomains(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "web", CompanionTypes: []string{"AAAA"}})
+	dh.AddDomain(Domain{Record_name: "example.com", Record_type: "A", Group: "web"})
+	fillWithin(t, 5*time.Second)
+	if len(scheduler.ready["web"]) != 2 {
+		t.Fatalf("ready = %v, want both entries", scheduler.ready["web"])
+	}
+
+	dh.AddDomain(Domain{Record_name: "example.com", Record_type: "AAAA", Group: "web"})
+	if d := queuedEntry(t, "web example.com IN AAAA"); d.Parent != "" {
+		t.Errorf("ready entry listed as derived from %q after the explicit add", d.Parent)
+	}
+	dh.RemoveDomains(func(d *Domain) bool { return d.Key() == "web example.com IN A" })
+	if keys := queuedKeyList(); !slices.Equal(keys, []string{"web example.com IN AAAA"}) {
+		t.Errorf("queue = %v, want the explicitly added entry", keys)
+	}
+
+	// the ready copy is promoted once it returns to the heap
+	i := slices.IndexFunc(scheduler.ready["web"], func(d Domain) bool { return d.Record_type == "AAAA" })
+	ready := scheduler.ready["web"][i]
+	promote(&ready)
+	if ready.Parent != "" || ready.Derived != "" {
+		t.Errorf("returning entry derived %q from %q", ready.Derived, ready.Parent)
+	}
+}
+
+func TestAnswerTargets(t *testing.T) {
+	response := new(dns.Msg)
+	response.Answer = []dns.RR{
+		mustRR(t, "example.com. 300 IN MX 10 mail.example.com."),
+		mustRR(t, "example.com. 300 IN MX 0 ."),
+		mustRR(t, "example.com. 300 IN HTTPS 1 cdn.example.net. alpn=h2"),
+		mustRR(t, "example.com. 300 IN HTTPS 1 ."),
+		mustRR(t, "_dns.example.com. 300 IN SVCB 1 dns.example.net."),
+		mustRR(t, "example.com. 300 IN A 192.0.2.1"),
+	}
+	want := []string{"mail.example.com", "cdn.example.net", "dns.example.net"}
+	if targets := AnswerTargets(response); !slices.Equal(targets, want) {
+		t.Errorf("AnswerTargets = %v, want %v", targets, want)
+	}
+	if AnswerTargets(nil) != nil {
+		t.Error("targets without response")
+	}
+}
+
+func TestDeriveTargets(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "mail", DeriveTargets: true})
+	group := groups["mail"]
+	parent := Domain{Record_name: "example.com", Record_type: "MX", Group: "mail"}
+	parent.response = new(dns.Msg)
+	parent.response.Answer = []dns.RR{mustRR(t, "example.com. 300 IN MX 10 Mail.Example.com.")}
+
+	dh.DeriveTargets(&parent, group)
+	dh.DeriveTargets(&parent, group)
+	want := []string{"mail mail.example.com IN A", "mail mail.example.com IN AAAA"}
+	if keys := queuedKeyList(); !slices.Equal(keys, want) {
+		t.Fatalf("queue = %v, want %v", keys, want)
+	}
+	if d := queuedEntry(t, want[0]); d.Derived != DerivedTarget || d.Parent != parent.Key() {
+		t.Errorf("target derived %q from %q", d.Derived, d.Parent)
+	}
+
+	// targets of derived domains belong to the explicitly added domain
+	resetQueue(t)
+	companion := parent
+	companion.Parent, companion.Derived = "mail example.com IN A", DerivedCompanion
+	dh.DeriveTargets(&companion, group)
+	if d := queuedEntry(t, want[0]); d.Parent != "mail example.com IN A" {
+		t.Errorf("target of a companion derived from %q", d.Parent)
+	}
+}
@@ -18,20 +18,20 @@ import (
 // NewConfig returns a new decoded Config struct
 func NewConfig(configPath string) *ResolverConfiguration {
 	config := &ResolverConfiguration{
-		ResolverIp:                                "127.0.0.1",
-		TimeoutMillisecons:                        5000,
-		RetryTimes:                                0,
-		PinMinTtl:                                 10,
-		StaticDelaySeconds:                        10,
-		FlexibleDelayMinTtlSeconds:                300,
-		FlexibleDelayMaxTtlSeconds:                600,
-		SleepLowTresholdMilliseconds:              1000,
+		ResolverIp:                   "127.0.0.1",
+		TimeoutMillisecons:           5000,
+		RetryTimes:                   0,
+		PinMinTtl:                    10,
+		StaticDelaySeconds:           10,
+		FlexibleDelayMinTtlSeconds:   300,
+		FlexibleDelayMaxTtlSeconds:   600,
+		SleepLowTresholdMilliseconds: 1000,
 		SleepLowTresholdCheckIntervalMilliseconds: 50,
-		ServerListenPort:                          8000,
-		DomainsFile:                               "",
-		LoadDomainsFileOnStart:                    false,
-		LoadDomainsFileInitialQueryLimit:          100,
-		LogLevel:                                  3,
+		ServerListenPort:                 8000,
+		DomainsFile:                      "",
+		LoadDomainsFileOnStart:           false,
+		LoadDomainsFileInitialQueryLimit: 100,
+		LogLevel:                         3,
 	}
 
 	// Parse the YAML configuration file.
@@ -78,14 +78,31 @@ func ParseFlags(rc *ResolverConfiguration) error {
 	flag.StringVar(&rc.ResolverIp, "ResolverIp", "127.0.0.1", "The resolver to which requests should be sent")
 	flag.UintVar(&rc.PinMinTtl, "PinMinTtl", 5, "If the returned ttl is greater than this value, use this ttl instead of the returned value")
 	flag.UintVar(&rc.StaticDelaySeconds, "StaticDelaySeconds", 600, "Dont send requests for the configured amount of seconds if the request returns a permanent error")
+	flag.UintVar(&rc.BackoffMaxSeconds, "BackoffMaxSeconds", 3600, "The delay between retries of a failing domain doubles with every consecutive failure up to this value")
+	flag.UintVar(&rc.QuarantineAfterFailures, "QuarantineAfterFailures", 10, "Quarantine a domain after value consecutive failures (0 disables quarantine)")
+	flag.UintVar(&rc.QuarantineRecheckSeconds, "QuarantineRecheckSeconds", 86400, "Recheck quarantined domains every value seconds")
+	flag.UintVar(&rc.QueryRateLimit, "QueryRateLimit", 0, "Send at most value refreshes/probes per second (0=unlimited)")
+	flag.UintVar(&rc.ProbeSampleSize, "ProbeSampleSize", 1000, "Number of randomly sampled domains a readiness probe queries (0=all)")
+	flag.Float64Var(&rc.ProbeThreshold, "ProbeThreshold", 0.9, "A readiness probe passes if at least this fraction of the probed domains is cached")
+	flag.Float64Var(&rc.ReadinessCacheHitRatio, "ReadinessCacheHitRatio", 0, "/readyz fails until every target reached this cache hit ratio (0=disabled)")
+	flag.UintVar(&rc.CacheHitLatencyMilliseconds, "CacheHitLatencyMilliseconds", 5, "Consider responses faster than value ms as served from the cache of the target")
+	flag.UintVar(&rc.CacheHitRatioWindow, "CacheHitRatioWindow", 1000, "Calculate the cache hit ratio of a target over the last value responses")
 	flag.UintVar(&rc.FlexibleDelayMinTtlSeconds, "FlexibleDelayMinTtlSeconds", 120, "If a flexible ttl is requested, return a value >= this value")
 	flag.UintVar(&rc.FlexibleDelayMaxTtlSeconds, "FlexibleDelayMaxTtlSeconds", 300, "If a flexible ttl is requested, return a value <= this value")
 	flag.Uint64Var(&rc.SleepLowTresholdMilliseconds, "SleepLowTresholdMilliseconds", 500, "If there is a bigger gap (>= value) between now and the next due request, sleep for value ms")
 	flag.Uint64Var(&rc.SleepLowTresholdCheckIntervalMilliseconds, "SleepLowTresholdCheckIntervalMilliseconds", 50, "If in next due time is < SleepLowTresholdMilliseconds, poll the queue every value ms")
 	flag.UintVar(&rc.ServerListenPort, "ServerListenPort", 9000, "Port on which the webserver should listen")
+	flag.StringVar(&rc.ServerListenAddress, "ServerListenAddress", "", "Address on which the webserver should listen (empty=all interfaces)")
 	flag.StringVar(&rc.DomainsFile, "DomainsFile", "domains.txt", "A file which contains the list of domains to preheat. Entries must be separated by newline '\\n'. Syntax 'domain rrtype' (e.g. 'github.com A')")
 	flag.BoolVar(&rc.LoadDomainsFileOnStart, "LoadDomainsFileOnStart", true, "Load the domains file on start")
 	flag.UintVar(&rc.LoadDomainsFileInitialQueryLimit, "LoadDomainsFileInitialQueryLimit", 500, "Limit to value requests per second when reading from DomainsFile")
+	flag.StringVar(&rc.ApiTokenFile, "ApiTokenFile", "", "Load additional api tokens (yaml list of Name, Hash, Scopes) from this file")
+	flag.BoolVar(&rc.ProtectMetrics, "ProtectMetrics", false, "Require an api token with scope read for /metrics")
+	flag.StringVar(&rc.StateFile, "StateFile", "", "Persist the pause state of the scheduler and targets in this file (empty=disabled)")
+	flag.StringVar(&rc.ReservedNamePolicy, "ReservedNamePolicy", ReservedNamesReject, "Domains below special-use names like .local, .invalid or .test are rejected, queued with a warning or queued (reject, warn, allow)")
+	flag.UintVar(&rc.ReverseMaxNames, "ReverseMaxNames", 65536, "Reject IP prefixes in the DomainsFile which expand to more than value reverse names")
+	flag.UintVar(&rc.QueryHistorySize, "QueryHistorySize", 10, "Keep the last value query results per domain (exposed via api)")
+	flag.StringVar(&rc.LogFormat, "LogFormat", "text", "Log as text or json (trace_id and span_id of traced refreshes are added as fields)")
 	flag.UintVar(&rc.LogLevel, "LogLevel", 3, "LogLevel (1-8) to use. 1=Panic,8=Trace - see https://github.com/sirupsen/logrus")
 
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
@@ -99,20 +116,47 @@ func ParseFlags(rc *ResolverConfiguration) error {
 }
 
 type ResolverConfiguration struct {
-	TimeoutMillisecons                        uint   `yaml:"TimeoutMillisecons"`
-	RetryTimes                                uint   `yaml:"RetryTimes"`
-	ResolverIp                                string `yaml:"ResolverIp"`
-	PinMinTtl                                 uint   `yaml:"PinMinTtl"`
-	StaticDelaySeconds                        uint   `yaml:"StaticDelaySeconds"`
-	FlexibleDelayMinTtlSeconds                uint   `yaml:"FlexibleDelayMinTtlSeconds"`
-	FlexibleDelayMaxTtlSeconds                uint   `yaml:"FlexibleDelayMaxTtlSeconds"`
-	SleepLowTresholdMilliseconds              uint64 `yaml:"SleepLowTresholdMilliseconds"`
-	SleepLowTresholdCheckIntervalMilliseconds uint64 `yaml:"SleepLowTresholdCheckIntervalMilliseconds"`
-	ServerListenPort                          uint   `yaml:"ServerListenPort"`
-	DomainsFile                               string `yaml:"DomainsFile"`
-	LoadDomainsFileOnStart                    bool   `yaml:"LoadDomainsFileOnStart"`
-	LoadDomainsFileInitialQueryLimit          uint   `yaml:"LoadDomainsFileInitialQueryLimit"`
-	LogLevel                                  uint   `yaml:"LogLevel"`
+	TimeoutMillisecons                        uint                         `yaml:"TimeoutMillisecons"`
+	RetryTimes                                uint                         `yaml:"RetryTimes"`
+	ResolverIp                                string                       `yaml:"ResolverIp"`
+	PinMinTtl                                 uint                         `yaml:"PinMinTtl"`
+	StaticDelaySeconds                        uint                         `yaml:"StaticDelaySeconds"`
+	FlexibleDelayMinTtlSeconds                uint                         `yaml:"FlexibleDelayMinTtlSeconds"`
+	FlexibleDelayMaxTtlSeconds                uint                         `yaml:"FlexibleDelayMaxTtlSeconds"`
+	SleepLowTresholdMilliseconds              uint64                       `yaml:"SleepLowTresholdMilliseconds"`
+	SleepLowTresholdCheckIntervalMilliseconds uint64                       `yaml:"SleepLowTresholdCheckIntervalMilliseconds"`
+	ServerListenPort                          uint                         `yaml:"ServerListenPort"`
+	DomainsFile                               string                       `yaml:"DomainsFile"`
+	LoadDomainsFileOnStart                    bool                         `yaml:"LoadDomainsFileOnStart"`
+	LoadDomainsFileInitialQueryLimit          uint                         `yaml:"LoadDomainsFileInitialQueryLimit"`
+	LogLevel                                  uint                         `yaml:"LogLevel"`
+	Targets                                   []TargetConfiguration        `yaml:"Targets"`
+	ClientSubnets                             []string                     `yaml:"ClientSubnets"`
+	QueryHistorySize                          uint                         `yaml:"QueryHistorySize"`
+	BackoffMaxSeconds                         uint                         `yaml:"BackoffMaxSeconds"`
+	QuarantineAfterFailures                   uint                         `yaml:"QuarantineAfterFailures"`
+	QuarantineRecheckSeconds                  uint                         `yaml:"QuarantineRecheckSeconds"`
+	CacheHitLatencyMilliseconds               uint                         `yaml:"CacheHitLatencyMilliseconds"`
+	CacheHitRatioWindow                       uint                         `yaml:"CacheHitRatioWindow"`
+	QueryRateLimit                            uint                         `yaml:"QueryRateLimit"`
+	ProbeSampleSize                           uint                         `yaml:"ProbeSampleSize"`
+	ReadinessCacheHitRatio                    float64                      `yaml:"ReadinessCacheHitRatio"`
+	ProbeThreshold                            float64                      `yaml:"ProbeThreshold"`
+	Diff                                      DiffConfiguration            `yaml:"Diff"`
+	Groups                                    []GroupConfiguration         `yaml:"Groups"`
+	ServerListenAddress                       string                       `yaml:"ServerListenAddress"`
+	ApiTls                                    ApiTlsConfiguration          `yaml:"ApiTls"`
+	ApiTokens                                 []ApiTokenConfiguration      `yaml:"ApiTokens"`
+	ApiTokenFile                              string                       `yaml:"ApiTokenFile"`
+	ProtectMetrics                            bool                         `yaml:"ProtectMetrics"`
+	StateFile                                 string                       `yaml:"StateFile"`
+	Schedules                                 []ScheduleRuleConfiguration  `yaml:"Schedules"`
+	ReservedNamePolicy                        string                       `yaml:"ReservedNamePolicy"`
+	Exclusions                                []string                     `yaml:"Exclusions"`
+	ExclusionFiles                            []ExclusionFileConfiguration `yaml:"ExclusionFiles"`
+	ReverseMaxNames                           uint                         `yaml:"ReverseMaxNames"`
+	Tracing                                   TracingConfiguration         `yaml:"Tracing"`
+	LogFormat                                 string                       `yaml:"LogFormat"`
 }
 
 func StructToKeyValuePairs(config *ResolverConfiguration) map[string]interface{} {
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DiffConfiguration - compare the answers of a candidate target with those of a reference target
+type DiffConfiguration struct {
+	Reference string `yaml:"Reference"`
+	Candidate string `yaml:"Candidate"`
+	// MismatchFile - append every mismatch as json line to this file
+	MismatchFile string `yaml:"MismatchFile"`
+	// MaxExamples - number of mismatches kept in memory for the api
+	MaxExamples uint `yaml:"MaxExamples"`
+}
+
+const (
+	DiffMatch    = "match"
+	DiffMismatch = "mismatch"
+	DiffError    = "error"
+)
+
+var (
+	diffComparisons = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "diff_comparisons_total",
+		Help:      "The total number of answer comparisons between reference and candidate target by result",
+	},
+		[]string{"reference", "candidate", "result"},
+	)
+	diffMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "diff_mismatches_total",
+		Help:      "The total number of answers of the candidate target which differ from the reference target",
+	},
+		[]string{"reference", "candidate", "reason"},
+	)
+)
+
+func init() {
+	prometheus.Register(diffComparisons)
+	prometheus.Register(diffMismatches)
+}
+
+// AnswerMismatch - an example of differing answers
+type AnswerMismatch struct {
+	Time             int64    `json:"time" example:"1700000000000"`
+	Domain           string   `json:"domain" example:"google.com"`
+	Type             string   `json:"type" example:"A"`
+	Subnet           string   `json:"subnet,omitempty" example:"192.0.2.0/24"`
+	Reason           string   `json:"reason" example:"rrset"`
+	Reference        string   `json:"reference" example:"old"`
+	Candidate        string   `json:"candidate" example:"new"`
+	ReferenceRcode   string   `json:"reference_rcode" example:"NOERROR"`
+	CandidateRcode   string   `json:"candidate_rcode" example:"NOERROR"`
+	ReferenceAnswers []string `json:"reference_answers"`
+	CandidateAnswers []string `json:"candidate_answers"`
+}
+
+// Differ - sends domains to a reference and a candidate target and records differing answers
+type Differ struct {
+	Reference    *Target
+	Candidate    *Target
+	mismatchFile string
+	maxExamples  int
+	mu           sync.Mutex
+	examples     []AnswerMismatch
+	counts       map[string]int
+}
+
+// NewDiffer returns nil if no reference and candidate are configured
+func NewDiffer(config DiffConfiguration) (*Differ, error) {
+	if config.Reference == "" && config.Candidate == "" {
+		return nil, nil
+	}
+	reference, err := FindTarget(config.Reference)
+	if err != nil {
+		return nil, fmt.Errorf("diff reference: %w", err)
+	}
+	candidate, err := FindTarget(config.Candidate)
+	if err != nil {
+		return nil, fmt.Errorf("diff candidate: %w", err)
+	}
+	maxExamples := int(config.MaxExamples)
+	if maxExamples == 0 {
+		maxExamples = 100
+	}
+	return &Differ{
+		Reference:    reference,
+		Candidate:    candidate,
+		mismatchFile: config.MismatchFile,
+		maxExamples:  maxExamples,
+		counts:       make(map[string]int),
+	}, nil
+}
+
+// NormalizeAnswers returns the answer section as sorted, deduplicated
+// presentation strings without ttl. Signatures are left out.
+func NormalizeAnswers(r *dns.Msg) []string {
+	var answers []string
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG || rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		rr.Header().Name = strings.ToLower(rr.Header().Name)
+		answers = append(answers, rr.String())
+	}
+	slices.Sort(answers)
+	return slices.Compact(answers)
+}
+
+// Compare sends the domain to both targets and records a mismatch if the
+// rcodes or the answer rrsets differ. The queries count towards the rate
+// limits of the group.
+func (differ *Differ) Compare(domain *Domain, group *Group) string {
+	labels := prometheus.Labels{"reference": differ.Reference.Id, "candidate": differ.Candidate.Id}
+	responses := make([]*dns.Msg, 2)
+	for i, target := range []*Target{differ.Reference, differ.Candidate} {
+		var m *dns.Msg
+		err := WaitForGroupQuerySlot(domain.traceContext(), group)
+		if err == nil {
+			m, err = target.NewDomainQuery(domain)
+		}
+		if err == nil {
+			responses[i], _, err = target.Exchange(domain.traceContext(), domain.GroupName(), m)
+		}
+		if err != nil {
+			log.WithContext(domain.traceContext()).Debug("diff of ", domain.ToString(), " failed on target ", target.Id, ": ", err)
+			differ.count(DiffError, labels)
+			return DiffError
+		}
+	}
+
+	mismatch := AnswerMismatch{
+		Time:             time.Now().UnixMilli(),
+		Domain:           domain.Record_name,
+		Type:             domain.Record_type,
+		Subnet:           domain.Client_subnet,
+		Reference:        differ.Reference.Id,
+		Candidate:        differ.Candidate.Id,
+		ReferenceRcode:   dns.RcodeToString[responses[0].Rcode],
+		CandidateRcode:   dns.RcodeToString[responses[1].Rcode],
+		ReferenceAnswers: NormalizeAnswers(responses[0]),
+		CandidateAnswers: NormalizeAnswers(responses[1]),
+	}
+	switch {
+	case mismatch.ReferenceRcode != mismatch.CandidateRcode:
+		mismatch.Reason = "rcode"
+	case !slices.Equal(mismatch.ReferenceAnswers, mismatch.CandidateAnswers):
+		mismatch.Reason = "rrset"
+	default:
+		differ.count(DiffMatch, labels)
+		return DiffMatch
+	}
+
+	log.WithContext(domain.traceContext()).Debug("diff of ", domain.ToString(), " between ", differ.Reference.Id, " and ", differ.Candidate.Id, " found ", mismatch.Reason, " mismatch")
+	labels["reason"] = mismatch.Reason
+	diffMismatches.With(labels).Inc()
+	delete(labels, "reason")
+	differ.count(DiffMismatch, labels)
+	differ.record(mismatch)
+	return DiffMismatch
+}
+
+func (differ *Differ) count(result string, labels prometheus.Labels) {
+	labels["result"] = result
+	diffComparisons.With(labels).Inc()
+	delete(labels, "result")
+	differ.mu.Lock()
+	differ.counts[result]++
+	differ.mu.Unlock()
+}
+
+func (differ *Differ) record(mismatch AnswerMismatch) {
+	differ.mu.Lock()
+	defer differ.mu.Unlock()
+	differ.examples = append(differ.examples, mismatch)
+	if overflow := len(differ.examples) - differ.maxExamples; overflow > 0 {
+		differ.examples = append([]AnswerMismatch(nil), differ.examples[overflow:]...)
+	}
+
+	if differ.mismatchFile == "" {
+		return
+	}
+	line, err := json.Marshal(mismatch)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	f, err := os.OpenFile(differ.mismatchFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Error("unable to write diff mismatch: ", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// Examples returns the recorded mismatches (oldest first) and the comparison counts
+func (differ *Differ) Examples() ([]AnswerMismatch, map[string]int) {
+	differ.mu.Lock()
+	defer differ.mu.Unlock()
+	counts := make(map[string]int)
+	for _, result := range []string{DiffMatch, DiffMismatch, DiffError} {
+		counts[result] = differ.counts[result]
+	}
+	return slices.Clone(differ.examples), counts
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"golang.org/x/time/rate"
+)
+
+func TestNormalizeAnswers(t *testing.T) {
+	r := &dns.Msg{Answer: []dns.RR{
+		mustRR(t, "Example.com. 300 IN A 192.0.2.2"),
+		mustRR(t, "example.com. 60 IN A 192.0.2.1"),
+		mustRR(t, "example.com. 300 IN A 192.0.2.2"),
+		mustRR(t, "example.com. 300 IN RRSIG A 13 2 300 20300101000000 20200101000000 12345 example.com. c2lnbmF0dXJl"),
+	}}
+	answers := NormalizeAnswers(r)
+	if len(answers) != 2 || !strings.HasSuffix(answers[0], "192.0.2.1") || !strings.HasSuffix(answers[1], "192.0.2.2") {
+		t.Errorf("NormalizeAnswers = %v, want the two addresses without signature", answers)
+	}
+}
+
+// newTestDiffer compares a reference answering 192.0.2.1 with a candidate
+// answering with candidate
+func newTestDiffer(t *testing.T, candidate dns.HandlerFunc) *Differ {
+	reference := newTestTarget(t, TargetConfiguration{Id: "reference", Address: startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(reply(t, r))
+	})})
+	return &Differ{
+		Reference:   reference,
+		Candidate:   newTestTarget(t, TargetConfiguration{Id: "candidate", Address: startDnsServer(t, candidate)}),
+		maxExamples: 2,
+		counts:      make(map[string]int),
+	}
+}
+
+func TestDifferCompare(t *testing.T) {
+	group := &Group{Name: "diff", limiter: NewQueryLimiter(0)}
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+
+	matching := newTestDiffer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := reply(t, r)
+		m.Answer[0].Header().Ttl = 10
+		w.WriteMsg(m)
+	})
+	if result := matching.Compare(domain, group); result != DiffMatch {
+		t.Errorf("answers differing in ttl only: %s, want %s", result, DiffMatch)
+	}
+
+	rrset := newTestDiffer(t, answer(t, "example.com. 300 IN A 192.0.2.99"))
+	rrset.mismatchFile = filepath.Join(t.TempDir(), "mismatches.ndjson")
+	if result := rrset.Compare(domain, group); result != DiffMismatch {
+		t.Errorf("different addresses: %s, want %s", result, DiffMismatch)
+	}
+	examples, counts := rrset.Examples()
+	if len(examples) != 1 || examples[0].Reason != "rrset" || counts[DiffMismatch] != 1 {
+		t.Errorf("examples = %v, counts = %v, want one rrset mismatch", examples, counts)
+	}
+	if data, err := os.ReadFile(rrset.mismatchFile); err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("mismatch file = %q (%v), want one line", data, err)
+	}
+
+	rcode := newTestDiffer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		w.WriteMsg(m.SetRcode(r, dns.RcodeNameError))
+	})
+	for i := 0; i < 3; i++ {
+		rcode.Compare(domain, group)
+	}
+	examples, _ = rcode.Examples()
+	if len(examples) != 2 || examples[0].Reason != "rcode" || examples[0].CandidateRcode != "NXDOMAIN" {
+		t.Errorf("examples = %v, want the last two (MaxExamples) rcode mismatches", examples)
+	}
+}
+
+func TestDifferCompareRespectsTheGroupRateLimit(t *testing.T) {
+	differ := newTestDiffer(t, func(w dns.ResponseWriter, r *dns.Msg) { w.WriteMsg(reply(t, r)) })
+	group := &Group{Name: "diff", limiter: rate.NewLimiter(20, 1)}
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		differ.Compare(domain, group)
+	}
+	// 6 queries at 20 per second with a burst of 1
+	if elapsed := time.Since(start); elapsed < 240*time.Millisecond {
+		t.Errorf("6 diff queries took %v, want at least 250ms at 20 queries per second", elapsed)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DnssecStatusSecure - the target returned the answer with the AD flag set
+	DnssecStatusSecure = "secure"
+	// DnssecStatusInsecure - the target returned the answer without the AD flag
+	DnssecStatusInsecure = "insecure"
+)
+
+var (
+	dnssecResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "dnssec_responses_total",
+		Help:      "The total number of responses from dnssec-aware targets by validation status",
+	},
+		[]string{"target", "status"},
+	)
+	dnssecStatusChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "dnssec_status_changes_total",
+		Help:      "The total number of domains whose validation status flipped",
+	},
+		[]string{"target", "from", "to"},
+	)
+)
+
+func init() {
+	prometheus.Register(dnssecResponses)
+	prometheus.Register(dnssecStatusChanges)
+}
+
+// TrackDnssec records the validation status and the earliest signature
+// expiration of a response from a dnssec-aware target
+func (domain *Domain) TrackDnssec(target *Target, r *dns.Msg) {
+	if !target.Dnssec {
+		return
+	}
+	status := DnssecStatusInsecure
+	if r.AuthenticatedData {
+		status = DnssecStatusSecure
+	}
+	dnssecResponses.With(prometheus.Labels{"target": target.Id, "status": status}).Inc()
+
+	if domain.Dnssec_status == nil {
+		domain.Dnssec_status = make(map[string]string)
+	}
+	previous, seen := domain.Dnssec_status[target.Id]
+	domain.Dnssec_status[target.Id] = status
+	if seen && previous != status {
+		dnssecStatusChanges.With(prometheus.Labels{"target": target.Id, "from": previous, "to": status}).Inc()
+		log.WithContext(domain.traceContext()).Warn("dnssec validation status of ", domain.ToString(), " on target ", target.Id, " changed from ", previous, " to ", status)
+	}
+
+	// an answer without signatures resets the expiration of the target
+	if expiration := RrsigExpiration(r.Answer, time.Now()); expiration != 0 {
+		if domain.Rrsig_expires_at == nil {
+			domain.Rrsig_expires_at = make(map[string]int64)
+		}
+		domain.Rrsig_expires_at[target.Id] = expiration
+	} else {
+		delete(domain.Rrsig_expires_at, target.Id)
+	}
+}
+
+// RrsigExpiration returns the earliest expiration (unix seconds) of all RRSIGs
+// in rrs or 0 if there are none
+func RrsigExpiration(rrs []dns.RR, now time.Time) int64 {
+	var earliest int64 = 0
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		// signature timestamps use serial number arithmetic (RFC 4034 3.1.5),
+		// they are within 68 years of now
+		expiration := now.Unix() + int64(int32(sig.Expiration-uint32(now.Unix())))
+		if earliest == 0 || expiration < earliest {
+			earliest = expiration
+		}
+	}
+	return earliest
+}
+
+// RrsigSecondsLeft returns the seconds until the earliest signature of the
+// last answer of the target expires. ok is false if the last answer carried
+// no signatures or the target is not dnssec-aware
+func (domain Domain) RrsigSecondsLeft(target *Target) (left uint, ok bool) {
+	expires_at, ok := domain.Rrsig_expires_at[target.Id]
+	if !ok {
+		return 0, false
+	}
+	seconds := expires_at - time.Now().Unix()
+	if seconds < 0 {
+		return 0, true
+	}
+	return uint(seconds), true
+}
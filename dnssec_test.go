@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewQuerySetsDoBitForDnssecTargets(t *testing.T) {
+	plain := &Target{Id: "plain"}
+	if opt := plain.NewQuery("example.com", dns.TypeA).IsEdns0(); opt != nil {
+		t.Errorf("query of a plain target has EDNS0: %v", opt)
+	}
+	aware := &Target{Id: "aware", Dnssec: true}
+	opt := aware.NewQuery("example.com", dns.TypeA).IsEdns0()
+	if opt == nil || !opt.Do() {
+		t.Errorf("query of a dnssec-aware target has no DO bit: %v", opt)
+	}
+}
+
+func TestTrackDnssecRecordsStatusChanges(t *testing.T) {
+	target := &Target{Id: "track-dnssec", Dnssec: true}
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+	changes := dnssecStatusChanges.With(prometheus.Labels{"target": target.Id, "from": DnssecStatusSecure, "to": DnssecStatusInsecure})
+	before := testutil.ToFloat64(changes)
+
+	domain.TrackDnssec(target, &dns.Msg{MsgHdr: dns.MsgHdr{AuthenticatedData: true}})
+	if status := domain.Dnssec_status[target.Id]; status != DnssecStatusSecure {
+		t.Fatalf("status = %s, want %s", status, DnssecStatusSecure)
+	}
+	domain.TrackDnssec(target, &dns.Msg{})
+	if status := domain.Dnssec_status[target.Id]; status != DnssecStatusInsecure {
+		t.Fatalf("status = %s, want %s", status, DnssecStatusInsecure)
+	}
+	if got := testutil.ToFloat64(changes) - before; got != 1 {
+		t.Errorf("status changes = %v, want 1", got)
+	}
+
+	untracked := &Domain{Record_name: "example.com", Record_type: "A"}
+	untracked.TrackDnssec(&Target{Id: "plain"}, &dns.Msg{MsgHdr: dns.MsgHdr{AuthenticatedData: true}})
+	if untracked.Dnssec_status != nil {
+		t.Errorf("status tracked for a plain target: %v", untracked.Dnssec_status)
+	}
+}
+
+func TestRrsigExpiration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rrsig := func(expiration int64) dns.RR {
+		return &dns.RRSIG{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET}, Expiration: uint32(expiration)}
+	}
+	tests := []struct {
+		name string
+		rrs  []dns.RR
+		now  time.Time
+		want int64
+	}{
+		{"no signatures", nil, now, 0},
+		{"earliest signature", []dns.RR{rrsig(now.Unix() + 600), rrsig(now.Unix() + 300)}, now, now.Unix() + 300},
+		{"expired signature", []dns.RR{rrsig(now.Unix() - 60)}, now, now.Unix() - 60},
+		// past 2106 the 32 bit timestamps wrap around
+		{"wrapped timestamp", []dns.RR{rrsig(1<<32 + 100)}, time.Unix(1<<32-100, 0), 1<<32 + 100},
+	}
+	for _, test := range tests {
+		if got := RrsigExpiration(test.rrs, test.now); got != test.want {
+			t.Errorf("%s: RrsigExpiration = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRegularStrategyRefreshesBeforeSignaturesExpire(t *testing.T) {
+	expiration := time.Now().Add(2 * time.Minute).UTC().Format("20060102150405")
+	address := startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.AuthenticatedData = true
+		m.Answer = append(m.Answer,
+			mustRR(t, "example.com. 3600 IN A 192.0.2.1"),
+			mustRR(t, fmt.Sprintf("example.com. 3600 IN RRSIG A 13 2 3600 %s 20200101000000 12345 example.com. c2lnbmF0dXJl", expiration)),
+		)
+		w.WriteMsg(m)
+	})
+	target := newTestTarget(t, TargetConfiguration{Address: address, Dnssec: true})
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+
+	ttl, err := TryQueryRegularDomain(resolverConfiguration, target, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl > 120 || ttl < 110 {
+		t.Errorf("ttl = %d, want the seconds until the signature expires (120)", ttl)
+	}
+	if status := domain.Dnssec_status[target.Id]; status != DnssecStatusSecure {
+		t.Errorf("status = %s, want %s", status, DnssecStatusSecure)
+	}
+}
+
+func TestSignatureExpirationIsKeptPerTarget(t *testing.T) {
+	expiration := time.Now().Add(2 * time.Minute).UTC().Format("20060102150405")
+	var signed atomic.Bool
+	signed.Store(true)
+	address := startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, mustRR(t, "example.com. 3600 IN A 192.0.2.1"))
+		if signed.Load() {
+			m.Answer = append(m.Answer, mustRR(t, fmt.Sprintf("example.com. 3600 IN RRSIG A 13 2 3600 %s 20200101000000 12345 example.com. c2lnbmF0dXJl", expiration)))
+		}
+		w.WriteMsg(m)
+	})
+	aware := newTestTarget(t, TargetConfiguration{Id: "aware", Address: address, Dnssec: true})
+	plain := newTestTarget(t, TargetConfiguration{Id: "plain", Address: startDnsServer(t, answer(t, "example.com. 3600 IN A 192.0.2.1"))})
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+
+	if ttl, err := TryQueryRegularDomain(resolverConfiguration, aware, domain); err != nil || ttl > 120 {
+		t.Fatalf("ttl of the signed answer = %d %v, want at most 120", ttl, err)
+	}
+	// the signatures of the other target don't cap the ttl
+	if ttl, err := TryQueryRegularDomain(resolverConfiguration, plain, domain); err != nil || ttl != 3600 {
+		t.Errorf("ttl of the plain target = %d %v, want 3600", ttl, err)
+	}
+	// an answer without signatures resets the expiration
+	signed.Store(false)
+	if ttl, err := TryQueryRegularDomain(resolverConfiguration, aware, domain); err != nil || ttl != 3600 {
+		t.Errorf("ttl of the unsigned answer = %d %v, want 3600", ttl, err)
+	}
+	if len(domain.Rrsig_expires_at) != 0 {
+		t.Errorf("signature expirations = %v, want none", domain.Rrsig_expires_at)
+	}
+}
@@ -1,5 +1,4 @@
-// Package docs GENERATED BY SWAG; DO NOT EDIT
-// This file was generated by swaggo/swag
+// Package docs Code generated by swaggo/swag. DO NOT EDIT
 package docs
 
 import "github.com/swaggo/swag"
@@ -25,26 +24,168 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/diff": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the number of matches, mismatches and errors and the most recent mismatches",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Return the comparison counts and recent mismatches between reference and candidate target",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithDiff"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/diff/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with one json object per line",
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Export the recent mismatches between reference and candidate target",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.AnswerMismatch"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
         "/domains": {
             "get": {
-                "description": "Responds with the queue",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the matching domains of a consistent snapshot of the queue, their total number and the cursor of the next page",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "syringe"
                 ],
-                "summary": "Return a list of domains currently in the queue",
+                "summary": "Return a page of the domains currently in the queue",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "example": 100,
+                        "description": "page size (default 100, max 10000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "due",
+                        "description": "due (default), name or ttl",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "A",
+                        "description": "only entries of this record type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "critical",
+                        "description": "only entries of this group",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "192.0.2.0/24",
+                        "description": "only entries for this client subnet",
+                        "name": "subnet",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "example.com",
+                        "description": "only domains equal to or below this domain",
+                        "name": "suffix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "^www\\.",
+                        "description": "only domains matching this regular expression",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "failing",
+                        "description": "only entries with this status (ok, pending, failing, quarantined)",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.ResponseWithDomains"
+                            "$ref": "#/definitions/main.ResponseWithDomainPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
                         }
                     }
                 }
             },
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "Responds with the new queue size",
                 "produces": [
                     "application/json"
@@ -74,7 +215,7 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.Response"
+                            "$ref": "#/definitions/main.ResponseError"
                         }
                     }
                 }
@@ -82,7 +223,12 @@ const docTemplate = `{
         },
         "/domains/count": {
             "get": {
-                "description": "Responds with the queue size",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the number of queued domains matching the filters",
                 "produces": [
                     "application/json"
                 ],
@@ -90,33 +236,130 @@ const docTemplate = `{
                     "syringe"
                 ],
                 "summary": "Return the number of domains in the queue",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "A",
+                        "description": "only count entries of this record type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "critical",
+                        "description": "only count entries of this group",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "192.0.2.0/24",
+                        "description": "only count entries for this client subnet",
+                        "name": "subnet",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "example.com",
+                        "description": "only count domains equal to or below this domain",
+                        "name": "suffix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "^www\\.",
+                        "description": "only count domains matching this regular expression",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "failing",
+                        "description": "only count entries with this status (ok, pending, failing, quarantined)",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/main.ResponseWithSize"
                         }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
                     }
                 }
             }
         },
-        "/domains/random": {
-            "post": {
-                "description": "Responds with the new queue size",
+        "/domains/export": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with one NDJSON domain definition or domains file entry per line and queue entry, which can be imported by another instance. Derived entries (companion types, targets) are not exported.",
                 "produces": [
-                    "application/json"
+                    "application/x-ndjson",
+                    "text/plain"
                 ],
                 "tags": [
                     "syringe"
                 ],
-                "summary": "Load random domains from the configured domains file",
+                "summary": "Export the queued domains",
                 "parameters": [
                     {
-                        "minimum": 1,
-                        "type": "integer",
-                        "example": 10,
-                        "description": "int valid",
-                        "name": "count",
+                        "type": "string",
+                        "example": "text",
+                        "description": "ndjson (default) or text",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "A",
+                        "description": "only export entries of this record type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "critical",
+                        "description": "only export entries of this group",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "192.0.2.0/24",
+                        "description": "only export entries for this client subnet",
+                        "name": "subnet",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "example.com",
+                        "description": "only export domains equal to or below this domain",
+                        "name": "suffix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "^www\\.",
+                        "description": "only export domains matching this regular expression",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "failing",
+                        "description": "only export entries with this status (ok, pending, failing, quarantined)",
+                        "name": "status",
                         "in": "query"
                     }
                 ],
@@ -124,65 +367,1667 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.ResponseWithSize"
+                            "$ref": "#/definitions/main.DomainDefinition"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.Response"
+                            "$ref": "#/definitions/main.ResponseError"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.DomainDefinition": {
-            "type": "object",
-            "properties": {
-                "domain": {
-                    "type": "string",
-                    "example": "google.com"
-                },
-                "type": {
-                    "type": "string",
-                    "example": "A"
-                }
-            }
         },
-        "main.DomainListDefinition": {
-            "type": "object",
-            "properties": {
-                "domains": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/main.DomainDefinition"
+        "/domains/history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with all queued entries (types, client subnets) matching the domain",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Return the last results and recent query history of a domain",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "google.com",
+                        "description": "domain name",
+                        "name": "domain",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "A",
+                        "description": "only entries of this rr type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "192.0.2.0/24",
+                        "description": "only entries for this client subnet",
+                        "name": "subnet",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithHistory"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
                     }
                 }
             }
         },
-        "main.Response": {
-            "type": "object",
-            "properties": {
-                "message": {
-                    "type": "string",
-                    "example": "success"
+        "/domains/import": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Reads one NDJSON domain definition or domains file entry ('\u003cdomain\u003e \u003ctype\u003e [ecs=\u003csubnet\u003e,...] [group=\u003cname\u003e]') per line. Invalid lines are skipped and reported. Mode merge adds the domains which are not queued yet, mode replace also removes the queued domains which are not imported, unless a line failed. Mode replace requires the admin scope.",
+                "consumes": [
+                    "application/x-ndjson",
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Import domains into the queue",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "text",
+                        "description": "ndjson or text, defaults to ndjson for json content types and text otherwise",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "merge",
+                        "description": "merge (default) or replace",
+                        "name": "mode",
+                        "in": "query"
+                    },
+                    {
+                        "description": "one domain per line",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithImport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithImport"
+                        }
+                    }
                 }
             }
         },
-        "main.ResponseWithDomains": {
-            "type": "object",
-            "properties": {
-                "domains": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/main.DomainDefinition"
+        "/domains/learn": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
                     }
-                },
-                "message": {
-                    "type": "string",
+                ],
+                "description": "Enrolls the names matching a subscription rule with the types, group and subnets of the rule. Responds with the number of names by outcome.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Learn observed names",
+                "parameters": [
+                    {
+                        "description": "observed names",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.LearnDefinition"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithLearn"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/domains/quarantine": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with all domains which failed QuarantineAfterFailures times in a row",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Return the quarantined domains",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithQuarantine"
+                        }
+                    }
+                }
+            }
+        },
+        "/domains/quarantine/release": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Released domains are queried immediately. Without body, all quarantined domains are released. Responds with the number of released domains",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Release domains from quarantine",
+                "parameters": [
+                    {
+                        "description": "domain list",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.DomainListDefinition"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSize"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/domains/random": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the new queue size",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Load random domains from the configured domains file",
+                "parameters": [
+                    {
+                        "minimum": 1,
+                        "type": "integer",
+                        "example": 10,
+                        "description": "int valid",
+                        "name": "count",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSize"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/domains/refresh": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Moves the matching domains to the front of the queue. They are dispatched within the rate limits. Responds with a job to track the progress.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Refresh queued domains now",
+                "parameters": [
+                    {
+                        "description": "names, suffix, regex and/or group",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RefreshSelector"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithRefreshJob"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/domains/refresh/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the number of matched and already refreshed domains",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Return the progress of a refresh job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "refresh-1",
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithRefreshJob"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Streams dispatch, result, error, add and remove events as they happen. Subscribers which don't keep up are disconnected.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Stream events as server-sent events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "*.example.com",
+                        "description": "only events of domains matching this shell pattern",
+                        "name": "domain",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "critical",
+                        "description": "only events of this group",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "default",
+                        "description": "only events of this target (result and error events)",
+                        "name": "target",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "result,error",
+                        "description": "comma separated event types (dispatch,result,error,add,remove)",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Event"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the state of every group, the open windows (rules and boosts) and the configured rules",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Return the effective rate limits of the groups and the schedule windows",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSchedule"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/boosts": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Changes the rate limit of the groups until the deadline. Responds with the opened window.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Start a boost window",
+                "parameters": [
+                    {
+                        "description": "boost",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.BoostDefinition"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithWindow"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule/boosts/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "End a boost window before its deadline",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "boost-1",
+                        "description": "boost id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/scheduler": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with whether dispatching is paused, the paused targets and the refreshes in flight",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Return the pause state of the scheduler and the targets",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSchedulerState"
+                        }
+                    }
+                }
+            }
+        },
+        "/scheduler/drain": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Pauses like /scheduler/pause and responds once no refreshes (to the target) are in flight or the timeout expired",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Pause sending refreshes and wait for the in-flight refreshes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "resolver1",
+                        "description": "only drain this target",
+                        "name": "target",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "example": 30,
+                        "description": "give up waiting after value seconds (default 30)",
+                        "name": "timeout_seconds",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithDrain"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/scheduler/pause": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stops dispatching refreshes (or only sending them to the target). In-flight refreshes finish. The state survives restarts if a StateFile is configured.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Pause sending refreshes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "resolver1",
+                        "description": "only pause this target",
+                        "name": "target",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSchedulerState"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/scheduler/resume": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Continues dispatching refreshes (or sending them to the target). Domains of the groups using the target which became due while paused are spread out at the rate limit of their group.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Resume sending refreshes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "resolver1",
+                        "description": "only resume this target",
+                        "name": "target",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSchedulerState"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/subscriptions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the rules enrolling learned names and the number of names each rule enrolled",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "List the subscription rules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSubscriptions"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The rule does not query on its own, learned names matching its pattern are enrolled with its types and group. Responds with the added rule.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Add a subscription rule",
+                "parameters": [
+                    {
+                        "description": "subscription",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SubscriptionDefinition"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithSubscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/subscriptions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The names enrolled by the rule stay queued",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Remove a subscription rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "subscription-1",
+                        "description": "subscription id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        },
+        "/targets": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Responds with the targets and their current cache hit ratio",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Return the configured targets",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithTargets"
+                        }
+                    }
+                }
+            }
+        },
+        "/targets/{id}/probe": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Sends non-recursive (RD=0) queries, which don't cause recursion, for a sample of the queue. Responds with the cached fraction per group and whether it meets the threshold. Requires the write scope.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "syringe"
+                ],
+                "summary": "Check which fraction of the queued domains is already cached on a target",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "default",
+                        "description": "target id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "example": 100,
+                        "description": "number of domains to probe, 0 probes the whole queue (default ProbeSampleSize)",
+                        "name": "sample",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "example": 0.9,
+                        "description": "minimum cached fraction to pass (default ProbeThreshold)",
+                        "name": "threshold",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseWithProbe"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/main.ResponseError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "main.AnswerMismatch": {
+            "type": "object",
+            "properties": {
+                "candidate": {
+                    "type": "string",
+                    "example": "new"
+                },
+                "candidate_answers": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "candidate_rcode": {
+                    "type": "string",
+                    "example": "NOERROR"
+                },
+                "domain": {
+                    "type": "string",
+                    "example": "google.com"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "rrset"
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "old"
+                },
+                "reference_answers": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reference_rcode": {
+                    "type": "string",
+                    "example": "NOERROR"
+                },
+                "subnet": {
+                    "type": "string",
+                    "example": "192.0.2.0/24"
+                },
+                "time": {
+                    "type": "integer",
+                    "example": 1700000000000
+                },
+                "type": {
+                    "type": "string",
+                    "example": "A"
+                }
+            }
+        },
+        "main.BoostDefinition": {
+            "type": "object",
+            "properties": {
+                "deadline": {
+                    "description": "Deadline - unix seconds at which the boost ends, alternatively use duration_seconds",
+                    "type": "integer",
+                    "example": 1700003600
+                },
+                "duration_seconds": {
+                    "type": "integer",
+                    "example": 3600
+                },
+                "groups": {
+                    "description": "Groups - defaults to all groups",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "critical"
+                    ]
+                },
+                "rate_limit": {
+                    "description": "RateLimit - refreshes per second of each group until the deadline, 0 is unlimited",
+                    "type": "integer",
+                    "example": 1000
+                }
+            }
+        },
+        "main.DomainDefinition": {
+            "type": "object",
+            "properties": {
+                "domain": {
+                    "type": "string",
+                    "example": "google.com"
+                },
+                "group": {
+                    "description": "Group - defaults to the default group, whose client subnets apply if no subnets are given",
+                    "type": "string",
+                    "example": "critical"
+                },
+                "subnets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "192.0.2.0/24"
+                    ]
+                },
+                "type": {
+                    "type": "string",
+                    "example": "A"
+                }
+            }
+        },
+        "main.DomainHistoryDefinition": {
+            "type": "object",
+            "properties": {
+                "domain": {
+                    "type": "string",
+                    "example": "google.com"
+                },
+                "history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.QueryResult"
+                    }
+                },
+                "last_result": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.QueryResult"
+                    }
+                },
+                "refresh_at": {
+                    "type": "integer",
+                    "example": 1700000010000
+                },
+                "subnet": {
+                    "type": "string",
+                    "example": "192.0.2.0/24"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "A"
+                }
+            }
+        },
+        "main.DomainListDefinition": {
+            "type": "object",
+            "properties": {
+                "domains": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DomainDefinition"
+                    }
+                }
+            }
+        },
+        "main.DomainListEntry": {
+            "type": "object",
+            "properties": {
+                "derived": {
+                    "description": "Derived - companion or target if the entry was derived from another entry",
+                    "type": "string",
+                    "example": "companion"
+                },
+                "domain": {
+                    "type": "string",
+                    "example": "google.com"
+                },
+                "group": {
+                    "description": "Group - defaults to the default group, whose client subnets apply if no subnets are given",
+                    "type": "string",
+                    "example": "critical"
+                },
+                "parent": {
+                    "description": "Parent - the entry it was derived from",
+                    "type": "string",
+                    "example": "default google.com IN A"
+                },
+                "refresh_at": {
+                    "type": "integer",
+                    "example": 1700000000000
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                },
+                "subnets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "192.0.2.0/24"
+                    ]
+                },
+                "ttl": {
+                    "description": "Ttl - the lowest ttl of the last results, 0 if not resolved yet",
+                    "type": "integer",
+                    "example": 300
+                },
+                "type": {
+                    "type": "string",
+                    "example": "A"
+                }
+            }
+        },
+        "main.DrainResult": {
+            "type": "object",
+            "properties": {
+                "drained": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "in_flight": {
+                    "description": "InFlight - refreshes in flight when the drain started",
+                    "type": "integer",
+                    "example": 12
+                },
+                "remaining": {
+                    "description": "Remaining - refreshes still in flight when the drain returned",
+                    "type": "integer",
+                    "example": 0
+                },
+                "waited_ms": {
+                    "type": "integer",
+                    "example": 230
+                }
+            }
+        },
+        "main.Event": {
+            "type": "object",
+            "properties": {
+                "domain": {
+                    "type": "string",
+                    "example": "google.com"
+                },
+                "group": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "record_type": {
+                    "type": "string",
+                    "example": "A"
+                },
+                "result": {
+                    "$ref": "#/definitions/main.QueryResult"
+                },
+                "subnet": {
+                    "type": "string",
+                    "example": "192.0.2.0/24"
+                },
+                "target": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "time": {
+                    "type": "integer",
+                    "example": 1700000000000
+                },
+                "type": {
+                    "type": "string",
+                    "example": "result"
+                }
+            }
+        },
+        "main.GroupScheduleStatus": {
+            "type": "object",
+            "properties": {
+                "base_rate_limit": {
+                    "description": "BaseRateLimit - the configured refreshes per second outside of windows",
+                    "type": "integer",
+                    "example": 10
+                },
+                "name": {
+                    "type": "string",
+                    "example": "critical"
+                },
+                "paused": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "rate_limit": {
+                    "description": "RateLimit - the effective refreshes per second, 0 is unlimited",
+                    "type": "integer",
+                    "example": 1000
+                },
+                "window": {
+                    "type": "string",
+                    "example": "maintenance"
+                }
+            }
+        },
+        "main.ImportError": {
+            "type": "object",
+            "properties": {
+                "entry": {
+                    "type": "string",
+                    "example": "example.com AAA"
+                },
+                "error": {
+                    "type": "string",
+                    "example": "unknown type AAA"
+                },
+                "line": {
+                    "type": "integer",
+                    "example": 17
+                }
+            }
+        },
+        "main.LearnDefinition": {
+            "type": "object",
+            "properties": {
+                "names": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "host1.corp.example"
+                    ]
+                }
+            }
+        },
+        "main.ProbeGroupResult": {
+            "type": "object",
+            "properties": {
+                "cached": {
+                    "type": "integer",
+                    "example": 93
+                },
+                "cached_fraction": {
+                    "type": "number",
+                    "example": 0.93
+                },
+                "errors": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "probed": {
+                    "type": "integer",
+                    "example": 100
+                }
+            }
+        },
+        "main.ProbeResult": {
+            "type": "object",
+            "properties": {
+                "groups": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.ProbeGroupResult"
+                    }
+                },
+                "pass": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "target": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "threshold": {
+                    "type": "number",
+                    "example": 0.9
+                },
+                "total": {
+                    "$ref": "#/definitions/main.ProbeGroupResult"
+                }
+            }
+        },
+        "main.QuarantinedDomainDefinition": {
+            "type": "object",
+            "properties": {
+                "domain": {
+                    "type": "string",
+                    "example": "google.com"
+                },
+                "failures": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "last_error": {
+                    "type": "string",
+                    "example": "regular: received no rr for regular lookup (rcode SERVFAIL)"
+                },
+                "quarantined_at": {
+                    "type": "integer",
+                    "example": 1700000000000
+                },
+                "recheck_at": {
+                    "type": "integer",
+                    "example": 1700086400000
+                },
+                "subnet": {
+                    "type": "string",
+                    "example": "192.0.2.0/24"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "A"
+                }
+            }
+        },
+        "main.QueryResult": {
+            "type": "object",
+            "properties": {
+                "answer_ttl": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "answers": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "cache": {
+                    "description": "Cache - whether the target answered from its cache (hit/miss)",
+                    "type": "string",
+                    "example": "miss"
+                },
+                "error": {
+                    "type": "string",
+                    "example": ""
+                },
+                "latency_ms": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "pinned": {
+                    "description": "Pinned - Ttl was raised above the answer ttl (e.g. by PinMinTtl)",
+                    "type": "boolean",
+                    "example": true
+                },
+                "rcode": {
+                    "type": "string",
+                    "example": "NOERROR"
+                },
+                "strategy": {
+                    "type": "string",
+                    "example": "regular"
+                },
+                "target": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "time": {
+                    "type": "integer",
+                    "example": 1700000000000
+                },
+                "ttl": {
+                    "description": "Ttl - the delay in seconds until the domain is refreshed",
+                    "type": "integer",
+                    "example": 10
+                }
+            }
+        },
+        "main.RefreshJob": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer",
+                    "example": 1700000000000
+                },
+                "done": {
+                    "type": "integer",
+                    "example": 40
+                },
+                "finished": {
+                    "type": "integer",
+                    "example": 1700000002000
+                },
+                "id": {
+                    "type": "string",
+                    "example": "refresh-1"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "main.RefreshSelector": {
+            "type": "object",
+            "properties": {
+                "group": {
+                    "type": "string",
+                    "example": "critical"
+                },
+                "names": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "google.com"
+                    ]
+                },
+                "regex": {
+                    "type": "string",
+                    "example": "^www[0-9]*\\."
+                },
+                "suffix": {
+                    "type": "string",
+                    "example": "example.com"
+                }
+            }
+        },
+        "main.Response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "main.ResponseError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "error \u003cerror msg here\u003e"
+                }
+            }
+        },
+        "main.ResponseWithDiff": {
+            "type": "object",
+            "properties": {
+                "candidate": {
+                    "type": "string",
+                    "example": "new"
+                },
+                "counts": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "mismatches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.AnswerMismatch"
+                    }
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "old"
+                }
+            }
+        },
+        "main.ResponseWithDomainPage": {
+            "type": "object",
+            "properties": {
+                "domains": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DomainListEntry"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "next_cursor": {
+                    "description": "NextCursor - pass as cursor to get the next page, empty on the last page",
+                    "type": "string",
+                    "example": "eyJ2IjoxNzAwMDAwMDAwMDAwLCJrIjoiZGVmYXVsdCBnb29nbGUuY29tIElOIEEifQ"
+                },
+                "total": {
+                    "description": "Total - the number of domains matching the filters",
+                    "type": "integer",
+                    "example": 523114
+                }
+            }
+        },
+        "main.ResponseWithDrain": {
+            "type": "object",
+            "properties": {
+                "drain": {
+                    "$ref": "#/definitions/main.DrainResult"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "paused": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "paused_targets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "resolver1"
+                    ]
+                }
+            }
+        },
+        "main.ResponseWithHistory": {
+            "type": "object",
+            "properties": {
+                "domains": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DomainHistoryDefinition"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "main.ResponseWithImport": {
+            "type": "object",
+            "properties": {
+                "added": {
+                    "description": "Added - the number of added queue entries, an entry with several subnets adds one per subnet",
+                    "type": "integer",
+                    "example": 1200
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ImportError"
+                    }
+                },
+                "errors_total": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "removed": {
+                    "description": "Removed - the number of queue entries removed by mode replace, none if a line failed",
+                    "type": "integer",
+                    "example": 13
+                },
+                "skipped": {
+                    "description": "Skipped - the number of entries which were already queued or imported",
+                    "type": "integer",
+                    "example": 52000
+                }
+            }
+        },
+        "main.ResponseWithLearn": {
+            "type": "object",
+            "properties": {
+                "enrolled": {
+                    "description": "Enrolled - names for which a matching rule queued at least one entry",
+                    "type": "integer",
+                    "example": 12
+                },
+                "excluded": {
+                    "description": "Excluded - names matching an exclusion rule",
+                    "type": "integer",
+                    "example": 40
+                },
+                "invalid": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "queued": {
+                    "description": "Queued - names matching a rule whose entries were all queued already",
+                    "type": "integer",
+                    "example": 300
+                },
+                "unmatched": {
+                    "type": "integer",
+                    "example": 5000
+                }
+            }
+        },
+        "main.ResponseWithProbe": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "probe": {
+                    "$ref": "#/definitions/main.ProbeResult"
+                }
+            }
+        },
+        "main.ResponseWithQuarantine": {
+            "type": "object",
+            "properties": {
+                "domains": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.QuarantinedDomainDefinition"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "main.ResponseWithRefreshJob": {
+            "type": "object",
+            "properties": {
+                "job": {
+                    "$ref": "#/definitions/main.RefreshJob"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                }
+            }
+        },
+        "main.ResponseWithSchedule": {
+            "type": "object",
+            "properties": {
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.GroupScheduleStatus"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScheduleRuleStatus"
+                    }
+                },
+                "windows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScheduleWindow"
+                    }
+                }
+            }
+        },
+        "main.ResponseWithSchedulerState": {
+            "type": "object",
+            "properties": {
+                "in_flight": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "message": {
+                    "type": "string",
                     "example": "success"
+                },
+                "paused": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "paused_targets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "resolver1"
+                    ]
+                },
+                "spread": {
+                    "description": "Spread - overdue domains spread out at the rate limit on resume",
+                    "type": "integer",
+                    "example": 0
                 }
             }
         },
@@ -198,6 +2043,263 @@ const docTemplate = `{
                     "example": 1
                 }
             }
+        },
+        "main.ResponseWithSubscription": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "subscription": {
+                    "$ref": "#/definitions/main.Subscription"
+                }
+            }
+        },
+        "main.ResponseWithSubscriptions": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "subscriptions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.Subscription"
+                    }
+                }
+            }
+        },
+        "main.ResponseWithTargets": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "targets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.TargetDefinition"
+                    }
+                }
+            }
+        },
+        "main.ResponseWithWindow": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "window": {
+                    "$ref": "#/definitions/main.ScheduleWindow"
+                }
+            }
+        },
+        "main.ScheduleRuleStatus": {
+            "type": "object",
+            "properties": {
+                "cron": {
+                    "type": "string",
+                    "example": "0 2 * * 6"
+                },
+                "duration_seconds": {
+                    "type": "integer",
+                    "example": 3600
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "critical"
+                    ]
+                },
+                "name": {
+                    "type": "string",
+                    "example": "maintenance"
+                },
+                "next": {
+                    "type": "integer",
+                    "example": 1700000000
+                },
+                "pause": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "rate_limit": {
+                    "type": "integer",
+                    "example": 1000
+                }
+            }
+        },
+        "main.ScheduleWindow": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "type": "integer",
+                    "example": 1700003600
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "critical"
+                    ]
+                },
+                "id": {
+                    "type": "string",
+                    "example": "maintenance"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "rule"
+                },
+                "pause": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "rate_limit": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "start": {
+                    "type": "integer",
+                    "example": 1700000000
+                }
+            }
+        },
+        "main.Subscription": {
+            "type": "object",
+            "properties": {
+                "enrolled": {
+                    "description": "Enrolled - the number of names enrolled by the rule",
+                    "type": "integer",
+                    "example": 42
+                },
+                "group": {
+                    "description": "Group - defaults to the default group, whose client subnets apply if no subnets are given",
+                    "type": "string",
+                    "example": "corp"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "subscription-1"
+                },
+                "last_enrolled": {
+                    "type": "integer",
+                    "example": 1700000000000
+                },
+                "pattern": {
+                    "description": "Pattern - '*.corp.example' matches names below corp.example, '.corp.example' also corp.example itself",
+                    "type": "string",
+                    "example": "*.corp.example"
+                },
+                "source": {
+                    "description": "Source - file (DomainsFile) or api",
+                    "type": "string",
+                    "example": "file"
+                },
+                "subnets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "192.0.2.0/24"
+                    ]
+                },
+                "types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "A",
+                        "AAAA"
+                    ]
+                }
+            }
+        },
+        "main.SubscriptionDefinition": {
+            "type": "object",
+            "properties": {
+                "group": {
+                    "description": "Group - defaults to the default group, whose client subnets apply if no subnets are given",
+                    "type": "string",
+                    "example": "corp"
+                },
+                "pattern": {
+                    "description": "Pattern - '*.corp.example' matches names below corp.example, '.corp.example' also corp.example itself",
+                    "type": "string",
+                    "example": "*.corp.example"
+                },
+                "subnets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "192.0.2.0/24"
+                    ]
+                },
+                "types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "A",
+                        "AAAA"
+                    ]
+                }
+            }
+        },
+        "main.TargetDefinition": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string",
+                    "example": "127.0.0.1:53"
+                },
+                "cache_classified": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "cache_hit_ratio": {
+                    "type": "number",
+                    "example": 0.85
+                },
+                "cache_probe": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "dnssec": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "id": {
+                    "type": "string",
+                    "example": "default"
+                },
+                "protocol": {
+                    "type": "string",
+                    "example": "udp"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "'Bearer \u003ctoken\u003e' - required if ApiTokens are configured",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -212,6 +2314,8 @@ var SwaggerInfo = &swag.Spec{
 	Description:      "A lightweight api for the syringe daemon",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
+	LeftDelim:        "{{",
+	RightDelim:       "}}",
 }
 
 func init() {
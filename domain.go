@@ -1,42 +1,191 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
 	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DefaultGroupName - the group of domains without explicit group
+const DefaultGroupName = "default"
+
 type Domain struct {
 	Record_name string `json:"Record_name" example:"google.com"`
 	Record_type string `json:"Record_type" example:"A"`
 	Refresh_at  int64  `json:"Refresh_at" example:"1234567"`
-	index       int
+	// Dnssec_status - validation status (secure/insecure) per dnssec-aware target
+	Dnssec_status map[string]string `json:"Dnssec_status,omitempty"`
+	// Rrsig_expires_at - earliest signature expiration (unix seconds) of the last answer per dnssec-aware target
+	Rrsig_expires_at map[string]int64 `json:"Rrsig_expires_at,omitempty"`
+	// Client_subnet - sent as EDNS0 CLIENT-SUBNET option, each subnet of a domain is a separate entry
+	Client_subnet string `json:"Client_subnet,omitempty" example:"192.0.2.0/24"`
+	// Ecs_scope - the narrowest scope prefix length the targets returned for Client_subnet in the last refresh
+	Ecs_scope uint8 `json:"Ecs_scope,omitempty"`
+	// ecs_scoped - a target returned a scope in the last refresh
+	ecs_scoped bool
+	// Last_result - the last query result per target
+	Last_result map[string]QueryResult `json:"Last_result,omitempty"`
+	// history - the most recent query results, a ring starting at
+	// history_start once it is full, see HistoryEntries
+	history       []QueryResult
+	history_start int
+	// Consecutive_failures - refreshes in a row in which no target resolved the domain
+	Consecutive_failures uint `json:"Consecutive_failures,omitempty"`
+	// Quarantined - the domain is only rechecked every QuarantineRecheckSeconds
+	Quarantined    bool  `json:"Quarantined,omitempty"`
+	Quarantined_at int64 `json:"Quarantined_at,omitempty"`
+	// Original_ttl - the highest answer ttl observed per target, used to detect cached answers
+	Original_ttl map[string]uint `json:"Original_ttl,omitempty"`
+	// Parent - the key of the domain this entry was derived from, empty for domains added explicitly
+	Parent string `json:"Parent,omitempty"`
+	// Derived - companion (a companion type of the parent) or target (named in an HTTPS, SVCB or MX answer of the parent)
+	Derived string `json:"Derived,omitempty"`
+	// Group - the group whose targets, strategies and rate limit apply, empty for the default group
+	Group    string `json:"Group,omitempty" example:"critical"`
+	index    int
+	response *dns.Msg
+	rtt      time.Duration
+	cache    string
+	// queued_at - unix nanoseconds at which the domain was added, copies
+	// added before a removal of the domain are dropped
+	queued_at int64
+	// ctx - the trace context of the running refresh
+	ctx context.Context
+	// refresh_jobs - the ids of the refresh jobs waiting for the next refresh
+	refresh_jobs []string
 }
 
+// Validate returns whether the domain can be queued, see Normalize
 func (domain Domain) Validate() bool {
-	// we currently don't validate domain names
-	if _, ok := dns.StringToType[strings.ToUpper(domain.Record_type)]; ok {
-		return true
+	return domain.Normalize() == nil
+}
+
+// Normalize validates the domain and normalizes its name (see
+// NormalizeDomainName) and record type
+func (domain *Domain) Normalize() error {
+	name, err := NormalizeDomainName(domain.Record_name)
+	if err != nil {
+		return err
+	}
+	if domain.Client_subnet != "" {
+		if err := ValidateClientSubnet(domain.Client_subnet); err != nil {
+			return err
+		}
 	}
-	return false
+	if _, err := FindGroup(domain.Group); err != nil {
+		return err
+	}
+	rr_type := strings.ToUpper(domain.Record_type)
+	if _, ok := dns.StringToType[rr_type]; !ok {
+		return fmt.Errorf("unknown type %s", domain.Record_type)
+	}
+	domain.Record_name = name
+	domain.Record_type = rr_type
+	return nil
+}
 
+// Query resolves the domain on every target which is not paused and sends
+// the delay until the next refresh to c: the lowest ttl if any target
+// resolved the domain, otherwise the backoff delay
+func (domain *Domain) Query(targets []*Target, strategyContainer *ResolverStrategies, config *ResolverConfiguration, c chan<- uint) {
+	parent := domain.traceContext()
+	var span trace.Span
+	domain.ctx, span = tracer.Start(parent, "Domain.Query", trace.WithAttributes(
+		attributeQname.String(domain.Record_name),
+		attributeQtype.String(domain.Record_type),
+		attributeGroup.String(domain.GroupName()),
+	))
+	ttl := domain.query(targets, strategyContainer, config, span)
+	span.End()
+	domain.ctx = parent
+	c <- ttl
+}
+
+func (domain *Domain) query(targets []*Target, strategyContainer *ResolverStrategies, config *ResolverConfiguration, span trace.Span) uint {
+	var ttl uint = 0
+	var fallback_ttl uint = 0
+	if delay, ok := domain.EcsCovered(); ok {
+		// the answer of another client subnet is cached for a scope covering this one
+		span.AddEvent("covered by the scope of another client subnet")
+		ecsCoveredRefreshes.Inc()
+		return delay
+	}
+	domain.Ecs_scope, domain.ecs_scoped = 0, false
+	active := ActiveTargets(targets)
+	if len(active) == 0 {
+		// all targets were paused after dispatching, retry soon without counting a failure
+		span.AddEvent("all targets paused")
+		return 1
+	}
+	for _, target := range active {
+		target.inFlight.Add(1)
+		target_ttl, ok := domain.QueryTarget(target, strategyContainer, config)
+		target.inFlight.Add(-1)
+		if !ok {
+			if fallback_ttl == 0 || target_ttl < fallback_ttl {
+				fallback_ttl = target_ttl
+			}
+			continue
+		}
+		if ttl == 0 || target_ttl < ttl {
+			ttl = target_ttl
+		}
+	}
+	domain.RecordEcsScope(ttl)
+	if ttl == 0 {
+		span.SetStatus(codes.Error, "no target resolved the domain")
+		return domain.RecordFailure(config, fallback_ttl)
+	}
+	domain.RecordSuccess()
+	return ttl
 }
 
-func (domain Domain) Query(strategyContainer *ResolverStrategies, config *ResolverConfiguration, c chan<- uint) {
+// QueryTarget runs the strategies against a single target until one succeeds.
+// ok is false if no strategy succeeded or the ttl is from a fallback strategy.
+func (domain *Domain) QueryTarget(target *Target, strategyContainer *ResolverStrategies, config *ResolverConfiguration) (uint, bool) {
+	var errs []string
+	ctx := domain.traceContext()
+	defer func() { domain.ctx = ctx }()
 	for i := 0; i < len(strategyContainer.ResolveFunctions); i++ {
 		strategy := strategyContainer.ResolveFunctions[i]
-		ttl, err := strategy(config, &domain)
-		log.Trace("resolve ", domain.ToString(), " via strategy index ", i, " yields ttl=", ttl, " err=", err)
+		// the result describes the exchange of this strategy only
+		domain.response, domain.rtt, domain.cache = nil, 0, ""
+		var span trace.Span
+		domain.ctx, span = tracer.Start(ctx, "strategy "+strategy.Name, trace.WithAttributes(
+			attributeStrategy.String(strategy.Name),
+			attributeTarget.String(target.Id),
+		))
+		ttl, err := strategy.Resolve(config, target, domain)
+		endSpan(span, err)
+		log.WithContext(domain.ctx).Trace("resolve ", domain.ToString(), " on target ", target.Id, " via strategy ", strategy.Name, " yields ttl=", ttl, " err=", err)
 		if err != nil {
+			errs = append(errs, strategy.Name+": "+err.Error())
 			continue
 		}
-		c <- ttl
-		return
+		domainsResolvedByStrategy.With(prometheus.Labels{"strategy": strategy.Name}).Inc()
+		result := domain.NewQueryResult(target, strategy.Name, ttl, errs)
+		domain.RecordResult(result, config.QueryHistorySize)
+		if strategy.Fallback {
+			// the domain did not resolve, the ttl is only a retry delay
+			PublishDomainEvent(EventError, domain, target, &result)
+		} else {
+			PublishDomainEvent(EventResult, domain, target, &result)
+		}
+		return ttl, !strategy.Fallback
 	}
-	c <- uint(config.StaticDelaySeconds)
+	result := domain.NewQueryResult(target, "", uint(config.StaticDelaySeconds), errs)
+	domain.RecordResult(result, config.QueryHistorySize)
+	PublishDomainEvent(EventError, domain, target, &result)
+	return uint(config.StaticDelaySeconds), false
 }
 
 func (domain *Domain) RefreshInSeconds(seconds uint) {
@@ -60,6 +209,59 @@ func (domain Domain) SecondsUntilDue() int64 {
 	return int64(domain.MillisUntilDue() / 1000)
 }
 
+// GroupName returns the name of the group the domain belongs to
+func (domain Domain) GroupName() string {
+	if domain.Group == "" {
+		return DefaultGroupName
+	}
+	return domain.Group
+}
+
+// Status returns quarantined, failing (the last refresh failed), pending
+// (not resolved yet) or ok
+func (domain Domain) Status() string {
+	switch {
+	case domain.Quarantined:
+		return DomainStatusQuarantined
+	case domain.Consecutive_failures > 0:
+		return DomainStatusFailing
+	case len(domain.Last_result) == 0:
+		return DomainStatusPending
+	}
+	return DomainStatusOk
+}
+
+// LastTtl returns the lowest ttl of the last results, 0 if there are none
+func (domain Domain) LastTtl() uint {
+	var ttl uint = 0
+	for _, result := range domain.Last_result {
+		if ttl == 0 || result.Ttl < ttl {
+			ttl = result.Ttl
+		}
+	}
+	return ttl
+}
+
+// snapshot returns a copy sharing no maps or history with the domain, which
+// stays consistent while the domain itself is refreshed
+func (domain Domain) snapshot() Domain {
+	domain.Dnssec_status = maps.Clone(domain.Dnssec_status)
+	domain.Rrsig_expires_at = maps.Clone(domain.Rrsig_expires_at)
+	domain.Last_result = maps.Clone(domain.Last_result)
+	domain.Original_ttl = maps.Clone(domain.Original_ttl)
+	domain.history = slices.Clone(domain.history)
+	domain.refresh_jobs = slices.Clone(domain.refresh_jobs)
+	return domain
+}
+
+// Key identifies a queue entry: group, name, type and client subnet
+func (domain Domain) Key() string {
+	return domain.GroupName() + " " + domain.ToString()
+}
+
 func (domain Domain) ToString() string {
+	if domain.Client_subnet != "" {
+		return fmt.Sprintf("%s IN %s (ecs %s)", domain.Record_name, domain.Record_type, domain.Client_subnet)
+	}
 	return fmt.Sprintf("%s IN %s", domain.Record_name, domain.Record_type)
 }
@@ -3,6 +3,9 @@ package main
 import (
 	"container/heap"
 	"fmt"
+	"maps"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -12,8 +15,8 @@ var (
 	queueCandidateTimes = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace: "syringe",
-			Name:      "queue_candidate_timer",
-			Help:      "queue_candidate_timer",
+			Name:      "queue_candidate_seconds",
+			Help:      "The seconds until a domain pushed onto the heap is due",
 			Buckets:   []float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600},
 		})
 )
@@ -43,9 +46,13 @@ func (pq *DomainHeap) Pop() interface{} {
 	return item
 }
 
-func (pq *DomainHeap) PopDomain() Domain {
+// PopDomain pops the domain due first, ok is false if the heap is empty
+func (pq *DomainHeap) PopDomain() (Domain, bool) {
+	if pq.Len() == 0 {
+		return Domain{}, false
+	}
 	var domain *Domain = heap.Pop(pq).(*Domain)
-	return *domain
+	return *domain, true
 }
 
 func (pq *DomainHeap) PushDomain(d *Domain) {
@@ -84,7 +91,12 @@ func (h DomainHeap) Dump() string {
 
 type heapPopChanMsg struct {
 	h      *DomainHeap
-	result chan Domain
+	result chan heapPopResult
+}
+
+type heapPopResult struct {
+	d  Domain
+	ok bool
 }
 
 // heapPushChanMsg - the message structure for a push chan
@@ -93,9 +105,121 @@ type heapPushChanMsg struct {
 	x Domain
 }
 
-func (dh *DomainHeap) AddDomain(d Domain) {
+// heapDoChanMsg - the message structure for a do chan
+type heapDoChanMsg struct {
+	h    *DomainHeap
+	f    func(h *DomainHeap)
+	done chan bool
+}
+
+// removedDomainTtl - how long removed domains are remembered, long enough
+// for copies out of the heap (ready or in flight) to return
+const removedDomainTtl = 10 * time.Minute
+
+// removedDomains - keys of removed domains and when (unix nanoseconds) they were removed
+var removedDomains = struct {
+	sync.RWMutex
+	keys map[string]int64
+}{keys: make(map[string]int64)}
+
+// queuedKeys - the keys of the queued domains, including ready and in-flight
+// domains, and the keys of derived domains which were added explicitly
+// meanwhile, see promote
+var queuedKeys = struct {
+	sync.RWMutex
+	keys     map[string]bool
+	promoted map[string]bool
+}{keys: make(map[string]bool), promoted: make(map[string]bool)}
+
+// IsQueued returns whether a domain with the key is queued
+func IsQueued(key string) bool {
+	queuedKeys.RLock()
+	defer queuedKeys.RUnlock()
+	return queuedKeys.keys[key]
+}
+
+// AddDomain queues a new domain unless it is excluded or queued already. An
+// explicitly added domain which is queued as derived domain takes over the
+// derived entry. The companion types of its group are enrolled, too.
+func (dh *DomainHeap) AddDomain(d Domain) error {
+	if err := CheckExcluded(d.Record_name); err != nil {
+		return err
+	}
+	queuedKeys.Lock()
+	if queuedKeys.keys[d.Key()] {
+		if d.Parent == "" {
+			queuedKeys.promoted[d.Key()] = true
+		}
+		queuedKeys.Unlock()
+		if d.Parent == "" {
+			dh.AddCompanions(d)
+		}
+		return nil
+	}
+	queuedKeys.keys[d.Key()] = true
+	queuedKeys.Unlock()
+	dh.enqueue(d)
+	return nil
+}
+
+// addDerived queues a derived domain unless it is excluded or a domain with
+// its key is queued
+func (dh *DomainHeap) addDerived(d Domain) bool {
+	if CheckExcluded(d.Record_name) != nil {
+		return false
+	}
+	queuedKeys.Lock()
+	if queuedKeys.keys[d.Key()] {
+		queuedKeys.Unlock()
+		return false
+	}
+	queuedKeys.keys[d.Key()] = true
+	queuedKeys.Unlock()
+	dh.enqueue(d)
+	return true
+}
+
+// enqueue pushes a domain whose key was claimed in queuedKeys
+func (dh *DomainHeap) enqueue(d Domain) {
+	d.queued_at = time.Now().UnixNano()
 	domainsAdded.Inc()
+	PublishDomainEvent(EventAdd, &d, nil, nil)
 	HeapPush(dh, d)
+	if d.Parent == "" {
+		dh.AddCompanions(d)
+	}
+}
+
+// promote turns the domain into an explicitly added one if a domain with its
+// key was added explicitly while it was queued as derived domain. Queued
+// entries are promoted when they pass the scheduler or a scan of the heap;
+// pass only the entry which stays queued, copies are promoted by promoted.
+func promote(d *Domain) {
+	queuedKeys.Lock()
+	defer queuedKeys.Unlock()
+	if len(queuedKeys.promoted) == 0 || !queuedKeys.promoted[d.Key()] {
+		return
+	}
+	delete(queuedKeys.promoted, d.Key())
+	d.Parent, d.Derived = "", ""
+}
+
+// pendingPromotions returns whether domains are to be promoted, see promote
+func pendingPromotions() bool {
+	queuedKeys.RLock()
+	defer queuedKeys.RUnlock()
+	return len(queuedKeys.promoted) > 0
+}
+
+// promoted returns a copy of the domain as explicitly added domain if it is
+// to be promoted, see promote. The pending promotion is kept.
+func promoted(d Domain) Domain {
+	queuedKeys.RLock()
+	defer queuedKeys.RUnlock()
+	if len(queuedKeys.promoted) > 0 && queuedKeys.promoted[d.Key()] {
+		d.Parent, d.Derived = "", ""
+	}
+	return d
 }
 
 func HeapPush(h *DomainHeap, x Domain) {
@@ -105,14 +229,35 @@ func HeapPush(h *DomainHeap, x Domain) {
 	}
 }
 
-// HeapPop - safely pop item from a heap interface
-func HeapPop(h *DomainHeap) interface{} {
-	var result = make(chan Domain)
+// HeapPop - safely pop the domain due first from a heap, ok is false if the
+// heap is empty
+func HeapPop(h *DomainHeap) (Domain, bool) {
+	var result = make(chan heapPopResult)
 	heapPopChan <- heapPopChanMsg{
 		h:      h,
 		result: result,
 	}
-	return <-result
+	popped := <-result
+	return popped.d, popped.ok
+}
+
+// HeapDo - safely run f with exclusive access to a heap. f must restore the
+// heap order (heap.Fix or heap.Init) after changing Refresh_at of entries
+func HeapDo(h *DomainHeap, f func(h *DomainHeap)) {
+	var done = make(chan bool)
+	heapDoChan <- heapDoChanMsg{
+		h:    h,
+		f:    f,
+		done: done,
+	}
+	<-done
+}
+
+// Size returns the number of domains on the heap
+func (dh *DomainHeap) Size() int {
+	size := 0
+	HeapDo(dh, func(h *DomainHeap) { size = h.Len() })
+	return size
 }
 
 // stopWatchHeapOps - stop watching for heap operations
@@ -121,15 +266,103 @@ func (dh *DomainHeap) watchHeapOps() {
 		for {
 			select {
 			case popMsg := <-heapPopChan:
-				d := dh.PopDomain()
-				log.Trace("heap pop ", d.ToString())
-				popMsg.result <- d
+				d, ok := dh.PopDomain()
+				if ok {
+					log.Trace("heap pop ", d.ToString())
+				}
+				popMsg.result <- heapPopResult{d: d, ok: ok}
 			case pushMsg := <-heapPushChan:
 				d := &(pushMsg.x)
 				log.Trace("heap push ", d.ToString())
 				dh.PushDomain(d)
 				queueCandidateTimes.Observe(float64(d.SecondsUntilDue()))
+			case doMsg := <-heapDoChan:
+				doMsg.f(dh)
+				doMsg.done <- true
 			}
 		}
 	}()
 }
+
+// RemoveDomains removes the matching domains and the domains derived from
+// them from the heap. Matching ready or in-flight domains are dropped by the
+// scheduler instead of being pushed back, see Dropped. Returns the number of
+// removed domains.
+func (dh *DomainHeap) RemoveDomains(match func(d *Domain) bool) int {
+	var outside []Domain
+	if scheduler != nil {
+		outside = scheduler.Outside()
+	}
+	parents := make(map[string]bool)
+	for i := range outside {
+		outside[i] = promoted(outside[i])
+		if match(&outside[i]) {
+			parents[outside[i].Key()] = true
+		}
+	}
+	remove := func(d *Domain) bool {
+		return parents[d.Key()] || (d.Parent != "" && parents[d.Parent])
+	}
+
+	var removed []Domain
+	pending := pendingPromotions()
+	HeapDo(dh, func(h *DomainHeap) {
+		for _, d := range *h {
+			if pending {
+				promote(d)
+			}
+			if match(d) {
+				parents[d.Key()] = true
+			}
+		}
+		kept := (*h)[:0]
+		for _, d := range *h {
+			if remove(d) {
+				d.Dropped()
+				removed = append(removed, *d)
+				continue
+			}
+			kept = append(kept, d)
+		}
+		clear((*h)[len(kept):])
+		*h = kept
+		for i, d := range *h {
+			d.index = i
+		}
+		heap.Init(h)
+	})
+	for i := range outside {
+		if remove(&outside[i]) {
+			removed = append(removed, outside[i])
+		}
+	}
+
+	queuedKeys.Lock()
+	for i := range removed {
+		delete(queuedKeys.keys, removed[i].Key())
+		delete(queuedKeys.promoted, removed[i].Key())
+	}
+	queuedKeys.Unlock()
+	now := time.Now().UnixNano()
+	removedDomains.Lock()
+	maps.DeleteFunc(removedDomains.keys, func(key string, at int64) bool { return now-at > removedDomainTtl.Nanoseconds() })
+	for i := range removed {
+		removedDomains.keys[removed[i].Key()] = now
+	}
+	removedDomains.Unlock()
+	for i := range removed {
+		PublishDomainEvent(EventRemove, &removed[i], nil, nil)
+	}
+	return len(removed)
+}
+
+// IsRemoved returns whether the domain was removed after it was added
+func IsRemoved(d Domain) bool {
+	removedDomains.RLock()
+	defer removedDomains.RUnlock()
+	if len(removedDomains.keys) == 0 {
+		return false
+	}
+	at, ok := removedDomains.keys[d.Key()]
+	return ok && d.queued_at <= at
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxDomainNameLength = 253
+	maxLabelLength      = 63
+)
+
+// Policies for reserved names, see ReservedNamePolicy
+const (
+	ReservedNamesReject = "reject"
+	ReservedNamesWarn   = "warn"
+	ReservedNamesAllow  = "allow"
+)
+
+// reservedSuffixes - special-use names (RFC 6761, 6762, 7686, 8375, 9476)
+// which are not resolvable in the global DNS
+var reservedSuffixes = []string{
+	"localhost",
+	"local",
+	"invalid",
+	"test",
+	"onion",
+	"alt",
+	"home.arpa",
+}
+
+// idnaProfile - maps Unicode names to punycode. Underscores are allowed for
+// service names like _dmarc.example.com, double hyphens for cdn names like
+// rr1---sn-4g5e6nz7.googlevideo.com.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+	idna.CheckHyphens(false),
+	idna.BidiRule(),
+)
+
+// NormalizeDomainName validates a domain name and returns it lower-cased, in
+// punycode and without trailing dot. The root is returned as '.'.
+func NormalizeDomainName(name string) (string, error) {
+	if name == "." {
+		return name, nil
+	}
+	trimmed := strings.TrimSuffix(name, ".")
+	if trimmed == "" {
+		return "", fmt.Errorf("empty domain name")
+	}
+	ascii, err := idnaProfile.ToASCII(trimmed)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain name %q: %w", name, err)
+	}
+	if len(ascii) > maxDomainNameLength {
+		return "", fmt.Errorf("invalid domain name %q: longer than %d characters", name, maxDomainNameLength)
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if err := validateLabel(label); err != nil {
+			return "", fmt.Errorf("invalid domain name %q: %w", name, err)
+		}
+	}
+	if suffix, reserved := ReservedSuffix(ascii); reserved {
+		switch resolverConfiguration.ReservedNamePolicy {
+		case ReservedNamesAllow:
+		case ReservedNamesWarn:
+			log.Warn("domain ", ascii, " is below the special-use name ", suffix)
+		default:
+			return "", fmt.Errorf("domain name %q is below the special-use name %s", name, suffix)
+		}
+	}
+	return ascii, nil
+}
+
+func validateLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("empty label")
+	}
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("label %s longer than %d characters", label, maxLabelLength)
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("label %s starts or ends with a hyphen", label)
+	}
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("label %s contains %q", label, c)
+		}
+	}
+	return nil
+}
+
+// ReservedSuffix returns the special-use name the normalized name is equal to or below
+func ReservedSuffix(name string) (string, bool) {
+	for _, suffix := range reservedSuffixes {
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return suffix, true
+		}
+	}
+	return "", false
+}
+
+// LookupDomainName returns the normalized name for comparisons with queued
+// domains, or the lower-cased name if it is invalid
+func LookupDomainName(name string) string {
+	if normalized, err := idnaProfile.ToASCII(strings.TrimSuffix(name, ".")); err == nil && normalized != "" {
+		return normalized
+	}
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// withReservedNamePolicy sets the ReservedNamePolicy for the test
+func withReservedNamePolicy(t *testing.T, policy string) {
+	previous := resolverConfiguration.ReservedNamePolicy
+	resolverConfiguration.ReservedNamePolicy = policy
+	t.Cleanup(func() { resolverConfiguration.ReservedNamePolicy = previous })
+}
+
+func TestNormalizeDomainName(t *testing.T) {
+	withReservedNamePolicy(t, ReservedNamesReject)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com"},
+		{".", "."},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"_dmarc.example.com", "_dmarc.example.com"},
+		{"rr1---sn-4g5e6nz7.googlevideo.com", "rr1---sn-4g5e6nz7.googlevideo.com"},
+		{strings.Repeat("a", 63) + ".com", strings.Repeat("a", 63) + ".com"},
+	}
+	for _, test := range tests {
+		if got, err := NormalizeDomainName(test.name); err != nil || got != test.want {
+			t.Errorf("NormalizeDomainName(%s) = %s %v, want %s", test.name, got, err, test.want)
+		}
+	}
+
+	long := strings.Repeat(strings.Repeat("a", 50)+".", 5) + "com"
+	for _, name := range []string{
+		"",
+		"..",
+		"example..com",
+		"-example.com",
+		"example-.com",
+		"exa mple.com",
+		"exa$mple.com",
+		strings.Repeat("a", 64) + ".com",
+		long,
+		"printer.local",
+		"localhost",
+		"router.home.arpa",
+	} {
+		if got, err := NormalizeDomainName(name); err == nil {
+			t.Errorf("NormalizeDomainName(%s) accepted an invalid name as %s", name, got)
+		}
+	}
+}
+
+func TestReservedNamePolicy(t *testing.T) {
+	for _, policy := range []string{ReservedNamesWarn, ReservedNamesAllow} {
+		withReservedNamePolicy(t, policy)
+		if got, err := NormalizeDomainName("Printer.Local"); err != nil || got != "printer.local" {
+			t.Errorf("NormalizeDomainName(Printer.Local) with policy %s = %s %v", policy, got, err)
+		}
+	}
+	// names merely ending like a special-use name are no special-use names
+	withReservedNamePolicy(t, ReservedNamesReject)
+	for _, name := range []string{"nottest.com", "test.example", "local.example.com"} {
+		if _, err := NormalizeDomainName(name); err != nil {
+			t.Errorf("NormalizeDomainName(%s) = %v", name, err)
+		}
+	}
+}
+
+func TestLookupDomainName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Example.COM.", "example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"-Invalid-.COM", "-invalid-.com"},
+	}
+	for _, test := range tests {
+		if got := LookupDomainName(test.name); got != test.want {
+			t.Errorf("LookupDomainName(%s) = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDomainNormalize(t *testing.T) {
+	withReservedNamePolicy(t, ReservedNamesReject)
+	domain := Domain{Record_name: "WWW.Example.com.", Record_type: "aaaa"}
+	if err := domain.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if domain.Record_name != "www.example.com" || domain.Record_type != "AAAA" {
+		t.Errorf("normalized domain %s %s, want www.example.com AAAA", domain.Record_name, domain.Record_type)
+	}
+
+	for _, invalid := range []Domain{
+		{Record_name: "example.com", Record_type: "AAA"},
+		{Record_name: "example..com", Record_type: "A"},
+		{Record_name: "example.com", Record_type: "A", Client_subnet: "192.0.2.1"},
+		{Record_name: "example.com", Record_type: "A", Group: "unknown"},
+	} {
+		if invalid.Validate() {
+			t.Errorf("%+v was accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ecsResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "ecs_responses_total",
+		Help:      "The total number of responses to queries with a client subnet by returned scope",
+	},
+		[]string{"target", "subnet", "scope"},
+	)
+	ecsCoveredRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "ecs_covered_refreshes_total",
+		Help:      "The total number of skipped refreshes of client subnets covered by the scope returned for another client subnet",
+	})
+)
+
+func init() {
+	prometheus.Register(ecsResponses)
+	prometheus.Register(ecsCoveredRefreshes)
+}
+
+// ecsCover - the answer for subnet is cached for the broader network until
+// the unix time in milliseconds
+type ecsCover struct {
+	subnet  string
+	network netip.Prefix
+	until   int64
+}
+
+// ecsCovers - the covers of the client subnets of a domain, by the key of
+// the domain without client subnet
+var ecsCovers = struct {
+	sync.Mutex
+	covers map[string][]ecsCover
+}{covers: make(map[string][]ecsCover)}
+
+// ecsKey returns the key of the domain without client subnet
+func (domain Domain) ecsKey() string {
+	return domain.GroupName() + " " + domain.Record_name + " IN " + domain.Record_type
+}
+
+// RecordEcsScope records that the answer of the refresh is cached for the
+// returned scope for ttl seconds. A scope broader than the client subnet
+// covers the other client subnets of the domain within it.
+func (domain *Domain) RecordEcsScope(ttl uint) {
+	if domain.Client_subnet == "" {
+		return
+	}
+	now := time.Now().UnixMilli()
+	key := domain.ecsKey()
+	ecsCovers.Lock()
+	defer ecsCovers.Unlock()
+	covers := slices.DeleteFunc(ecsCovers.covers[key], func(c ecsCover) bool {
+		return c.subnet == domain.Client_subnet || c.until <= now
+	})
+	if subnet, err := netip.ParsePrefix(domain.Client_subnet); err == nil && domain.ecs_scoped && ttl > 0 && int(domain.Ecs_scope) < subnet.Bits() {
+		network, _ := subnet.Addr().Prefix(int(domain.Ecs_scope))
+		covers = append(covers, ecsCover{subnet: domain.Client_subnet, network: network, until: now + int64(ttl)*1000})
+	}
+	if len(covers) == 0 {
+		delete(ecsCovers.covers, key)
+	} else {
+		ecsCovers.covers[key] = covers
+	}
+}
+
+// EcsCovered returns the seconds until the answer of another client subnet,
+// whose returned scope covers the client subnet of the domain, expires. The
+// refresh of a covered domain is skipped, it would warm the same cache slice.
+func (domain *Domain) EcsCovered() (uint, bool) {
+	if domain.Client_subnet == "" {
+		return 0, false
+	}
+	subnet, err := netip.ParsePrefix(domain.Client_subnet)
+	if err != nil {
+		return 0, false
+	}
+	now := time.Now().UnixMilli()
+	ecsCovers.Lock()
+	defer ecsCovers.Unlock()
+	covers := ecsCovers.covers[domain.ecsKey()]
+	for _, c := range covers {
+		if c.subnet != domain.Client_subnet && c.until > now && c.network.Bits() <= subnet.Bits() && c.network.Contains(subnet.Addr()) {
+			// the domain does not refresh its own scope while it is covered
+			ecsCovers.covers[domain.ecsKey()] = slices.DeleteFunc(covers, func(c ecsCover) bool { return c.subnet == domain.Client_subnet })
+			return uint((c.until-now)/1000) + 1, true
+		}
+	}
+	return 0, false
+}
+
+// ValidateClientSubnet checks whether subnet is a valid CIDR prefix
+func ValidateClientSubnet(subnet string) error {
+	if _, _, err := net.ParseCIDR(subnet); err != nil {
+		return fmt.Errorf("invalid client subnet %s: %w", subnet, err)
+	}
+	return nil
+}
+
+// ExpandClientSubnets returns one domain per client subnet, as every
+// (domain, subnet) pair is scheduled independently. Without subnets the
+// domain is returned as is.
+func ExpandClientSubnets(domain Domain, subnets []string) []Domain {
+	if len(subnets) == 0 {
+		return []Domain{domain}
+	}
+	var result []Domain
+	for _, subnet := range subnets {
+		d := domain
+		d.Client_subnet = subnet
+		result = append(result, d)
+	}
+	return result
+}
+
+// NewEcsOption builds an EDNS0 CLIENT-SUBNET option (RFC 7871) for subnet
+func NewEcsOption(subnet string) (*dns.EDNS0_SUBNET, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipnet.Mask.Size()
+	option := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+		SourceScope:   0,
+	}
+	if ip4 := ipnet.IP.To4(); ip4 != nil {
+		option.Family = 1
+		option.Address = ip4
+	} else {
+		option.Family = 2
+		option.Address = ipnet.IP
+	}
+	return option, nil
+}
+
+// SetEcs adds a CLIENT-SUBNET option for subnet to m
+func SetEcs(m *dns.Msg, subnet string) error {
+	option, err := NewEcsOption(subnet)
+	if err != nil {
+		return err
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt = m.IsEdns0()
+	}
+	opt.Option = append(opt.Option, option)
+	return nil
+}
+
+// EcsScope returns the scope prefix length of the CLIENT-SUBNET option in r
+func EcsScope(r *dns.Msg) (uint8, bool) {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return e.SourceScope, true
+		}
+	}
+	return 0, false
+}
+
+// TrackEcs records the narrowest scope returned for the client subnet of the
+// domain and counts it
+func (domain *Domain) TrackEcs(target *Target, r *dns.Msg) {
+	if domain.Client_subnet == "" {
+		return
+	}
+	scope, ok := EcsScope(r)
+	if !ok {
+		return
+	}
+	if !domain.ecs_scoped || scope > domain.Ecs_scope {
+		domain.Ecs_scope, domain.ecs_scoped = scope, true
+	}
+	ecsResponses.With(prometheus.Labels{"target": target.Id, "subnet": domain.Client_subnet, "scope": strconv.Itoa(int(scope))}).Inc()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidateClientSubnet(t *testing.T) {
+	for _, subnet := range []string{"192.0.2.0/24", "2001:db8::/56"} {
+		if err := ValidateClientSubnet(subnet); err != nil {
+			t.Errorf("ValidateClientSubnet(%s) = %v", subnet, err)
+		}
+	}
+	for _, subnet := range []string{"", "192.0.2.1", "192.0.2.0/33", "example.com/24"} {
+		if err := ValidateClientSubnet(subnet); err == nil {
+			t.Errorf("ValidateClientSubnet(%s) accepted an invalid subnet", subnet)
+		}
+	}
+}
+
+func TestExpandClientSubnets(t *testing.T) {
+	domain := Domain{Record_name: "example.com", Record_type: "A"}
+	if domains := ExpandClientSubnets(domain, nil); len(domains) != 1 || domains[0].Client_subnet != "" {
+		t.Errorf("ExpandClientSubnets without subnets = %v", domains)
+	}
+	domains := ExpandClientSubnets(domain, []string{"192.0.2.0/24", "2001:db8::/56"})
+	if len(domains) != 2 || domains[0].Client_subnet != "192.0.2.0/24" || domains[1].Client_subnet != "2001:db8::/56" {
+		t.Errorf("ExpandClientSubnets = %v", domains)
+	}
+	if domains[0].Key() == domains[1].Key() {
+		t.Errorf("domains of different subnets share the key %s", domains[0].Key())
+	}
+}
+
+func TestNewEcsOption(t *testing.T) {
+	tests := []struct {
+		subnet  string
+		family  uint16
+		netmask uint8
+		address string
+	}{
+		{"192.0.2.0/24", 1, 24, "192.0.2.0"},
+		// host bits are cleared
+		{"192.0.2.77/24", 1, 24, "192.0.2.0"},
+		{"2001:db8::/56", 2, 56, "2001:db8::"},
+	}
+	for _, test := range tests {
+		option, err := NewEcsOption(test.subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if option.Family != test.family || option.SourceNetmask != test.netmask || !option.Address.Equal(net.ParseIP(test.address)) {
+			t.Errorf("NewEcsOption(%s) = family %d, netmask %d, address %s", test.subnet, option.Family, option.SourceNetmask, option.Address)
+		}
+	}
+}
+
+func TestEcsQueryCountsReturnedScope(t *testing.T) {
+	received := make(chan *dns.EDNS0_SUBNET, 1)
+	address := startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if opt := r.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+					received <- e
+					reply := *e
+					reply.SourceScope = 16
+					m.SetEdns0(dns.DefaultMsgSize, false)
+					m.IsEdns0().Option = append(m.IsEdns0().Option, &reply)
+				}
+			}
+		}
+		m.Answer = append(m.Answer, mustRR(t, "example.com. 300 IN A 192.0.2.1"))
+		w.WriteMsg(m)
+	})
+	target := newTestTarget(t, TargetConfiguration{Id: "ecs", Address: address})
+	domain := &Domain{Record_name: "example.com", Record_type: "A", Client_subnet: "198.51.100.0/24"}
+	responses := ecsResponses.With(prometheus.Labels{"target": target.Id, "subnet": domain.Client_subnet, "scope": "16"})
+	before := testutil.ToFloat64(responses)
+
+	if _, err := TryQueryRegularDomain(resolverConfiguration, target, domain); err != nil {
+		t.Fatal(err)
+	}
+	var subnet *dns.EDNS0_SUBNET
+	select {
+	case subnet = <-received:
+	default:
+	}
+	if subnet == nil || subnet.SourceNetmask != 24 || !subnet.Address.Equal(net.ParseIP("198.51.100.0")) {
+		t.Fatalf("the query carried the client subnet %v, want 198.51.100.0/24", subnet)
+	}
+	if got := testutil.ToFloat64(responses) - before; got != 1 {
+		t.Errorf("responses with scope 16 = %v, want 1", got)
+	}
+}
+
+func TestDomainListEntryWithClientSubnets(t *testing.T) {
+	domains, err := DomainListEntryToDomains("example.com A ecs=192.0.2.0/24,2001:db8::/56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 || domains[0].Client_subnet != "192.0.2.0/24" || domains[1].Client_subnet != "2001:db8::/56" {
+		t.Errorf("DomainListEntryToDomains = %v", domains)
+	}
+	if _, err := DomainListEntryToDomains("example.com A ecs=192.0.2.0"); err == nil {
+		t.Error("an entry with an invalid client subnet was accepted")
+	}
+}
+
+// scopedAnswer answers with the client subnet of the query and the scope,
+// queries counts the queries
+func scopedAnswer(t *testing.T, scope uint8, queries *atomic.Int32) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		m := reply(t, r)
+		if opt := r.IsEdns0(); opt != nil {
+			m.SetEdns0(dns.DefaultMsgSize, false)
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+					answered := *e
+					answered.SourceScope = scope
+					m.IsEdns0().Option = append(m.IsEdns0().Option, &answered)
+				}
+			}
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func TestEcsScopeCoversOtherClientSubnets(t *testing.T) {
+	covering := &Domain{Record_name: "example.com", Record_type: "A", Client_subnet: "192.0.2.0/24", Ecs_scope: 16, ecs_scoped: true}
+	covering.RecordEcsScope(300)
+	t.Cleanup(func() { clear(ecsCovers.covers) })
+
+	covered := &Domain{Record_name: "example.com", Record_type: "A", Client_subnet: "192.0.3.0/24"}
+	if delay, ok := covered.EcsCovered(); !ok || delay < 300 || delay > 301 {
+		t.Errorf("EcsCovered of a subnet within the scope = %d %v, want the ttl of the covering answer", delay, ok)
+	}
+	for _, d := range []Domain{
+		*covering,
+		{Record_name: "example.com", Record_type: "A", Client_subnet: "198.51.100.0/24"},
+		{Record_name: "example.com", Record_type: "A", Client_subnet: "2001:db8::/56"},
+		{Record_name: "example.com", Record_type: "AAAA", Client_subnet: "192.0.3.0/24"},
+		{Record_name: "example.com", Record_type: "A", Client_subnet: "192.0.0.0/15"},
+	} {
+		if _, ok := d.EcsCovered(); ok {
+			t.Errorf("%s is covered by the scope of 192.0.2.0/24", d.ToString())
+		}
+	}
+
+	// a scope as narrow as the client subnet covers no other subnet
+	narrow := &Domain{Record_name: "example.net", Record_type: "A", Client_subnet: "192.0.2.0/24", Ecs_scope: 24, ecs_scoped: true}
+	narrow.RecordEcsScope(300)
+	if _, ok := (&Domain{Record_name: "example.net", Record_type: "A", Client_subnet: "192.0.2.128/25"}).EcsCovered(); ok {
+		t.Error("covered by a scope as narrow as the client subnet")
+	}
+}
+
+func TestQuerySkipsCoveredClientSubnets(t *testing.T) {
+	t.Cleanup(func() { clear(ecsCovers.covers) })
+	var queries atomic.Int32
+	target := newTestTarget(t, TargetConfiguration{Id: "ecs", Address: startDnsServer(t, scopedAnswer(t, 16, &queries))})
+	strategies, err := NewResolverStrategies([]string{"regular"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(d *Domain) uint {
+		c := make(chan uint, 1)
+		d.Query([]*Target{target}, strategies, resolverConfiguration, c)
+		return <-c
+	}
+
+	first := &Domain{Record_name: "example.com", Record_type: "A", Client_subnet: "192.0.2.0/24"}
+	if ttl := query(first); ttl != 300 || first.Ecs_scope != 16 {
+		t.Fatalf("ttl %d with scope %d, want 300 and 16", ttl, first.Ecs_scope)
+	}
+	second := &Domain{Record_name: "example.com", Record_type: "A", Client_subnet: "192.0.3.0/24"}
+	if delay := query(second); delay < 300 || queries.Load() != 1 {
+		t.Errorf("%d queries, refresh of the covered subnet in %ds, want 1 query and the ttl of the covering answer", queries.Load(), delay)
+	}
+	other := &Domain{Record_name: "example.com", Record_type: "A", Client_subnet: "198.51.100.0/24"}
+	query(other)
+	if queries.Load() != 2 {
+		t.Errorf("%d queries, want the subnet outside of the scope queried", queries.Load())
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// eventBufferSize - events buffered per subscriber, subscribers which fall
+// further behind are dropped
+const eventBufferSize = 256
+
+const (
+	EventDispatch = "dispatch"
+	EventResult   = "result"
+	EventError    = "error"
+	EventAdd      = "add"
+	EventRemove   = "remove"
+)
+
+var EventTypes = []string{EventDispatch, EventResult, EventError, EventAdd, EventRemove}
+
+var (
+	eventSubscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "event_subscribers",
+		Help:      "The current number of event stream subscribers",
+	})
+	eventSubscribersDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "event_subscribers_dropped_total",
+		Help:      "The total number of event stream subscribers dropped because they did not keep up",
+	})
+)
+
+func init() {
+	prometheus.Register(eventSubscribers)
+	prometheus.Register(eventSubscribersDropped)
+}
+
+// Event - something that happened to a domain
+type Event struct {
+	Type       string       `json:"type" example:"result"`
+	Time       int64        `json:"time" example:"1700000000000"`
+	Domain     string       `json:"domain" example:"google.com"`
+	RecordType string       `json:"record_type" example:"A"`
+	Subnet     string       `json:"subnet,omitempty" example:"192.0.2.0/24"`
+	Group      string       `json:"group" example:"default"`
+	Target     string       `json:"target,omitempty" example:"default"`
+	Result     *QueryResult `json:"result,omitempty"`
+}
+
+// NewEvent returns an event of the given type for the domain
+func NewEvent(eventType string, domain *Domain) Event {
+	return Event{
+		Type:       eventType,
+		Time:       time.Now().UnixMilli(),
+		Domain:     domain.Record_name,
+		RecordType: domain.Record_type,
+		Subnet:     domain.Client_subnet,
+		Group:      domain.GroupName(),
+	}
+}
+
+// EventFilter - an empty field matches every event. Events without target
+// (dispatch, add, remove) don't match a Target filter.
+type EventFilter struct {
+	// DomainPattern - shell pattern like *.example.com
+	DomainPattern string
+	Group         string
+	Target        string
+	Types         []string
+}
+
+func (filter EventFilter) Matches(event Event) bool {
+	if len(filter.Types) > 0 && !slices.Contains(filter.Types, event.Type) {
+		return false
+	}
+	if filter.Group != "" && event.Group != filter.Group {
+		return false
+	}
+	if filter.Target != "" && event.Target != filter.Target {
+		return false
+	}
+	if filter.DomainPattern != "" {
+		matched, _ := path.Match(strings.ToLower(filter.DomainPattern), strings.ToLower(strings.TrimSuffix(event.Domain, ".")))
+		return matched
+	}
+	return true
+}
+
+type eventSubscriber struct {
+	filter EventFilter
+	ch     chan Event
+}
+
+// EventBus - fans out events to the subscribers without ever blocking the publisher
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+var events = &EventBus{subscribers: make(map[*eventSubscriber]struct{})}
+
+// Subscribe returns a channel receiving the matching events. The channel is
+// closed if the subscriber falls behind or unsubscribes.
+func (bus *EventBus) Subscribe(filter EventFilter) (<-chan Event, func()) {
+	subscriber := &eventSubscriber{filter: filter, ch: make(chan Event, eventBufferSize)}
+	bus.mu.Lock()
+	bus.subscribers[subscriber] = struct{}{}
+	eventSubscribers.Set(float64(len(bus.subscribers)))
+	bus.mu.Unlock()
+	return subscriber.ch, func() { bus.remove(subscriber) }
+}
+
+func (bus *EventBus) remove(subscriber *eventSubscriber) bool {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if _, ok := bus.subscribers[subscriber]; !ok {
+		return false
+	}
+	delete(bus.subscribers, subscriber)
+	close(subscriber.ch)
+	eventSubscribers.Set(float64(len(bus.subscribers)))
+	return true
+}
+
+// Active returns whether anyone is subscribed, so publishers can skip building events
+func (bus *EventBus) Active() bool {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	return len(bus.subscribers) > 0
+}
+
+// Publish sends the event to the matching subscribers. Subscribers whose
+// buffer is full are dropped.
+func (bus *EventBus) Publish(event Event) {
+	var slow []*eventSubscriber
+	bus.mu.RLock()
+	for subscriber := range bus.subscribers {
+		if !subscriber.filter.Matches(event) {
+			continue
+		}
+		select {
+		case subscriber.ch <- event:
+		default:
+			slow = append(slow, subscriber)
+		}
+	}
+	bus.mu.RUnlock()
+
+	for _, subscriber := range slow {
+		if bus.remove(subscriber) {
+			log.Warn("dropping event subscriber which fell more than ", eventBufferSize, " events behind")
+			eventSubscribersDropped.Inc()
+		}
+	}
+}
+
+// PublishDomainEvent publishes an event of the given type for the domain if anyone is subscribed
+func PublishDomainEvent(eventType string, domain *Domain, target *Target, result *QueryResult) {
+	if !events.Active() {
+		return
+	}
+	event := NewEvent(eventType, domain)
+	if target != nil {
+		event.Target = target.Id
+	}
+	event.Result = result
+	events.Publish(event)
+}
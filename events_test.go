@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventFilterMatches(t *testing.T) {
+	event := Event{Type: EventResult, Domain: "www.Example.com.", Group: "critical", Target: "resolver1"}
+	tests := []struct {
+		filter EventFilter
+		want   bool
+	}{
+		{EventFilter{}, true},
+		{EventFilter{Types: []string{EventError, EventResult}}, true},
+		{EventFilter{Types: []string{EventAdd}}, false},
+		{EventFilter{Group: "critical"}, true},
+		{EventFilter{Group: DefaultGroupName}, false},
+		{EventFilter{Target: "resolver1"}, true},
+		{EventFilter{Target: "resolver2"}, false},
+		{EventFilter{DomainPattern: "*.example.com"}, true},
+		{EventFilter{DomainPattern: "*.example.net"}, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.Matches(event); got != test.want {
+			t.Errorf("%+v matches: %v, want %v", test.filter, got, test.want)
+		}
+	}
+	if (EventFilter{Target: "resolver1"}).Matches(Event{Type: EventAdd, Domain: "example.com"}) {
+		t.Error("an event without target matches a target filter")
+	}
+}
+
+func TestEventBusDropsSlowSubscribers(t *testing.T) {
+	bus := &EventBus{subscribers: make(map[*eventSubscriber]struct{})}
+	slow, _ := bus.Subscribe(EventFilter{})
+	other, unsubscribe := bus.Subscribe(EventFilter{Types: []string{EventRemove}})
+	for i := 0; i <= eventBufferSize; i++ {
+		bus.Publish(Event{Type: EventAdd, Domain: "example.com"})
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != eventBufferSize {
+		t.Errorf("the slow subscriber received %d events before it was dropped, want %d", received, eventBufferSize)
+	}
+	if !bus.Active() {
+		t.Fatal("the subscriber without matching events was dropped")
+	}
+	unsubscribe()
+	if _, open := <-other; open || bus.Active() {
+		t.Error("unsubscribing did not close the channel")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	resetQueue(t)
+	server := httptest.NewServer(SetupRouter())
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/api/v1/events?type=add&domain=*.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %s", resp.Status)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !events.Active(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the stream did not subscribe")
+		}
+	}
+	dh.AddDomain(Domain{Record_name: "other.test", Record_type: "A"})
+	dh.AddDomain(Domain{Record_name: "www.example", Record_type: "A"})
+
+	lines := bufio.NewScanner(resp.Body)
+	var event []string
+	for lines.Scan() && lines.Text() != "" {
+		event = append(event, lines.Text())
+	}
+	if len(event) != 2 || event[0] != "event:add" || !strings.Contains(event[1], `"domain":"www.example"`) {
+		t.Errorf("event = %q, want the add event of www.example", event)
+	}
+
+	resp, err = http.Get(server.URL + "/api/v1/events?type=unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status of an unknown event type = %s, want 400", resp.Status)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// exclusionCheckInterval - how often the exclusion files are checked for changes
+const exclusionCheckInterval = 10 * time.Second
+
+const (
+	ExclusionFormatList = "list"
+	ExclusionFormatRpz  = "rpz"
+)
+
+var (
+	excludedDomains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "excluded_domains_total",
+		Help:      "The total number of domains rejected by an exclusion rule by source of the rule",
+	},
+		[]string{"source"},
+	)
+	exclusionRules = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "exclusion_rules",
+		Help:      "The number of exclusion rules by source",
+	},
+		[]string{"source"},
+	)
+)
+
+func init() {
+	prometheus.Register(excludedDomains)
+	prometheus.Register(exclusionRules)
+}
+
+// ExclusionFileConfiguration - a file of exclusion rules, reloaded when it changes
+type ExclusionFileConfiguration struct {
+	Path string `yaml:"Path"`
+	// Format - list (default, one rule per line, hosts files are accepted) or rpz (response policy zone)
+	Format string `yaml:"Format"`
+	// Origin - the name of the response policy zone, needed if its records
+	// precede the SOA record or are relative without $ORIGIN
+	Origin string `yaml:"Origin"`
+}
+
+// ExclusionRule - an exact name, '*.<suffix>' (names below the suffix),
+// '.<suffix>' (the suffix and names below) or '/<regex>/'
+type ExclusionRule struct {
+	Pattern string
+	Source  string
+	name    string
+	// exact - the name itself matches
+	exact bool
+	// below - names below the name match
+	below bool
+	re    *regexp.Regexp
+}
+
+// ParseExclusionRule parses a rule of the configuration or a list file
+func ParseExclusionRule(pattern string, source string) (*ExclusionRule, error) {
+	rule := &ExclusionRule{Pattern: pattern, Source: source}
+	switch {
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion rule %s: %w", pattern, err)
+		}
+		rule.re = re
+		return rule, nil
+	case strings.HasPrefix(pattern, "*."):
+		rule.name = LookupDomainName(pattern[2:])
+		rule.below = true
+	case strings.HasPrefix(pattern, "."):
+		rule.name = LookupDomainName(pattern[1:])
+		rule.exact = true
+		rule.below = true
+	default:
+		rule.name = LookupDomainName(pattern)
+		rule.exact = true
+	}
+	if rule.name == "" || strings.ContainsAny(rule.name, " */") {
+		return nil, fmt.Errorf("invalid exclusion rule %s", pattern)
+	}
+	return rule, nil
+}
+
+// exclusionIndex - the rules by name for lookups in the number of labels
+type exclusionIndex struct {
+	exact  map[string]*ExclusionRule
+	suffix map[string]*ExclusionRule
+	regex  []*ExclusionRule
+}
+
+func newExclusionIndex(rules []*ExclusionRule) *exclusionIndex {
+	index := &exclusionIndex{exact: make(map[string]*ExclusionRule), suffix: make(map[string]*ExclusionRule)}
+	for _, rule := range rules {
+		if rule.re != nil {
+			index.regex = append(index.regex, rule)
+		}
+		if rule.exact {
+			index.exact[rule.name] = rule
+		}
+		if rule.below {
+			index.suffix[rule.name] = rule
+		}
+	}
+	return index
+}
+
+func (index *exclusionIndex) match(name string) *ExclusionRule {
+	if rule, ok := index.exact[name]; ok {
+		return rule
+	}
+	for parent := name; ; {
+		_, rest, found := strings.Cut(parent, ".")
+		if !found {
+			break
+		}
+		parent = rest
+		if rule, ok := index.suffix[parent]; ok {
+			return rule
+		}
+	}
+	for _, rule := range index.regex {
+		if rule.re.MatchString(name) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// exclusionFile - the rules of a file as of its last successful load
+type exclusionFile struct {
+	config   ExclusionFileConfiguration
+	rules    []*ExclusionRule
+	modified time.Time
+}
+
+// ExclusionList - names which are never queued, whichever way they are added
+type ExclusionList struct {
+	mu     sync.RWMutex
+	inline []*ExclusionRule
+	files  []*exclusionFile
+	index  *exclusionIndex
+}
+
+var exclusions = &ExclusionList{index: newExclusionIndex(nil)}
+
+// SetupExclusions loads the rules of the configuration and the ExclusionFiles
+func SetupExclusions(config *ResolverConfiguration) (*ExclusionList, error) {
+	list := &ExclusionList{}
+	for _, pattern := range config.Exclusions {
+		rule, err := ParseExclusionRule(pattern, "config")
+		if err != nil {
+			return nil, err
+		}
+		list.inline = append(list.inline, rule)
+	}
+	exclusionRules.With(prometheus.Labels{"source": "config"}).Set(float64(len(list.inline)))
+	for _, fileConfig := range config.ExclusionFiles {
+		if fileConfig.Format == "" {
+			fileConfig.Format = ExclusionFormatList
+		}
+		if fileConfig.Format != ExclusionFormatList && fileConfig.Format != ExclusionFormatRpz {
+			return nil, fmt.Errorf("exclusion file %s: unknown format %s (list, rpz)", fileConfig.Path, fileConfig.Format)
+		}
+		file := &exclusionFile{config: fileConfig}
+		if err := file.load(); err != nil {
+			return nil, err
+		}
+		list.files = append(list.files, file)
+	}
+	list.rebuild()
+	return list, nil
+}
+
+func (file *exclusionFile) load() error {
+	info, err := os.Stat(file.config.Path)
+	if err != nil {
+		return err
+	}
+	var rules []*ExclusionRule
+	if file.config.Format == ExclusionFormatRpz {
+		rules, err = ReadRpzFile(file.config.Path, file.config.Origin)
+	} else {
+		rules, err = ReadExclusionListFile(file.config.Path)
+	}
+	if err != nil {
+		return err
+	}
+	file.rules = rules
+	file.modified = info.ModTime()
+	exclusionRules.With(prometheus.Labels{"source": file.config.Path}).Set(float64(len(rules)))
+	log.Info("loaded ", len(rules), " exclusion rules from ", file.config.Path)
+	return nil
+}
+
+// rebuild indexes the rules, the caller must hold the write lock or own the list
+func (list *ExclusionList) rebuild() {
+	rules := append([]*ExclusionRule{}, list.inline...)
+	for _, file := range list.files {
+		rules = append(rules, file.rules...)
+	}
+	list.index = newExclusionIndex(rules)
+}
+
+// ReadExclusionListFile reads one rule per line. Empty lines and comments
+// (#) are ignored, for hosts file lines ('0.0.0.0 ads.example') the name is used.
+func ReadExclusionListFile(path string) ([]*ExclusionRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []*ExclusionRule
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		pattern := fields[0]
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			pattern = fields[1]
+		}
+		rule, err := ParseExclusionRule(pattern, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ReadRpzFile reads the qname triggers of a response policy zone. Without
+// origin, the owner of the SOA record is the origin. Passthru rules and
+// ip/nsdname triggers are ignored.
+func ReadRpzFile(path string, origin string) ([]*ExclusionRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []*ExclusionRule
+	seen := make(map[string]bool)
+	if origin != "" {
+		origin = strings.ToLower(dns.Fqdn(origin))
+	}
+	parser := dns.NewZoneParser(f, origin, path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		owner := strings.ToLower(rr.Header().Name)
+		if soa, isSoa := rr.(*dns.SOA); isSoa && origin == "" {
+			origin = strings.ToLower(soa.Hdr.Name)
+			continue
+		}
+		if origin == "" {
+			return nil, fmt.Errorf("%s: records before the SOA record, set the Origin of the file", path)
+		}
+		trigger, ok := strings.CutSuffix(owner, "."+origin)
+		if !ok || trigger == "" || seen[trigger] {
+			continue
+		}
+		if cname, isCname := rr.(*dns.CNAME); isCname && cname.Target == "rpz-passthru." {
+			continue
+		}
+		last := trigger[strings.LastIndex(trigger, ".")+1:]
+		if strings.HasPrefix(last, "rpz-") {
+			continue
+		}
+		seen[trigger] = true
+		rule, err := ParseExclusionRule(trigger, path)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Match returns the rule excluding the normalized name, nil if it is not excluded
+func (list *ExclusionList) Match(name string) *ExclusionRule {
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+	return list.index.match(name)
+}
+
+// CheckExcluded returns an error and counts the source of the rule if the
+// normalized name is excluded. Files may hold millions of rules, the rule
+// itself is only logged.
+func CheckExcluded(name string) error {
+	rule := exclusions.Match(name)
+	if rule == nil {
+		return nil
+	}
+	excludedDomains.With(prometheus.Labels{"source": rule.Source}).Inc()
+	log.Debug("excluded ", name, " by rule ", rule.Pattern, " of ", rule.Source)
+	return fmt.Errorf("excluded by rule %s", rule.Pattern)
+}
+
+// Watch reloads the exclusion files when they change, see reload
+func (list *ExclusionList) Watch(dh *DomainHeap) {
+	if len(list.files) == 0 {
+		return
+	}
+	for range time.Tick(exclusionCheckInterval) {
+		list.reload(dh)
+	}
+}
+
+// reload reloads the changed exclusion files and removes the queued domains
+// excluded by their new rules. If a file fails to load, its previous rules
+// stay in use. Returns the number of removed domains.
+func (list *ExclusionList) reload(dh *DomainHeap) int {
+	changed := false
+	for _, file := range list.files {
+		info, err := os.Stat(file.config.Path)
+		if err != nil || info.ModTime().Equal(file.modified) {
+			continue
+		}
+		reloaded := &exclusionFile{config: file.config}
+		if err := reloaded.load(); err != nil {
+			log.Error("unable to reload exclusion file: ", err)
+			file.modified = info.ModTime()
+			continue
+		}
+		list.mu.Lock()
+		*file = *reloaded
+		list.mu.Unlock()
+		changed = true
+	}
+	if !changed {
+		return 0
+	}
+	list.mu.Lock()
+	list.rebuild()
+	list.mu.Unlock()
+	// the rules are matched outside of the heap, regular expressions are slow
+	excluded := make(map[string]bool)
+	for _, d := range dh.QueuedDomains() {
+		if list.Match(d.Record_name) != nil {
+			excluded[d.Key()] = true
+		}
+	}
+	if len(excluded) == 0 {
+		return 0
+	}
+	removed := dh.RemoveDomains(func(d *Domain) bool { return excluded[d.Key()] })
+	if removed > 0 {
+		log.Info("removed ", removed, " queued domains excluded by the reloaded exclusion files")
+	}
+	return removed
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// writeExclusionFile writes content to name in dir and moves its
+// modification time forward, file systems with coarse timestamps would hide
+// a rewrite otherwise
+func writeExclusionFile(t *testing.T, dir string, name string, content string, age time.Duration) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(age)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ruleNames returns the patterns of the rules
+func ruleNames(rules []*ExclusionRule) []string {
+	patterns := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		patterns = append(patterns, rule.Pattern)
+	}
+	return patterns
+}
+
+func TestExclusionRules(t *testing.T) {
+	var rules []*ExclusionRule
+	for _, pattern := range []string{"Ads.Example", "*.tracker.example", ".corp.internal", `/^[a-z0-9]{20,}\./`} {
+		rule, err := ParseExclusionRule(pattern, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	index := newExclusionIndex(rules)
+	tests := []struct {
+		name string
+		rule string
+	}{
+		{"ads.example", "Ads.Example"},
+		{"www.ads.example", ""},
+		{"tracker.example", ""},
+		{"a.b.tracker.example", "*.tracker.example"},
+		{"corp.internal", ".corp.internal"},
+		{"host.corp.internal", ".corp.internal"},
+		{"abcdefghij0123456789.example", `/^[a-z0-9]{20,}\./`},
+		{"example.com", ""},
+	}
+	for _, test := range tests {
+		rule := index.match(test.name)
+		if (rule == nil) != (test.rule == "") || rule != nil && rule.Pattern != test.rule {
+			t.Errorf("match(%s) = %v, want %s", test.name, rule, test.rule)
+		}
+	}
+	for _, pattern := range []string{"", "*.", "/(/", "ads example", "ads.*.example"} {
+		if _, err := ParseExclusionRule(pattern, "test"); err == nil {
+			t.Errorf("ParseExclusionRule(%s) accepted an invalid rule", pattern)
+		}
+	}
+}
+
+func TestReadExclusionListFile(t *testing.T) {
+	path := writeExclusionFile(t, t.TempDir(), "list.txt", "# blocklist\n\nads.example # ads\n0.0.0.0 tracker.example\n::1 *.metrics.example\n", 0)
+	rules, err := ReadExclusionListFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ads.example", "tracker.example", "*.metrics.example"}; !slices.Equal(ruleNames(rules), want) {
+		t.Errorf("rules = %v, want %v", ruleNames(rules), want)
+	}
+	invalid := writeExclusionFile(t, t.TempDir(), "list.txt", "ads.example\n/(/\n", 0)
+	if _, err := ReadExclusionListFile(invalid); err == nil {
+		t.Error("a list with an invalid rule was accepted")
+	}
+}
+
+func TestReadRpzFile(t *testing.T) {
+	dir := t.TempDir()
+	triggers := "ads.example CNAME .\n" +
+		"*.tracker.example CNAME .\n" +
+		"ok.ads.example CNAME rpz-passthru.\n" +
+		"32.1.2.0.192.rpz-ip CNAME .\n" +
+		"ns.example.rpz-nsdname CNAME .\n" +
+		"ads.example A 127.0.0.1\n"
+	want := []string{"ads.example", "*.tracker.example"}
+	soa := "@ 300 IN SOA localhost. hostmaster.localhost. 1 3600 600 86400 300\n@ NS localhost.\n"
+
+	withOrigin := writeExclusionFile(t, dir, "origin.rpz", "$ORIGIN rpz.example.\n$TTL 300\n"+soa+triggers, 0)
+	rules, err := ReadRpzFile(withOrigin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ruleNames(rules), want) {
+		t.Errorf("rules = %v, want %v", ruleNames(rules), want)
+	}
+
+	// relative records without $ORIGIN need the configured origin
+	relative := writeExclusionFile(t, dir, "relative.rpz", "$TTL 300\n"+soa+triggers, 0)
+	if _, err := ReadRpzFile(relative, ""); err == nil {
+		t.Error("a zone with relative names and without origin was accepted")
+	}
+	rules, err = ReadRpzFile(relative, "RPZ.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ruleNames(rules), want) {
+		t.Errorf("rules with the configured origin = %v, want %v", ruleNames(rules), want)
+	}
+
+	// with the configured origin, the SOA record may follow the triggers
+	late := writeExclusionFile(t, dir, "late.rpz", "$TTL 300\n"+triggers+soa, 0)
+	if _, err := ReadRpzFile(late, ""); err == nil {
+		t.Error("triggers before the SOA record were accepted without origin")
+	}
+	if rules, err := ReadRpzFile(late, "rpz.example."); err != nil || !slices.Equal(ruleNames(rules), want) {
+		t.Errorf("rules of triggers before the SOA record = %v %v, want %v", ruleNames(rules), err, want)
+	}
+}
+
+func TestExclusionFileReloadRemovesExcludedDomains(t *testing.T) {
+	resetQueue(t)
+	dir := t.TempDir()
+	path := writeExclusionFile(t, dir, "list.txt", "ads.example\n", -time.Minute)
+	config := *resolverConfiguration
+	config.Exclusions = nil
+	config.ExclusionFiles = []ExclusionFileConfiguration{{Path: path}}
+	list, err := SetupExclusions(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tracker.example", "www.tracker.example", "example.com"} {
+		if err := dh.AddDomain(Domain{Record_name: name, Record_type: "A"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed := list.reload(dh); removed != 0 {
+		t.Errorf("%d domains removed without a change of the file", removed)
+	}
+
+	writeExclusionFile(t, dir, "list.txt", "ads.example\n.tracker.example\n", 0)
+	if removed := list.reload(dh); removed != 2 {
+		t.Errorf("%d domains removed after the reload, want 2", removed)
+	}
+	if keys := queuedKeyList(); !slices.Equal(keys, []string{"default example.com IN A"}) {
+		t.Errorf("queue = %v, want example.com", keys)
+	}
+	if list.Match("www.tracker.example") == nil {
+		t.Error("the new rule was not loaded")
+	}
+
+	// a broken file keeps the previous rules
+	writeExclusionFile(t, dir, "list.txt", "/(/\n", time.Minute)
+	list.reload(dh)
+	if list.Match("www.tracker.example") == nil {
+		t.Error("the rules of a broken file replaced the previous ones")
+	}
+}
+
+func TestCheckExcludedCountsTheSource(t *testing.T) {
+	withExclusions(t, "ads.example", "*.tracker.example")
+	excluded := excludedDomains.With(prometheus.Labels{"source": "config"})
+	before := testutil.ToFloat64(excluded)
+	for _, name := range []string{"ads.example", "a.tracker.example", "example.com"} {
+		CheckExcluded(name)
+	}
+	if got := testutil.ToFloat64(excluded) - before; got != 2 {
+		t.Errorf("%v excluded domains counted for the config rules, want 2", got)
+	}
+}
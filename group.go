@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	dns "github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// GroupConfiguration - settings shared by a named set of domains. Unset
+// (zero) values inherit the global configuration.
+type GroupConfiguration struct {
+	Name string `yaml:"Name"`
+	// Priority - when rate limited, due domains of groups with higher priority are dispatched first
+	Priority int `yaml:"Priority"`
+	// Targets - ids of the targets to preheat, defaults to all targets
+	Targets []string `yaml:"Targets"`
+	// Strategies - names of the strategies to run in order, defaults to regular, soa, flexible_delay
+	Strategies []string `yaml:"Strategies"`
+	PinMinTtl  uint     `yaml:"PinMinTtl"`
+	// RefreshAheadSeconds - refresh this many seconds before the ttl expires
+	RefreshAheadSeconds uint `yaml:"RefreshAheadSeconds"`
+	// RateLimit - refreshes per second of this group, 0 is unlimited (the global QueryRateLimit still applies)
+	RateLimit     uint     `yaml:"RateLimit"`
+	ClientSubnets []string `yaml:"ClientSubnets"`
+	// CompanionTypes - types enrolled together with every domain of the group, like [AAAA, HTTPS] for A
+	CompanionTypes []string `yaml:"CompanionTypes"`
+	// DeriveTargets - enroll the target names of HTTPS/SVCB answers and the exchanges of MX answers (A and AAAA)
+	DeriveTargets bool `yaml:"DeriveTargets"`
+}
+
+// Group - the runtime settings of a group
+type Group struct {
+	Name                string
+	Priority            int
+	Targets             []*Target
+	Strategies          *ResolverStrategies
+	Config              *ResolverConfiguration
+	ClientSubnets       []string
+	RefreshAheadSeconds uint
+	// RateLimit - the configured refreshes per second, schedule windows may override it
+	RateLimit      uint
+	CompanionTypes []string
+	DeriveTargets  bool
+	limiter        *rate.Limiter
+	paused         atomic.Bool
+}
+
+// groups - all groups by name, groupsByPriority - all groups, highest priority first
+var groups map[string]*Group
+var groupsByPriority []*Group
+
+// SetupGroups builds the configured groups. The group "default" always
+// exists and uses the global configuration unless configured explicitly.
+func SetupGroups(config *ResolverConfiguration) (map[string]*Group, []*Group, error) {
+	groupConfigurations := config.Groups
+	if !slices.ContainsFunc(groupConfigurations, func(gc GroupConfiguration) bool { return gc.Name == DefaultGroupName }) {
+		groupConfigurations = append([]GroupConfiguration{{Name: DefaultGroupName}}, groupConfigurations...)
+	}
+
+	byName := make(map[string]*Group)
+	var byPriority []*Group
+	for _, gc := range groupConfigurations {
+		if gc.Name == "" {
+			return nil, nil, fmt.Errorf("group without name")
+		}
+		if _, exists := byName[gc.Name]; exists {
+			return nil, nil, fmt.Errorf("duplicate group %s", gc.Name)
+		}
+		group, err := NewGroup(gc, config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("group %s: %w", gc.Name, err)
+		}
+		byName[group.Name] = group
+		byPriority = append(byPriority, group)
+	}
+	slices.SortStableFunc(byPriority, func(a, b *Group) int { return b.Priority - a.Priority })
+	return byName, byPriority, nil
+}
+
+func NewGroup(gc GroupConfiguration, config *ResolverConfiguration) (*Group, error) {
+	group := &Group{
+		Name:                gc.Name,
+		Priority:            gc.Priority,
+		Targets:             targets,
+		ClientSubnets:       config.ClientSubnets,
+		RefreshAheadSeconds: gc.RefreshAheadSeconds,
+		RateLimit:           gc.RateLimit,
+		DeriveTargets:       gc.DeriveTargets,
+		limiter:             NewQueryLimiter(gc.RateLimit),
+	}
+
+	for _, rr_type := range gc.CompanionTypes {
+		rr_type = strings.ToUpper(rr_type)
+		if _, ok := dns.StringToType[rr_type]; !ok {
+			return nil, fmt.Errorf("unknown companion type %s", rr_type)
+		}
+		group.CompanionTypes = append(group.CompanionTypes, rr_type)
+	}
+
+	if len(gc.Targets) > 0 {
+		group.Targets = nil
+		for _, id := range gc.Targets {
+			target, err := FindTarget(id)
+			if err != nil {
+				return nil, err
+			}
+			group.Targets = append(group.Targets, target)
+		}
+	}
+
+	strategyNames := gc.Strategies
+	if len(strategyNames) == 0 {
+		strategyNames = DefaultStrategyPipeline
+	}
+	strategies, err := NewResolverStrategies(strategyNames)
+	if err != nil {
+		return nil, err
+	}
+	group.Strategies = strategies
+
+	if len(gc.ClientSubnets) > 0 {
+		for _, subnet := range gc.ClientSubnets {
+			if err := ValidateClientSubnet(subnet); err != nil {
+				return nil, err
+			}
+		}
+		group.ClientSubnets = gc.ClientSubnets
+	}
+
+	// strategies read their settings from the configuration, so each group gets its own copy
+	groupConfig := *config
+	if gc.PinMinTtl > 0 {
+		groupConfig.PinMinTtl = gc.PinMinTtl
+	}
+	group.Config = &groupConfig
+
+	log.Debug("group ", group.Name, " priority=", group.Priority, " targets=", len(group.Targets), " strategies=", strategyNames, " rate_limit=", gc.RateLimit)
+	return group, nil
+}
+
+// FindGroup returns the group with the given name
+func FindGroup(name string) (*Group, error) {
+	if name == "" {
+		name = DefaultGroupName
+	}
+	group, ok := groups[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown group %s", name)
+	}
+	return group, nil
+}
+
+// RefreshDelay returns the delay until the next refresh for a ttl,
+// RefreshAheadSeconds (but at most half the ttl) before it expires
+func (group *Group) RefreshDelay(ttl uint) uint {
+	return ttl - min(group.RefreshAheadSeconds, ttl/2)
+}
+
+// Paused returns whether a schedule window paused the group
+func (group *Group) Paused() bool {
+	return group.paused.Load()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dns "github.com/miekg/dns"
+)
+
+const (
+	// heapCheckTimeout - the heap goroutine must run a noop within this time
+	heapCheckTimeout = 2 * time.Second
+	// targetCheckInterval - reachability of the targets is checked at most this often
+	targetCheckInterval = 10 * time.Second
+)
+
+// HealthCheck - the outcome of a single liveness or readiness check
+type HealthCheck struct {
+	Name   string `json:"name" example:"scheduler"`
+	Ok     bool   `json:"ok" example:"true"`
+	Detail string `json:"detail" example:"last loop 12ms ago"`
+}
+
+// HealthResponse - ok if all checks are ok
+type HealthResponse struct {
+	Status string        `json:"status" example:"ok"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// domainsLoaded - the startup load of the DomainsFile finished
+var domainsLoaded atomic.Bool
+
+// CheckScheduler fails if the scheduler loop did not run recently
+func CheckScheduler(config *ResolverConfiguration) HealthCheck {
+	check := HealthCheck{Name: "scheduler"}
+	last := scheduler.LastLoop()
+	if last.IsZero() {
+		// the scheduler starts once the DomainsFile is loaded
+		check.Ok = !domainsLoaded.Load()
+		check.Detail = "not started"
+		return check
+	}
+	// the loop sleeps at most SleepLowTresholdMilliseconds
+	maxAge := max(10*time.Second, 10*time.Duration(config.SleepLowTresholdMilliseconds)*time.Millisecond)
+	age := time.Since(last)
+	check.Ok = age <= maxAge
+	check.Detail = fmt.Sprintf("last loop %dms ago (max %dms)", age.Milliseconds(), maxAge.Milliseconds())
+	return check
+}
+
+// CheckHeap fails if the heap goroutine does not run a noop within heapCheckTimeout
+func CheckHeap(dh *DomainHeap) HealthCheck {
+	check := HealthCheck{Name: "heap"}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		HeapDo(dh, func(h *DomainHeap) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+		check.Ok = true
+		check.Detail = fmt.Sprintf("responded in %dms", time.Since(start).Milliseconds())
+	case <-time.After(heapCheckTimeout):
+		check.Detail = fmt.Sprintf("no response within %dms", heapCheckTimeout.Milliseconds())
+	}
+	return check
+}
+
+// CheckDomainsLoaded fails until the startup load of the DomainsFile finished
+func CheckDomainsLoaded(dh *DomainHeap) HealthCheck {
+	check := HealthCheck{Name: "domains", Ok: domainsLoaded.Load()}
+	if check.Ok {
+		check.Detail = fmt.Sprintf("%d domains queued", dh.QueueSize())
+	} else {
+		check.Detail = "loading DomainsFile"
+	}
+	return check
+}
+
+// targetReachability - the cached result of the last reachability check
+var targetReachability struct {
+	mu      sync.Mutex
+	checked time.Time
+	check   HealthCheck
+}
+
+// CheckTargets fails if no target answers a query for the root NS records.
+// The result is cached for targetCheckInterval.
+func CheckTargets() HealthCheck {
+	targetReachability.mu.Lock()
+	defer targetReachability.mu.Unlock()
+	if time.Since(targetReachability.checked) < targetCheckInterval {
+		return targetReachability.check
+	}
+
+	check := HealthCheck{Name: "targets"}
+	var wg sync.WaitGroup
+	errs := make([]error, len(targets))
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *Target) {
+			defer wg.Done()
+			_, _, errs[i] = target.Query(".", dns.TypeNS)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var details []string
+	for i, target := range targets {
+		if errs[i] != nil {
+			details = append(details, target.Id+": "+errs[i].Error())
+			continue
+		}
+		check.Ok = true
+		details = append(details, target.Id+": reachable")
+	}
+	check.Detail = strings.Join(details, ", ")
+	targetReachability.checked = time.Now()
+	targetReachability.check = check
+	return check
+}
+
+// CheckWarmup fails unless every target which is not paused reached the
+// cache hit ratio threshold
+func CheckWarmup(threshold float64) HealthCheck {
+	check := HealthCheck{Name: "warmup", Ok: true}
+	var details []string
+	for _, target := range ActiveTargets(targets) {
+		ratio, samples := target.CacheHitRatio()
+		if samples == 0 || ratio < threshold {
+			check.Ok = false
+		}
+		details = append(details, fmt.Sprintf("%s: %.3f of %d responses", target.Id, ratio, samples))
+	}
+	check.Detail = fmt.Sprintf("cache hit ratio threshold %.3f - %s", threshold, strings.Join(details, ", "))
+	return check
+}
+
+func respondHealth(c *gin.Context, checks []HealthCheck) {
+	response := HealthResponse{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.Ok {
+			response.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, response)
+}
+
+// HandleHealthz responds with 200 if the scheduler loop and the heap
+// goroutine are alive, 503 otherwise
+func HandleHealthz(c *gin.Context) {
+	respondHealth(c, []HealthCheck{CheckScheduler(resolverConfiguration), CheckHeap(dh)})
+}
+
+// HandleReadyz responds with 200 if the domains are loaded, a target is
+// reachable and (if configured) the targets are warmed up, 503 otherwise
+func HandleReadyz(c *gin.Context) {
+	checks := []HealthCheck{CheckDomainsLoaded(dh), CheckTargets()}
+	if resolverConfiguration.ReadinessCacheHitRatio > 0 {
+		checks = append(checks, CheckWarmup(resolverConfiguration.ReadinessCacheHitRatio))
+	}
+	respondHealth(c, checks)
+}
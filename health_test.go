@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// withTargets replaces the targets for the test and forgets the cached
+// reachability
+func withTargets(t *testing.T, replacement ...*Target) {
+	t.Helper()
+	previous := targets
+	targets = replacement
+	forget := func() {
+		targetReachability.mu.Lock()
+		targetReachability.checked = time.Time{}
+		targetReachability.mu.Unlock()
+	}
+	forget()
+	t.Cleanup(func() {
+		targets = previous
+		forget()
+	})
+}
+
+// withHealthState sets whether the domains are loaded and when the scheduler
+// loop ran last for the test
+func withHealthState(t *testing.T, loaded bool, lastLoop time.Time) {
+	t.Helper()
+	previousLoaded, previousLoop := domainsLoaded.Load(), scheduler.lastLoop.Load()
+	domainsLoaded.Store(loaded)
+	scheduler.lastLoop.Store(0)
+	if !lastLoop.IsZero() {
+		scheduler.lastLoop.Store(lastLoop.UnixNano())
+	}
+	t.Cleanup(func() {
+		domainsLoaded.Store(previousLoaded)
+		scheduler.lastLoop.Store(previousLoop)
+	})
+}
+
+// unreachableAddress returns a local udp address nothing listens on
+func unreachableAddress(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().String()
+	conn.Close()
+	return address
+}
+
+func TestCheckScheduler(t *testing.T) {
+	tests := []struct {
+		name     string
+		loaded   bool
+		lastLoop time.Time
+		ok       bool
+	}{
+		{"loading", false, time.Time{}, true},
+		// the scheduler starts right after the load
+		{"loaded but not started", true, time.Time{}, false},
+		{"running", true, time.Now(), true},
+		{"stuck", true, time.Now().Add(-time.Hour), false},
+	}
+	for _, test := range tests {
+		withHealthState(t, test.loaded, test.lastLoop)
+		if check := CheckScheduler(resolverConfiguration); check.Ok != test.ok {
+			t.Errorf("%s: CheckScheduler = %+v, want ok %v", test.name, check, test.ok)
+		}
+	}
+}
+
+func TestCheckHeap(t *testing.T) {
+	if check := CheckHeap(dh); !check.Ok {
+		t.Errorf("CheckHeap = %+v, want ok", check)
+	}
+}
+
+func TestCheckDomainsLoaded(t *testing.T) {
+	resetQueue(t)
+	HeapPush(dh, Domain{Record_name: "example.com", Record_type: "A", Group: "default"})
+
+	withHealthState(t, false, time.Time{})
+	if check := CheckDomainsLoaded(dh); check.Ok {
+		t.Errorf("CheckDomainsLoaded while loading = %+v", check)
+	}
+	domainsLoaded.Store(true)
+	if check := CheckDomainsLoaded(dh); !check.Ok || check.Detail != "1 domains queued" {
+		t.Errorf("CheckDomainsLoaded = %+v, want ok with 1 domain", check)
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	reachable := newTestTarget(t, TargetConfiguration{Id: "reachable", Address: startDnsServer(t, answer(t, ". 300 IN NS a.root-servers.net."))})
+	unreachable := newTestTarget(t, TargetConfiguration{Id: "unreachable", Address: unreachableAddress(t)})
+
+	withTargets(t, unreachable, reachable)
+	if check := CheckTargets(); !check.Ok {
+		t.Errorf("CheckTargets with a reachable target = %+v, want ok", check)
+	}
+
+	withTargets(t, unreachable)
+	check := CheckTargets()
+	if check.Ok {
+		t.Errorf("CheckTargets without a reachable target = %+v, want fail", check)
+	}
+	// the result is cached for targetCheckInterval
+	targets = []*Target{reachable}
+	if cached := CheckTargets(); cached != check {
+		t.Errorf("CheckTargets = %+v, want the cached %+v", cached, check)
+	}
+}
+
+func TestCheckWarmup(t *testing.T) {
+	cold := newTestTarget(t, TargetConfiguration{Id: "cold", Address: unreachableAddress(t)})
+	warm := newTestTarget(t, TargetConfiguration{Id: "warm", Address: unreachableAddress(t)})
+	for i := 0; i < 10; i++ {
+		warm.cacheWindow.Add(i > 0)
+	}
+	withTargets(t, warm, cold)
+
+	if check := CheckWarmup(0.8); check.Ok {
+		t.Errorf("CheckWarmup with a target without responses = %+v, want fail", check)
+	}
+	cold.SetPaused(true)
+	t.Cleanup(func() { cold.SetPaused(false) })
+	if check := CheckWarmup(0.8); !check.Ok {
+		t.Errorf("CheckWarmup of the warm target = %+v, want ok", check)
+	}
+	if check := CheckWarmup(0.95); check.Ok {
+		t.Errorf("CheckWarmup above the hit ratio = %+v, want fail", check)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	resetQueue(t)
+	withTargets(t, newTestTarget(t, TargetConfiguration{Address: startDnsServer(t, answer(t, ". 300 IN NS a.root-servers.net."))}))
+	withHealthState(t, false, time.Time{})
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/healthz", http.StatusOK},
+		// the DomainsFile is still loading
+		{"/readyz", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		w := apiRequest(t, "GET", test.target, "", "")
+		var response HealthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != test.status || (response.Status == "ok") != (test.status == http.StatusOK) {
+			t.Errorf("GET %s = %d %+v, want %d", test.target, w.Code, response, test.status)
+		}
+	}
+
+	withHealthState(t, true, time.Now())
+	for _, target := range []string{"/healthz", "/readyz"} {
+		if w := apiRequest(t, "GET", target, "", ""); w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d %s, want 200", target, w.Code, w.Body)
+		}
+	}
+}
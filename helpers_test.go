@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dns "github.com/miekg/dns"
+)
+
+// TestMain parses the test flags again, pflag.Parse in init marks the go flag
+// set as parsed before the testing package gets to it, and sets up the daemon
+// like main does
+func TestMain(m *testing.M) {
+	flag.CommandLine.Parse(os.Args[1:])
+	setupTargets()
+	setupServer()
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// startDnsServer serves handler over udp and tcp on the same port of 127.0.0.1
+func startDnsServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	for attempt := 0; attempt < 10; attempt++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.ListenPacket("udp", listener.Addr().String())
+		if err != nil {
+			// the port is taken for udp, try another one
+			listener.Close()
+			continue
+		}
+		serve(t, &dns.Server{Listener: listener, Handler: handler})
+		serve(t, &dns.Server{PacketConn: conn, Handler: handler})
+		return listener.Addr().String()
+	}
+	t.Fatal("no free port for udp and tcp")
+	return ""
+}
+
+// serve starts server and shuts it down at the end of the test
+func serve(t *testing.T, server *dns.Server) {
+	t.Helper()
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dns server did not start")
+	}
+	t.Cleanup(func() { server.Shutdown() })
+}
+
+// answer returns a handler answering every query with rrs
+func answer(t *testing.T, rrs ...string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		for _, rr := range rrs {
+			m.Answer = append(m.Answer, mustRR(t, rr))
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+// newTestTarget creates a target with a short timeout
+func newTestTarget(t *testing.T, tc TargetConfiguration) *Target {
+	t.Helper()
+	config := *resolverConfiguration
+	config.TimeoutMillisecons = 2000
+	config.RetryTimes = 0
+	if tc.Id == "" {
+		tc.Id = "test"
+	}
+	target, err := NewTarget(tc, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(target.transport.Close)
+	return target
+}
+
+// testPki - a self-signed ca which issues certificates for tests
+type testPki struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	CaFile string
+	serial int64
+}
+
+func newTestPki(t *testing.T) *testPki {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "syringe test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki := &testPki{dir: t.TempDir(), ca: ca, caKey: key, serial: 1}
+	pki.CaFile = pki.writePem(t, "ca.pem", "CERTIFICATE", der)
+	return pki
+}
+
+// Issue creates a certificate for commonName, valid for localhost and
+// 127.0.0.1 as server and as client
+func (pki *testPki) Issue(t *testing.T, commonName string) (certFile string, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(pki.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, pki.ca, &key.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("cert-%d", pki.serial)
+	return pki.writePem(t, name+".pem", "CERTIFICATE", der), pki.writePem(t, name+".key", "EC PRIVATE KEY", keyDer)
+}
+
+// ServerConfig returns a tls configuration serving a certificate for commonName
+func (pki *testPki) ServerConfig(t *testing.T, commonName string) *tls.Config {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(pki.Issue(t, commonName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func (pki *testPki) writePem(t *testing.T, name string, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(pki.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// resetQueue empties the heap, the scheduler and the domain indexes
+func resetQueue(t *testing.T) {
+	t.Helper()
+	clean := func() {
+		HeapDo(dh, func(h *DomainHeap) { *h = (*h)[:0] })
+		scheduler.ready = make(map[string][]Domain)
+		scheduler.mu.Lock()
+		scheduler.outside = make(map[string]Domain)
+		scheduler.mu.Unlock()
+		queuedKeys.Lock()
+		clear(queuedKeys.keys)
+		clear(queuedKeys.promoted)
+		queuedKeys.Unlock()
+		removedDomains.Lock()
+		clear(removedDomains.keys)
+		removedDomains.Unlock()
+	}
+	clean()
+	t.Cleanup(clean)
+}
+
+// withGroups replaces the groups by the configured ones for the test
+func withGroups(t *testing.T, gcs ...GroupConfiguration) {
+	t.Helper()
+	config := *resolverConfiguration
+	config.Groups = gcs
+	byName, byPriority, err := SetupGroups(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, previousByPriority := groups, groupsByPriority
+	groups, groupsByPriority = byName, byPriority
+	t.Cleanup(func() { groups, groupsByPriority = previous, previousByPriority })
+}
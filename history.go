@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	dns "github.com/miekg/dns"
+)
+
+// QueryResult - the outcome of resolving a domain on a single target
+type QueryResult struct {
+	Time      int64  `json:"time" example:"1700000000000"`
+	Target    string `json:"target" example:"default"`
+	Strategy  string `json:"strategy,omitempty" example:"regular"`
+	Rcode     string `json:"rcode,omitempty" example:"NOERROR"`
+	Answers   int    `json:"answers" example:"1"`
+	AnswerTtl uint   `json:"answer_ttl" example:"3"`
+	// Ttl - the delay in seconds until the domain is refreshed
+	Ttl uint `json:"ttl" example:"10"`
+	// Pinned - Ttl was raised above the answer ttl (e.g. by PinMinTtl)
+	Pinned    bool  `json:"pinned" example:"true"`
+	LatencyMs int64 `json:"latency_ms" example:"12"`
+	// Cache - whether the target answered from its cache (hit/miss)
+	Cache string `json:"cache,omitempty" example:"miss"`
+	Error string `json:"error,omitempty" example:""`
+}
+
+// Exchange sends m to target and keeps the response for the query history
+func (domain *Domain) Exchange(target *Target, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	r, rtt, err := target.Exchange(domain.traceContext(), domain.GroupName(), m)
+	domain.response = r
+	domain.rtt = rtt
+	return r, rtt, err
+}
+
+// NewQueryResult describes the last exchange of the current strategy
+func (domain *Domain) NewQueryResult(target *Target, strategy string, ttl uint, errs []string) QueryResult {
+	result := QueryResult{
+		Time:     time.Now().UnixMilli(),
+		Target:   target.Id,
+		Strategy: strategy,
+		Ttl:      ttl,
+		Error:    strings.Join(errs, "; "),
+	}
+	if domain.response != nil {
+		result.Rcode = dns.RcodeToString[domain.response.Rcode]
+		result.LatencyMs = domain.rtt.Milliseconds()
+		result.Cache = domain.cache
+		for _, rr := range domain.response.Answer {
+			if rr.Header().Rrtype == dns.TypeRRSIG {
+				continue
+			}
+			if result.Answers == 0 || uint(rr.Header().Ttl) < result.AnswerTtl {
+				result.AnswerTtl = uint(rr.Header().Ttl)
+			}
+			result.Answers++
+		}
+		result.Pinned = result.Answers > 0 && ttl > result.AnswerTtl
+	}
+	return result
+}
+
+// RecordResult stores result as last result of its target and adds it to
+// the history. Once the history holds size entries, result replaces the oldest.
+func (domain *Domain) RecordResult(result QueryResult, size uint) {
+	if domain.Last_result == nil {
+		domain.Last_result = make(map[string]QueryResult)
+	}
+	domain.Last_result[result.Target] = result
+	if size == 0 {
+		return
+	}
+	if len(domain.history) < int(size) {
+		if domain.history == nil {
+			domain.history = make([]QueryResult, 0, size)
+		}
+		domain.history = append(domain.history, result)
+		return
+	}
+	domain.history[domain.history_start] = result
+	domain.history_start = (domain.history_start + 1) % len(domain.history)
+}
+
+// HistoryEntries returns a copy of the history, oldest first
+func (domain Domain) HistoryEntries() []QueryResult {
+	entries := make([]QueryResult, 0, len(domain.history))
+	entries = append(entries, domain.history[domain.history_start:]...)
+	return append(entries, domain.history[:domain.history_start]...)
+}
+
+// LastHistoryEntry returns the most recent result of the history
+func (domain Domain) LastHistoryEntry() (QueryResult, bool) {
+	if len(domain.history) == 0 {
+		return QueryResult{}, false
+	}
+	return domain.history[(domain.history_start+len(domain.history)-1)%len(domain.history)], true
+}
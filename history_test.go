@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	dns "github.com/miekg/dns"
+)
+
+func TestRecordResultKeepsTheMostRecentResults(t *testing.T) {
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+	for i := int64(1); i <= 3; i++ {
+		domain.RecordResult(QueryResult{Time: i, Target: "a"}, 3)
+	}
+	backing := &domain.history[0]
+	for i := int64(4); i <= 7; i++ {
+		domain.RecordResult(QueryResult{Time: i, Target: "a"}, 3)
+	}
+
+	entries := domain.HistoryEntries()
+	if len(entries) != 3 || entries[0].Time != 5 || entries[1].Time != 6 || entries[2].Time != 7 {
+		t.Errorf("history = %v, want the results 5, 6 and 7", entries)
+	}
+	if &domain.history[0] != backing {
+		t.Error("the full history was reallocated")
+	}
+	if last, ok := domain.LastHistoryEntry(); !ok || last.Time != 7 {
+		t.Errorf("last history entry = %v, want the result 7", last)
+	}
+	if domain.Last_result["a"].Time != 7 {
+		t.Errorf("last result = %v, want the result 7", domain.Last_result["a"])
+	}
+}
+
+func TestRecordResultWithoutHistory(t *testing.T) {
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+	domain.RecordResult(QueryResult{Time: 1, Target: "a"}, 0)
+	if entries := domain.HistoryEntries(); len(entries) != 0 {
+		t.Errorf("history = %v, want none", entries)
+	}
+	if _, ok := domain.LastHistoryEntry(); ok {
+		t.Error("empty history has a last entry")
+	}
+	if domain.Last_result["a"].Time != 1 {
+		t.Errorf("last result = %v, want the result 1", domain.Last_result["a"])
+	}
+}
+
+func TestQueryResultDescribesTheSucceedingStrategy(t *testing.T) {
+	address := startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+	target := newTestTarget(t, TargetConfiguration{Address: address})
+	strategies, err := NewResolverStrategies([]string{"regular", "static_delay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+
+	ttl, ok := domain.QueryTarget(target, strategies, resolverConfiguration)
+	if ok || ttl != uint(resolverConfiguration.StaticDelaySeconds) {
+		t.Fatalf("QueryTarget = %d %v, want the static delay of the fallback", ttl, ok)
+	}
+	result := domain.Last_result[target.Id]
+	if result.Strategy != "static_delay" || result.Error == "" {
+		t.Errorf("result = %+v, want the fallback strategy with the error of regular", result)
+	}
+	// the fallback sent no query, the SERVFAIL belongs to regular
+	if result.Rcode != "" || result.LatencyMs != 0 {
+		t.Errorf("result of the fallback reports rcode %q with latency %dms", result.Rcode, result.LatencyMs)
+	}
+
+	strategies, _ = NewResolverStrategies([]string{"regular"})
+	domain.QueryTarget(target, strategies, resolverConfiguration)
+	if result := domain.Last_result[target.Id]; result.Strategy != "" || result.Rcode != "SERVFAIL" {
+		t.Errorf("result = %+v, want the SERVFAIL of the failed pipeline", result)
+	}
+}
+
+func TestHistoryAndQuarantineIncludeReadyDomains(t *testing.T) {
+	resetQueue(t)
+	d := Domain{Record_name: "example.com", Record_type: "A", Quarantined: true, Consecutive_failures: 5}
+	d.RecordResult(QueryResult{Target: "default", Error: "timeout"}, 10)
+	HeapPush(dh, d)
+	fillWithin(t, 5*time.Second)
+	if dh.Size() != 0 {
+		t.Fatal("the domain was not filled into the ready list")
+	}
+
+	history := apiRequest(t, "GET", "/api/v1/domains/history?domain=example.com", "", "")
+	if history.Code != http.StatusOK || !strings.Contains(history.Body.String(), "timeout") {
+		t.Errorf("history of a ready domain = %d %s", history.Code, history.Body)
+	}
+	quarantine := apiRequest(t, "GET", "/api/v1/domains/quarantine", "", "")
+	if !strings.Contains(quarantine.Body.String(), `"example.com"`) {
+		t.Errorf("quarantine listing = %s, want the ready domain", quarantine.Body)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	ImportFormatNdjson = "ndjson"
+	ImportFormatText   = "text"
+
+	// ImportModeMerge - add the imported domains which are not queued yet
+	ImportModeMerge = "merge"
+	// ImportModeReplace - additionally remove the queued domains which are not
+	// imported, unless a line failed (it may name a queued domain)
+	ImportModeReplace = "replace"
+
+	// maxImportErrors - errors reported in the import result, further errors are only counted
+	maxImportErrors = 100
+	// maxImportLineSize - longer lines abort the import
+	maxImportLineSize = 64 * 1024
+)
+
+// ImportError - an import line which was skipped
+type ImportError struct {
+	Line  int    `json:"line" example:"17"`
+	Entry string `json:"entry" example:"example.com AAA"`
+	Error string `json:"error" example:"unknown type AAA"`
+}
+
+// ImportResult - the outcome of an import
+type ImportResult struct {
+	// Added - the number of added queue entries, an entry with several subnets adds one per subnet
+	Added int `json:"added" example:"1200"`
+	// Removed - the number of queue entries removed by mode replace, none if a line failed
+	Removed int `json:"removed" example:"13"`
+	// Skipped - the number of entries which were already queued or imported
+	Skipped     int           `json:"skipped" example:"52000"`
+	ErrorsTotal int           `json:"errors_total" example:"2"`
+	Errors      []ImportError `json:"errors"`
+}
+
+func (result *ImportResult) addError(line int, entry string, err error) {
+	result.ErrorsTotal++
+	if len(result.Errors) < maxImportErrors {
+		result.Errors = append(result.Errors, ImportError{Line: line, Entry: entry, Error: err.Error()})
+	}
+}
+
+// ParseImportLine parses and validates an NDJSON domain definition or a
+// domains file entry
+func ParseImportLine(format string, line string) ([]Domain, error) {
+	var domains []Domain
+	switch format {
+	case ImportFormatNdjson:
+		var definition DomainDefinition
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&definition); err != nil {
+			return nil, fmt.Errorf("invalid domain definition: %w", err)
+		}
+		if definition.Domain == "" || definition.Type == "" {
+			return nil, fmt.Errorf("domain and type are required")
+		}
+		domain := Domain{Record_name: definition.Domain, Record_type: definition.Type, Group: definition.Group}
+		if err := domain.Normalize(); err != nil {
+			return nil, err
+		}
+		subnets := definition.Subnets
+		if len(subnets) == 0 {
+			subnets = groups[domain.GroupName()].ClientSubnets
+		}
+		for _, subnet := range subnets {
+			if err := ValidateClientSubnet(subnet); err != nil {
+				return nil, err
+			}
+		}
+		domains = ExpandClientSubnets(domain, subnets)
+	case ImportFormatText:
+		if IsSubscriptionEntry(line) {
+			return nil, fmt.Errorf("subscriptions are not imported, see /api/v1/subscriptions")
+		}
+		var err error
+		if domains, err = DomainListEntryToDomains(line); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %s (ndjson, text)", format)
+	}
+	return domains, nil
+}
+
+// QueuedDomains returns a snapshot of the queue: the heap and the ready and
+// in-flight domains of the scheduler, sorted by key
+func (dh *DomainHeap) QueuedDomains() []Domain {
+	var domains []Domain
+	pending := pendingPromotions()
+	HeapDo(dh, func(h *DomainHeap) {
+		domains = make([]Domain, 0, len(*h))
+		for _, d := range *h {
+			if pending {
+				promote(d)
+			}
+			domains = append(domains, d.snapshot())
+		}
+	})
+	if scheduler != nil {
+		for _, d := range scheduler.Outside() {
+			domains = append(domains, promoted(d))
+		}
+	}
+	slices.SortFunc(domains, func(a, b Domain) int { return strings.Compare(a.Key(), b.Key()) })
+	// a domain pushed back while taking the snapshot is listed twice
+	return slices.CompactFunc(domains, func(a, b Domain) bool { return a.Key() == b.Key() })
+}
+
+// ImportDomains reads domains line by line and adds them to the queue. Empty
+// lines and lines starting with # are ignored, invalid lines are reported and
+// skipped. Queued domains are not added again, but derived ones are promoted
+// to explicitly added domains. Mode replace removes the queued domains which
+// are not imported (and the domains derived from them) once all lines are
+// read without errors.
+func (dh *DomainHeap) ImportDomains(r io.Reader, format string, mode string) (ImportResult, error) {
+	result := ImportResult{Errors: []ImportError{}}
+	if format != ImportFormatNdjson && format != ImportFormatText {
+		return result, fmt.Errorf("unknown format %s (ndjson, text)", format)
+	}
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		return result, fmt.Errorf("unknown mode %s (merge, replace)", mode)
+	}
+
+	queued := make(map[string]bool)
+	for _, d := range dh.QueuedDomains() {
+		queued[d.Key()] = true
+	}
+	imported := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxImportLineSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := string(bytes.TrimSpace(scanner.Bytes()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains, err := ParseImportLine(format, line)
+		if err != nil {
+			result.addError(lineNumber, line, err)
+			continue
+		}
+		for _, domain := range domains {
+			key := domain.Key()
+			if imported[key] {
+				result.Skipped++
+				continue
+			}
+			imported[key] = true
+			// a queued domain derived from another one is promoted, it is
+			// explicitly added now
+			if err := dh.AddDomain(domain); err != nil {
+				result.addError(lineNumber, line, err)
+				break
+			}
+			if queued[key] {
+				result.Skipped++
+			} else {
+				result.Added++
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("line %d: %w", lineNumber+1, err)
+	}
+
+	// a failed line may name a queued domain, which must not be removed
+	if mode == ImportModeReplace && result.ErrorsTotal == 0 {
+		// derived domains are removed together with their parent
+		result.Removed = dh.RemoveDomains(func(d *Domain) bool { return d.Parent == "" && !imported[d.Key()] })
+	}
+	return result, nil
+}
+
+// ExportLine formats a domain as NDJSON domain definition or domains file entry
+func ExportLine(format string, domain Domain) string {
+	if format == ImportFormatNdjson {
+		definition := DomainDefinition{Domain: domain.Record_name, Type: domain.Record_type, Group: domain.Group}
+		if domain.Client_subnet != "" {
+			definition.Subnets = []string{domain.Client_subnet}
+		}
+		line, _ := json.Marshal(definition)
+		return string(line)
+	}
+	line := domain.Record_name + " " + domain.Record_type
+	if domain.Client_subnet != "" {
+		line += " ecs=" + domain.Client_subnet
+	}
+	if domain.Group != "" {
+		line += " group=" + domain.Group
+	}
+	return line
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// queuedKeyList returns the keys of the queued domains
+func queuedKeyList() []string {
+	var keys []string
+	for _, d := range dh.QueuedDomains() {
+		keys = append(keys, d.Key())
+	}
+	return keys
+}
+
+func TestParseImportLine(t *testing.T) {
+	tests := []struct {
+		format string
+		line   string
+		want   []string
+	}{
+		{ImportFormatText, "Example.com. a", []string{"default example.com IN A"}},
+		{ImportFormatText, "example.com AAAA ecs=192.0.2.0/24,2001:db8::/56", []string{"default example.com IN AAAA (ecs 192.0.2.0/24)", "default example.com IN AAAA (ecs 2001:db8::/56)"}},
+		{ImportFormatNdjson, `{"domain":"example.com","type":"MX"}`, []string{"default example.com IN MX"}},
+		{ImportFormatNdjson, `{"domain":"example.com","type":"A","subnets":["192.0.2.0/24"]}`, []string{"default example.com IN A (ecs 192.0.2.0/24)"}},
+	}
+	for _, test := range tests {
+		domains, err := ParseImportLine(test.format, test.line)
+		if err != nil {
+			t.Errorf("ParseImportLine(%s, %s) = %v", test.format, test.line, err)
+			continue
+		}
+		var keys []string
+		for _, d := range domains {
+			keys = append(keys, d.Key())
+		}
+		if !slices.Equal(keys, test.want) {
+			t.Errorf("ParseImportLine(%s, %s) = %v, want %v", test.format, test.line, keys, test.want)
+		}
+	}
+	for _, line := range []string{"example.com", "example.com AAA", "-invalid-.com A", "example.com A group=unknown", "example.com A ecs=192.0.2.1"} {
+		if _, err := ParseImportLine(ImportFormatText, line); err == nil {
+			t.Errorf("ParseImportLine(text, %s) accepted an invalid line", line)
+		}
+	}
+	for _, line := range []string{`{"domain":"example.com"}`, `{"domain":"example.com","type":"A"`, `example.com A`} {
+		if _, err := ParseImportLine(ImportFormatNdjson, line); err == nil {
+			t.Errorf("ParseImportLine(ndjson, %s) accepted an invalid line", line)
+		}
+	}
+}
+
+func TestImportDomainsMerge(t *testing.T) {
+	resetQueue(t)
+	if err := dh.AddDomain(Domain{Record_name: "queued.example", Record_type: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		"# comment",
+		"new.example A",
+		"",
+		"queued.example A",
+		"new.example A",
+		"new.example AAA",
+		"other.example MX",
+	}, "\n")
+
+	result, err := dh.ImportDomains(strings.NewReader(input), ImportFormatText, ImportModeMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 2 || result.Skipped != 2 || result.Removed != 0 || result.ErrorsTotal != 1 {
+		t.Errorf("import result = %+v, want 2 added, 2 skipped and 1 error", result)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Line != 6 || result.Errors[0].Entry != "new.example AAA" {
+		t.Errorf("import errors = %+v, want line 6", result.Errors)
+	}
+	want := []string{"default new.example IN A", "default other.example IN MX", "default queued.example IN A"}
+	if keys := queuedKeyList(); !slices.Equal(keys, want) {
+		t.Errorf("queue = %v, want %v", keys, want)
+	}
+}
+
+func TestImportDomainsReplace(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "web", CompanionTypes: []string{"AAAA"}})
+	for _, d := range []Domain{
+		{Record_name: "kept.example", Record_type: "A", Group: "web"},
+		{Record_name: "removed.example", Record_type: "A", Group: "web"},
+	} {
+		if err := dh.AddDomain(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input := "kept.example A group=web\nnew.example A\n"
+	result, err := dh.ImportDomains(strings.NewReader(input), ImportFormatText, ImportModeReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// removed.example goes together with its companion
+	if result.Added != 1 || result.Skipped != 1 || result.Removed != 2 {
+		t.Errorf("import result = %+v, want 1 added, 1 skipped and 2 removed", result)
+	}
+	want := []string{"default new.example IN A", "web kept.example IN A", "web kept.example IN AAAA"}
+	if keys := queuedKeyList(); !slices.Equal(keys, want) {
+		t.Errorf("queue = %v, want %v", keys, want)
+	}
+}
+
+func TestImportDomainsReplacePromotesDerivedDomains(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "web", CompanionTypes: []string{"AAAA"}})
+	if err := dh.AddDomain(Domain{Record_name: "example.com", Record_type: "A", Group: "web"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the companion is imported explicitly, its parent is not
+	result, err := dh.ImportDomains(strings.NewReader("example.com AAAA group=web\n"), ImportFormatText, ImportModeReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Skipped != 1 || result.Removed != 1 {
+		t.Errorf("import result = %+v, want 1 skipped and 1 removed", result)
+	}
+	if keys := queuedKeyList(); !slices.Equal(keys, []string{"web example.com IN AAAA"}) {
+		t.Fatalf("queue = %v, want the imported entry", keys)
+	}
+	if d := queuedEntry(t, "web example.com IN AAAA"); d.Parent != "" || d.Derived != "" {
+		t.Errorf("imported entry derived %q from %q", d.Derived, d.Parent)
+	}
+}
+
+func TestImportDomainsReplaceKeepsTheQueueOnErrors(t *testing.T) {
+	resetQueue(t)
+	if err := dh.AddDomain(Domain{Record_name: "queued.example", Record_type: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	// the typo names the queued domain, which must not be removed
+	input := "new.example A\nqueued.example AA\n"
+	result, err := dh.ImportDomains(strings.NewReader(input), ImportFormatText, ImportModeReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 1 || result.Removed != 0 || result.ErrorsTotal != 1 {
+		t.Errorf("import result = %+v, want 1 added, none removed and 1 error", result)
+	}
+	want := []string{"default new.example IN A", "default queued.example IN A"}
+	if keys := queuedKeyList(); !slices.Equal(keys, want) {
+		t.Errorf("queue = %v, want %v", keys, want)
+	}
+}
+
+func TestImportDomainsRejectsUnknownArguments(t *testing.T) {
+	if _, err := dh.ImportDomains(strings.NewReader(""), "csv", ImportModeMerge); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+	if _, err := dh.ImportDomains(strings.NewReader(""), ImportFormatText, "sync"); err == nil {
+		t.Error("an unknown mode was accepted")
+	}
+}
+
+func TestExportLineCanBeImported(t *testing.T) {
+	domains := []Domain{
+		{Record_name: "example.com", Record_type: "A"},
+		{Record_name: "example.com", Record_type: "AAAA", Client_subnet: "2001:db8::/56"},
+	}
+	for _, format := range []string{ImportFormatNdjson, ImportFormatText} {
+		for _, domain := range domains {
+			line := ExportLine(format, domain)
+			imported, err := ParseImportLine(format, line)
+			if err != nil || len(imported) != 1 || imported[0].Key() != domain.Key() {
+				t.Errorf("%s line %s imports as %v %v, want %s", format, line, imported, err, domain.Key())
+			}
+		}
+	}
+}
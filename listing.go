@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 10000
+)
+
+const (
+	DomainStatusOk          = "ok"
+	DomainStatusPending     = "pending"
+	DomainStatusFailing     = "failing"
+	DomainStatusQuarantined = "quarantined"
+)
+
+const (
+	SortDue  = "due"
+	SortName = "name"
+	SortTtl  = "ttl"
+)
+
+// DomainListEntry - a queued domain as listed by the api
+type DomainListEntry struct {
+	DomainDefinition
+	RefreshAt int64 `json:"refresh_at" example:"1700000000000"`
+	// Ttl - the lowest ttl of the last results, 0 if not resolved yet
+	Ttl    uint   `json:"ttl" example:"300"`
+	Status string `json:"status" example:"ok"`
+	// Derived - companion or target if the entry was derived from another entry
+	Derived string `json:"derived,omitempty" example:"companion"`
+	// Parent - the entry it was derived from
+	Parent string `json:"parent,omitempty" example:"default google.com IN A"`
+}
+
+// DomainFilter - selects domains for the listing, empty fields match everything
+type DomainFilter struct {
+	Type   string
+	Group  string
+	Subnet string
+	Suffix string
+	Regex  string
+	Status string
+}
+
+// Matcher returns a function reporting whether a domain passes the filter
+func (filter DomainFilter) Matcher() (func(d *Domain) bool, error) {
+	if filter.Status != "" && !slices.Contains([]string{DomainStatusOk, DomainStatusPending, DomainStatusFailing, DomainStatusQuarantined}, filter.Status) {
+		return nil, fmt.Errorf("unknown status %s (ok, pending, failing, quarantined)", filter.Status)
+	}
+	matchName := func(d *Domain) bool { return true }
+	if filter.Suffix != "" || filter.Regex != "" || filter.Group != "" {
+		var err error
+		if matchName, err = (RefreshSelector{Suffix: filter.Suffix, Regex: filter.Regex, Group: filter.Group}).Matcher(); err != nil {
+			return nil, err
+		}
+	}
+	return func(d *Domain) bool {
+		return (filter.Type == "" || strings.EqualFold(d.Record_type, filter.Type)) &&
+			(filter.Subnet == "" || d.Client_subnet == filter.Subnet) &&
+			(filter.Status == "" || d.Status() == filter.Status) &&
+			matchName(d)
+	}, nil
+}
+
+// listCursor - the position after the last entry of a page
+type listCursor struct {
+	Value int64  `json:"v"`
+	Key   string `json:"k"`
+}
+
+func (cursor listCursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(s string) (listCursor, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return cursor, fmt.Errorf("invalid cursor")
+	}
+	return cursor, nil
+}
+
+type listedDomain struct {
+	entry  DomainListEntry
+	cursor listCursor
+}
+
+// ListDomains returns a page of the matching domains sorted by sortBy, the
+// total number of matching domains and the cursor of the next page (empty on
+// the last page). The domains are taken from a snapshot of the queue, see
+// QueuedDomains.
+func (dh *DomainHeap) ListDomains(filter DomainFilter, sortBy string, cursor string, limit int) ([]DomainListEntry, int, string, error) {
+	if sortBy == "" {
+		sortBy = SortDue
+	}
+	if !slices.Contains([]string{SortDue, SortName, SortTtl}, sortBy) {
+		return nil, 0, "", fmt.Errorf("unknown sort %s (due, name, ttl)", sortBy)
+	}
+	if limit <= 0 || limit > MaxListLimit {
+		return nil, 0, "", fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+	}
+	var after *listCursor
+	if cursor != "" {
+		decoded, err := decodeListCursor(cursor)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		after = &decoded
+	}
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	var listed []listedDomain
+	domains := dh.QueuedDomains()
+	for i := range domains {
+		d := &domains[i]
+		if !match(d) {
+			continue
+		}
+		entry := DomainListEntry{
+			DomainDefinition: DomainDefinition{Domain: d.Record_name, Type: d.Record_type, Group: d.Group},
+			RefreshAt:        d.Refresh_at,
+			Ttl:              d.LastTtl(),
+			Status:           d.Status(),
+			Derived:          d.Derived,
+			Parent:           d.Parent,
+		}
+		if d.Client_subnet != "" {
+			entry.Subnets = []string{d.Client_subnet}
+		}
+		item := listedDomain{entry: entry, cursor: listCursor{Key: d.Key()}}
+		switch sortBy {
+		case SortDue:
+			item.cursor.Value = d.Refresh_at
+		case SortTtl:
+			item.cursor.Value = int64(entry.Ttl)
+		}
+		listed = append(listed, item)
+	}
+
+	compare := func(a, b listCursor) int {
+		if a.Value != b.Value {
+			return int(max(-1, min(1, a.Value-b.Value)))
+		}
+		return strings.Compare(a.Key, b.Key)
+	}
+	slices.SortFunc(listed, func(a, b listedDomain) int { return compare(a.cursor, b.cursor) })
+
+	start := 0
+	if after != nil {
+		start, _ = slices.BinarySearchFunc(listed, *after, func(item listedDomain, target listCursor) int {
+			if compare(item.cursor, target) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	end := min(start+limit, len(listed))
+	page := make([]DomainListEntry, 0, end-start)
+	for _, item := range listed[start:end] {
+		page = append(page, item.entry)
+	}
+	next := ""
+	if end < len(listed) {
+		next = listed[end-1].cursor.Encode()
+	}
+	return page, len(listed), next, nil
+}
+
+// CountDomains returns the number of queued domains passing the filter
+func (dh *DomainHeap) CountDomains(filter DomainFilter) (int, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	domains := dh.QueuedDomains()
+	for i := range domains {
+		if match(&domains[i]) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// QueueSize returns the number of queued domains, including the ready and
+// in-flight domains of the scheduler
+func (dh *DomainHeap) QueueSize() int {
+	size := dh.Size()
+	if scheduler != nil {
+		size += len(scheduler.Outside())
+	}
+	return size
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// listAll pages through the listing with limit and returns the entries
+func listAll(t *testing.T, filter DomainFilter, sortBy string, limit int) ([]DomainListEntry, int) {
+	t.Helper()
+	var listed []DomainListEntry
+	cursor, total := "", -1
+	for pages := 0; pages < 100; pages++ {
+		page, pageTotal, next, err := dh.ListDomains(filter, sortBy, cursor, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > limit {
+			t.Fatalf("page of %d entries, limit %d", len(page), limit)
+		}
+		if total >= 0 && pageTotal != total {
+			t.Fatalf("total changed from %d to %d between pages", total, pageTotal)
+		}
+		total = pageTotal
+		listed = append(listed, page...)
+		if next == "" {
+			return listed, total
+		}
+		cursor = next
+	}
+	t.Fatal("the listing did not end")
+	return nil, 0
+}
+
+func listedNames(entries []DomainListEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Group+" "+entry.Domain+" "+entry.Type)
+	}
+	return names
+}
+
+func TestListDomainsPagesThroughTheQueue(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "critical", Priority: 1})
+	now := time.Now().UnixMilli()
+	for i, name := range []string{"e.example", "c.example", "a.example", "d.example", "b.example"} {
+		HeapPush(dh, Domain{Record_name: name, Record_type: "A", Refresh_at: now + int64(i)*1000})
+	}
+	// same name in another group, same due time as a.example
+	HeapPush(dh, Domain{Record_name: "a.example", Record_type: "A", Group: "critical", Refresh_at: now + 2000})
+
+	byName, total := listAll(t, DomainFilter{}, SortName, 2)
+	want := []string{"critical a.example A", " a.example A", " b.example A", " c.example A", " d.example A", " e.example A"}
+	if total != 6 || !slices.Equal(listedNames(byName), want) {
+		t.Errorf("listing by name = %v of %d, want %v", listedNames(byName), total, want)
+	}
+	byDue, _ := listAll(t, DomainFilter{}, SortDue, 4)
+	want = []string{" e.example A", " c.example A", "critical a.example A", " a.example A", " d.example A", " b.example A"}
+	if !slices.Equal(listedNames(byDue), want) {
+		t.Errorf("listing by due time = %v, want %v", listedNames(byDue), want)
+	}
+}
+
+func TestListDomainsIncludesReadyAndInFlightDomains(t *testing.T) {
+	resetQueue(t)
+	queueDue(t, 3, DefaultGroupName)
+	fillWithin(t, 5*time.Second)
+	HeapPush(dh, Domain{Record_name: "later.example", Record_type: "A", Refresh_at: time.Now().Add(time.Hour).UnixMilli()})
+	if dh.Size() != 1 {
+		t.Fatalf("%d domains on the heap, want the one which is not due", dh.Size())
+	}
+
+	listed, total := listAll(t, DomainFilter{}, SortName, 10)
+	if total != 4 || len(listed) != 4 {
+		t.Errorf("listing = %v of %d, want the 3 ready domains and the scheduled one", listedNames(listed), total)
+	}
+	if size := dh.QueueSize(); size != 4 {
+		t.Errorf("QueueSize = %d, want 4", size)
+	}
+	if count, err := dh.CountDomains(DomainFilter{Regex: `^d\d\.`}); err != nil || count != 3 {
+		t.Errorf("CountDomains = %d %v, want the 3 ready domains", count, err)
+	}
+}
+
+func TestListDomainsFilters(t *testing.T) {
+	resetQueue(t)
+	HeapPush(dh, Domain{Record_name: "www.example.com", Record_type: "A", Last_result: map[string]QueryResult{"a": {Ttl: 300}}})
+	HeapPush(dh, Domain{Record_name: "www.example.com", Record_type: "AAAA", Consecutive_failures: 2})
+	HeapPush(dh, Domain{Record_name: "mail.example.com", Record_type: "A", Client_subnet: "192.0.2.0/24"})
+	HeapPush(dh, Domain{Record_name: "example.org", Record_type: "A", Quarantined: true})
+
+	tests := []struct {
+		filter DomainFilter
+		want   int
+	}{
+		{DomainFilter{}, 4},
+		{DomainFilter{Type: "a"}, 3},
+		{DomainFilter{Suffix: "example.com"}, 3},
+		{DomainFilter{Regex: `^www\.`}, 2},
+		{DomainFilter{Subnet: "192.0.2.0/24"}, 1},
+		{DomainFilter{Status: DomainStatusOk}, 1},
+		{DomainFilter{Status: DomainStatusPending}, 1},
+		{DomainFilter{Status: DomainStatusFailing}, 1},
+		{DomainFilter{Status: DomainStatusQuarantined}, 1},
+		{DomainFilter{Type: "A", Suffix: "example.com", Status: DomainStatusPending}, 1},
+	}
+	for _, test := range tests {
+		listed, total := listAll(t, test.filter, SortTtl, 1)
+		if total != test.want || len(listed) != test.want {
+			t.Errorf("listing %+v = %v of %d, want %d", test.filter, listedNames(listed), total, test.want)
+		}
+		if count, err := dh.CountDomains(test.filter); err != nil || count != test.want {
+			t.Errorf("CountDomains(%+v) = %d %v, want %d", test.filter, count, err, test.want)
+		}
+	}
+}
+
+func TestListDomainsRejectsInvalidArguments(t *testing.T) {
+	tests := []struct {
+		filter DomainFilter
+		sortBy string
+		cursor string
+		limit  int
+	}{
+		{DomainFilter{}, "size", "", 10},
+		{DomainFilter{}, SortName, "", 0},
+		{DomainFilter{}, SortName, "", MaxListLimit + 1},
+		{DomainFilter{}, SortName, "not a cursor", 10},
+		{DomainFilter{Status: "unknown"}, SortName, "", 10},
+		{DomainFilter{Regex: "("}, SortName, "", 10},
+	}
+	for _, test := range tests {
+		if _, _, _, err := dh.ListDomains(test.filter, test.sortBy, test.cursor, test.limit); err == nil {
+			t.Errorf("ListDomains(%+v, %s, %q, %d) accepted invalid arguments", test.filter, test.sortBy, test.cursor, test.limit)
+		}
+	}
+}
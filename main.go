@@ -3,56 +3,81 @@ package main
 import (
 	"bufio"
 	"container/heap"
+	"fmt"
 	"math/rand"
+	"net/netip"
 	"os"
+	"os/signal"
 	"slices"
-	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	_ "github.com/santosh/gingo/docs"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 )
 
+// version - the release, set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
 var (
-	domainsAdded = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "domains_added",
-		Help: "The total number of added domains",
+	domainsAdded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "domains_added_total",
+		Help:      "The total number of added domains",
 	})
-	queryResponseTimes = prometheus.NewHistogram(
+	queryResponseTimes = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "syringe",
-			Name:      "query_response_time",
-			Help:      "query_response_time",
+			Name:      "refresh_duration_seconds",
+			Help:      "The duration of a refresh of a domain on all targets of its group",
 			Buckets:   []float64{0.1, 0.2, 0.5, 1.0, 1.5, 2, 5},
-		})
-	queryResponseTtl = prometheus.NewHistogram(
+		},
+		[]string{"group"},
+	)
+	queryResponseTtl = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "syringe",
-			Name:      "query_response_ttl",
-			Help:      "query_response_ttl",
+			Name:      "refresh_ttl_seconds",
+			Help:      "The ttl resulting from a refresh, before the refresh-ahead of the group",
 			Buckets:   []float64{0, 5, 10, 30, 60, 300, 600, 900, 1800, 3600, 86400},
-		})
-	queueSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		},
+		[]string{"group"},
+	)
+	queueSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "syringe",
 		Name:      "queue_size",
-		Help:      "The current size of the queue",
-	})
+		Help:      "The current number of queued domains by state (scheduled, ready, in_flight)",
+	},
+		[]string{"state"},
+	)
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by version, revision and goversion of the build",
+	},
+		[]string{"version", "revision", "goversion"},
+	)
 	// heapPushChan - push channel for pushing to a heap
 	heapPushChan = make(chan heapPushChanMsg)
 	// heapPopChan - pop channel for popping from a heap
 	heapPopChan = make(chan heapPopChanMsg)
-	domainList  []string
+	// heapDoChan - channel for running functions with exclusive access to a heap
+	heapDoChan = make(chan heapDoChanMsg)
+	domainList []string
 )
 
 var resolverConfiguration *ResolverConfiguration = &ResolverConfiguration{}
 var ginInstance *gin.Engine
 var dh *DomainHeap
-var resolverStrategies *ResolverStrategies
+var targets []*Target
+var differ *Differ
+var schedule *Schedule
+var scheduler *Scheduler
 
 func init() {
 	// Initialize configuration
@@ -62,7 +87,9 @@ func init() {
 	}
 
 	// Log as JSON instead of the default ASCII formatter.
-	//log.SetFormatter(&log.JSONFormatter{})
+	if resolverConfiguration.LogFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
 
 	// Output to stdout instead of the default stderr
 	// Can be any io.Writer, see below for File example
@@ -75,8 +102,39 @@ func init() {
 	prometheus.Register(queryResponseTimes)
 	prometheus.Register(queryResponseTtl)
 	prometheus.Register(queueSize)
+	prometheus.Register(buildInfo)
+	buildInfo.With(BuildInfoLabels()).Set(1)
+
+	dh = &DomainHeap{}
+	heap.Init(dh)
+	// Start the queue serializer (will schedule heap access)
+	dh.watchHeapOps()
+}
+
+// setupTargets initializes the targets, the rate limiter and the groups, which
+// are needed to query
+func setupTargets() {
+	targets = SetupTargets(resolverConfiguration)
+	queryLimiter = NewQueryLimiter(resolverConfiguration.QueryRateLimit)
+	for _, subnet := range resolverConfiguration.ClientSubnets {
+		if err := ValidateClientSubnet(subnet); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !slices.Contains([]string{ReservedNamesReject, ReservedNamesWarn, ReservedNamesAllow}, resolverConfiguration.ReservedNamePolicy) {
+		log.Fatal("unknown ReservedNamePolicy ", resolverConfiguration.ReservedNamePolicy, " (reject, warn, allow)")
+	}
+	var err error
+	groups, groupsByPriority, err = SetupGroups(resolverConfiguration)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	// Initialize router/variables
+// setupServer initializes everything else the daemon needs: the router, the
+// differ, tracing, exclusions, schedules, api tokens and the scheduler with
+// its persisted state
+func setupServer() {
 	if resolverConfiguration.LogLevel >= uint(log.DebugLevel) {
 		log.Debug("Setting gin mode to DebugMode because LogLevel is set to ", resolverConfiguration.LogLevel, ". Using LogLevel<", uint(log.DebugLevel), " will set the mode to release")
 		gin.SetMode(gin.DebugMode)
@@ -84,17 +142,29 @@ func init() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	ginInstance = SetupRouter()
-	dh = &DomainHeap{}
-	heap.Init(dh)
-	// Start the queue serializer (will schedule heap access)
-	dh.watchHeapOps()
-
-	// Initialize strategies
-	resolverStrategies = &ResolverStrategies{
-		ResolveFunctions: []func(config *ResolverConfiguration, domain *Domain) (uint, error){
-			TryQueryRegularDomain,
-			TryQuerySOADomain,
-			TryQueryFlexibleDelayDomain},
+	var err error
+	differ, err = NewDiffer(resolverConfiguration.Diff)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := SetupTracing(resolverConfiguration.Tracing); err != nil {
+		log.Fatal(err)
+	}
+	exclusions, err = SetupExclusions(resolverConfiguration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	schedule, err = NewSchedule(resolverConfiguration.Schedules, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiTokens, err = SetupApiTokens(resolverConfiguration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scheduler = NewScheduler(dh, resolverConfiguration)
+	if err := LoadSchedulerState(resolverConfiguration.StateFile); err != nil {
+		log.Fatal(err)
 	}
 }
 
@@ -112,16 +182,43 @@ func init() {
 
 // @host      localhost:8000
 // @BasePath  /api/v1
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+// @description                 'Bearer <token>' - required if ApiTokens are configured
 func main() {
+	// the subcommands only load what they need, not the state or exclusions
+	switch pflag.Arg(0) {
+	case "probe":
+		setupTargets()
+		os.Exit(RunProbeCommand(pflag.Args()[1:]))
+	case "token":
+		os.Exit(RunTokenCommand(pflag.Args()[1:]))
+	}
+	setupTargets()
+	setupServer()
+
 	// Register api endpoints
 	ginInstance.GET("/docs/*any", DocOverrideHandler)
 	ginInstance.StaticFile("/swagger-static/doc.json", "docs/swagger.json")
-	ginInstance.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	if resolverConfiguration.ProtectMetrics {
+		ginInstance.GET("/metrics", ApiAuth(ScopeRead), gin.WrapH(promhttp.Handler()))
+	} else {
+		ginInstance.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	}
 	// Run the webserver
+	server, err := NewApiServer(resolverConfiguration, ginInstance)
+	if err != nil {
+		log.Fatal(err)
+	}
 	go func() {
-		ginInstance.Run(":" + strconv.FormatUint(uint64(resolverConfiguration.ServerListenPort), 10))
+		log.Fatal(ServeApi(server))
 	}()
 
+	// Reload changed exclusion files
+	go exclusions.Watch(dh)
+
 	if resolverConfiguration.LoadDomainsFileOnStart {
 		log.Debug("Loading domains from ", resolverConfiguration.DomainsFile)
 		rowsRead, err := dh.LoadDomainsFile()
@@ -130,39 +227,29 @@ func main() {
 		}
 		log.Info("Read ", rowsRead, " rows from DomainsFile ", resolverConfiguration.DomainsFile)
 	}
+	domainsLoaded.Store(true)
 
-	// Main loop
-	for {
-		queueSize.Set(float64(dh.Len()))
-		if dh.Len() == 0 {
-			time.Sleep(time.Duration(resolverConfiguration.SleepLowTresholdMilliseconds) * time.Millisecond)
-			continue
-		}
+	// Open and close schedule windows
+	go schedule.Run()
 
-		var cur Domain = HeapPop(dh).(Domain)
-		sleep_time := cur.MillisUntilDue()
-		if sleep_time > int64(resolverConfiguration.SleepLowTresholdMilliseconds) {
-			HeapPush(dh, cur)
-			time.Sleep(time.Duration(resolverConfiguration.SleepLowTresholdCheckIntervalMilliseconds) * time.Millisecond)
-			continue
-		}
+	// Export the remaining spans when terminated
+	go shutdownOnSignal(syscall.SIGINT, syscall.SIGTERM)
 
-		if sleep_time >= 0 && sleep_time <= int64(resolverConfiguration.SleepLowTresholdCheckIntervalMilliseconds) {
-			time.Sleep(time.Duration(sleep_time) * time.Millisecond)
-		}
+	// Main loop
+	scheduler.Run()
+}
 
-		ch := make(chan uint, 1)
-		go func() {
-			start := time.Now()
-			go cur.Query(resolverStrategies, resolverConfiguration, ch)
-			var ttl = <-ch
-			cur.RefreshInSeconds(ttl)
-			HeapPush(dh, cur)
-			elapsed := time.Since(start)
-			queryResponseTimes.Observe(elapsed.Seconds())
-			queryResponseTtl.Observe(float64(ttl))
-		}()
+// shutdownOnSignal exports the remaining spans and exits once one of the
+// signals is received
+func shutdownOnSignal(signals ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	sig := <-c
+	log.Info("received ", sig, ", shutting down")
+	if err := ShutdownTracing(tracingShutdownTimeout); err != nil {
+		log.Error(err)
 	}
+	os.Exit(0)
 }
 
 func ReadDomainsFile(f string) ([]string, error) {
@@ -178,8 +265,8 @@ func ReadDomainsFile(f string) ([]string, error) {
 	for fileScanner.Scan() {
 		i++
 		line_split := strings.Split(fileScanner.Text(), " ")
-		if len(line_split) != 2 {
-			log.Error("Malformed line ", i, " in file ", resolverConfiguration.DomainsFile, " syntax='<domain> <rr type>' each per line - example: 'google.de A'")
+		if len(line_split) < 2 {
+			log.Error("Malformed line ", i, " in file ", resolverConfiguration.DomainsFile, " syntax='<domain> <rr type> [ecs=<subnet>,...] [group=<name>]' each per line - example: 'google.de A'")
 			continue
 		}
 		domainsRead = append(domainsRead, fileScanner.Text())
@@ -197,9 +284,28 @@ func (dh *DomainHeap) LoadDomainsFile() (uint, error) {
 	}
 
 	for _, d := range domainsRead {
+		if IsSubscriptionEntry(d) {
+			definition, err := ParseSubscriptionEntry(d)
+			if err == nil {
+				_, err = subscriptions.Add(definition, SubscriptionSourceFile)
+			}
+			if err != nil {
+				log.Error(err)
+			}
+			continue
+		}
 		if !slices.Contains(domainList, d) {
+			domains, err := DomainListEntryToDomains(d)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
 			i++
-			dh.AddDomain(DomainListEntryToDomain(d))
+			for _, domain := range domains {
+				if err := dh.AddDomain(domain); err != nil {
+					log.Info("Skipping ", domain.ToString(), " in file ", resolverConfiguration.DomainsFile, ": ", err)
+				}
+			}
 		}
 	}
 	return i, nil
@@ -211,18 +317,79 @@ func (dh *DomainHeap) AppendRandom(delay_seconds uint) {
 		return
 	}
 	domain_index := rand.Intn(len(domainList) - 1)
-	domain := DomainListEntryToDomain(domainList[domain_index])
-	domain.RefreshInSeconds(delay_seconds)
-	dh.AddDomain(domain)
+	if IsSubscriptionEntry(domainList[domain_index]) {
+		return
+	}
+	domains, err := DomainListEntryToDomains(domainList[domain_index])
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, domain := range domains {
+		domain.RefreshInSeconds(delay_seconds)
+		dh.AddDomain(domain)
+	}
 }
 
-func DomainListEntryToDomain(d string) Domain {
-	line_split := strings.Split(d, " ")
+// DomainListEntryToDomains parses a domains file entry '<domain> <rr type> [ecs=<subnet>,...] [group=<name>]'.
+// An entry with several client subnets yields one domain per subnet. Without
+// ecs option the client subnets of the group apply. An IP prefix instead of the
+// domain yields its reverse domains, see ReversePrefixDomains.
+func DomainListEntryToDomains(d string) ([]Domain, error) {
+	line_split := strings.Fields(d)
+	if len(line_split) < 2 {
+		return nil, fmt.Errorf("malformed domains file entry '%s', syntax='<domain> <rr type> [ecs=<subnet>,...] [group=<name>]'", d)
+	}
 
 	domain_name := line_split[0]
 	rr_type := line_split[1]
+	var subnets []string
+	group_name := ""
+
+	for _, option := range line_split[2:] {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "ecs":
+			subnets = strings.Split(value, ",")
+		case "group":
+			group_name = value
+		default:
+			return nil, fmt.Errorf("unknown option '%s' in domains file entry '%s'", key, d)
+		}
+	}
+
+	group, err := FindGroup(group_name)
+	if err != nil {
+		return nil, fmt.Errorf("%w in domains file entry '%s'", err, d)
+	}
+	if subnets == nil {
+		subnets = group.ClientSubnets
+	}
+	for _, subnet := range subnets {
+		if err := ValidateClientSubnet(subnet); err != nil {
+			return nil, fmt.Errorf("%w in domains file entry '%s'", err, d)
+		}
+	}
 
-	return Domain{Record_name: domain_name, Record_type: rr_type, Refresh_at: 0}
+	domains := []Domain{{Record_name: domain_name, Record_type: rr_type, Refresh_at: 0}}
+	if prefix, err := netip.ParsePrefix(domain_name); err == nil {
+		if domains, err = ReversePrefixDomains(prefix, strings.Split(rr_type, ",")); err != nil {
+			return nil, fmt.Errorf("%w in domains file entry '%s'", err, d)
+		}
+		// every reverse name is queued once per client subnet
+		if total := len(domains) * max(1, len(subnets)); uint(total) > resolverConfiguration.ReverseMaxNames {
+			return nil, fmt.Errorf("prefix %s expands to %d domains with %d client subnets, more than %d in domains file entry '%s'", prefix, total, len(subnets), resolverConfiguration.ReverseMaxNames, d)
+		}
+	}
+	expanded := make([]Domain, 0, len(domains)*max(1, len(subnets)))
+	for _, domain := range domains {
+		domain.Group = group_name
+		if err := domain.Normalize(); err != nil {
+			return nil, fmt.Errorf("%w in domains file entry '%s'", err, d)
+		}
+		expanded = append(expanded, ExpandClientSubnets(domain, subnets)...)
+	}
+	return expanded, nil
 }
 
 func LoadDomainsBulkWithApproxRateLimit(qps int, d []Domain) {
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	schedulerPausedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "scheduler_paused",
+		Help:      "1 if dispatching of all refreshes is paused",
+	})
+	targetPausedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "target_paused",
+		Help:      "1 if no refreshes are sent to the target",
+	},
+		[]string{"target"},
+	)
+)
+
+func init() {
+	prometheus.Register(schedulerPausedGauge)
+	prometheus.Register(targetPausedGauge)
+}
+
+// SchedulerState - the pause state, persisted in the StateFile
+type SchedulerState struct {
+	Paused        bool     `json:"paused" example:"false"`
+	PausedTargets []string `json:"paused_targets" example:"resolver1"`
+}
+
+// DrainResult - the outcome of waiting for in-flight refreshes
+type DrainResult struct {
+	// InFlight - refreshes in flight when the drain started
+	InFlight int64 `json:"in_flight" example:"12"`
+	// Remaining - refreshes still in flight when the drain returned
+	Remaining int64 `json:"remaining" example:"0"`
+	Drained   bool  `json:"drained" example:"true"`
+	WaitedMs  int64 `json:"waited_ms" example:"230"`
+}
+
+// stateMu serializes pause/resume and writing the StateFile
+var stateMu sync.Mutex
+
+// CurrentSchedulerState returns whether dispatching and which targets are paused
+func CurrentSchedulerState() SchedulerState {
+	state := SchedulerState{Paused: scheduler.Paused(), PausedTargets: []string{}}
+	for _, target := range targets {
+		if target.Paused() {
+			state.PausedTargets = append(state.PausedTargets, target.Id)
+		}
+	}
+	return state
+}
+
+// LoadSchedulerState restores the pause state of the StateFile. A missing
+// file is not an error, unknown targets are skipped.
+func LoadSchedulerState(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state SchedulerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	scheduler.SetPaused(state.Paused)
+	for _, id := range state.PausedTargets {
+		target, err := FindTarget(id)
+		if err != nil {
+			log.Warn("ignoring paused target from state file: ", err)
+			continue
+		}
+		target.SetPaused(true)
+	}
+	if state.Paused || len(state.PausedTargets) > 0 {
+		log.Warn("restored pause state from ", path, ": paused=", state.Paused, " paused_targets=", state.PausedTargets)
+	}
+	return nil
+}
+
+// saveSchedulerState writes the pause state to the StateFile. The caller must hold stateMu.
+func saveSchedulerState(path string) {
+	if path == "" {
+		return
+	}
+	data, _ := json.Marshal(CurrentSchedulerState())
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Error("unable to write state file: ", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Error("unable to write state file: ", err)
+	}
+}
+
+// Pause stops dispatching refreshes, globally if target is nil, otherwise
+// only to the target
+func Pause(target *Target) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if target == nil {
+		log.Warn("pausing scheduler")
+		scheduler.SetPaused(true)
+	} else {
+		log.Warn("pausing target ", target.Id)
+		target.SetPaused(true)
+	}
+	saveSchedulerState(resolverConfiguration.StateFile)
+}
+
+// Resume continues dispatching refreshes. Domains of the groups sending
+// refreshes to the target (all groups if target is nil) which became due
+// while paused are spread out at the rate of their group to avoid a burst.
+// Returns the number of spread domains.
+func Resume(target *Target) int {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if target == nil {
+		log.Warn("resuming scheduler")
+		scheduler.SetPaused(false)
+	} else {
+		log.Warn("resuming target ", target.Id)
+		target.SetPaused(false)
+	}
+	saveSchedulerState(resolverConfiguration.StateFile)
+
+	spread := 0
+	for _, group := range groupsByPriority {
+		if target != nil && !slices.Contains(group.Targets, target) {
+			continue
+		}
+		qps := ResumeRate(group)
+		if n := dh.SpreadOverdue(group, qps); n > 0 {
+			log.Info("spread ", n, " overdue domains of group ", group.Name, " at ", qps, " per second")
+			spread += n
+		}
+	}
+	return spread
+}
+
+// ResumeRate returns the rate at which the overdue domains of the group are
+// spread on resume: the effective rate limit of the group, or
+// LoadDomainsFileInitialQueryLimit if neither the group nor QueryRateLimit
+// limit it
+func ResumeRate(group *Group) float64 {
+	limit := min(group.limiter.Limit(), queryLimiter.Limit())
+	if limit == rate.Inf {
+		return float64(resolverConfiguration.LoadDomainsFileInitialQueryLimit)
+	}
+	return float64(limit)
+}
+
+// Drain waits until the in-flight refreshes (of the target if not nil) are
+// finished or ctx is done. Dispatching should be paused before.
+func Drain(ctx context.Context, target *Target) DrainResult {
+	inFlight := scheduler.InFlight
+	if target != nil {
+		inFlight = target.InFlight
+	}
+	start := time.Now()
+	result := DrainResult{InFlight: inFlight()}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for result.Remaining = inFlight(); result.Remaining > 0; result.Remaining = inFlight() {
+		select {
+		case <-ctx.Done():
+			result.WaitedMs = time.Since(start).Milliseconds()
+			return result
+		case <-ticker.C:
+		}
+	}
+	result.Drained = true
+	result.WaitedMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// SpreadOverdue makes the overdue domains of the group due one after another
+// at qps (keeping their order). Returns the number of overdue domains.
+func (dh *DomainHeap) SpreadOverdue(group *Group, qps float64) int {
+	if qps <= 0 {
+		return 0
+	}
+	var overdue []*Domain
+	HeapDo(dh, func(h *DomainHeap) {
+		now := time.Now().UnixMilli()
+		for _, d := range *h {
+			if d.Refresh_at <= now && d.GroupName() == group.Name {
+				overdue = append(overdue, d)
+			}
+		}
+		sort.SliceStable(overdue, func(i, j int) bool { return overdue[i].Refresh_at < overdue[j].Refresh_at })
+		for i, d := range overdue {
+			d.Refresh_at = now + int64(float64(i)*1000/qps)
+		}
+		heap.Init(h)
+	})
+	return len(overdue)
+}
+
+// ActiveTargets returns the targets which are not paused
+func ActiveTargets(targets []*Target) []*Target {
+	return slices.DeleteFunc(slices.Clone(targets), (*Target).Paused)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestPauseStateIsPersisted(t *testing.T) {
+	resetQueue(t)
+	config := resolverConfiguration
+	previous := config.StateFile
+	config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	t.Cleanup(func() { config.StateFile = previous })
+	target := targets[0]
+	t.Cleanup(func() {
+		scheduler.SetPaused(false)
+		target.SetPaused(false)
+	})
+
+	Pause(nil)
+	Pause(target)
+	if state := CurrentSchedulerState(); !state.Paused || len(state.PausedTargets) != 1 || state.PausedTargets[0] != target.Id {
+		t.Fatalf("state = %+v, want the scheduler and %s paused", state, target.Id)
+	}
+	if len(ActiveTargets(targets)) != len(targets)-1 {
+		t.Errorf("the paused target is active")
+	}
+
+	scheduler.SetPaused(false)
+	target.SetPaused(false)
+	if err := LoadSchedulerState(config.StateFile); err != nil {
+		t.Fatal(err)
+	}
+	if !scheduler.Paused() || !target.Paused() {
+		t.Error("the pause state was not restored from the state file")
+	}
+
+	Resume(nil)
+	Resume(target)
+	if err := LoadSchedulerState(config.StateFile); err != nil {
+		t.Fatal(err)
+	}
+	if scheduler.Paused() || target.Paused() {
+		t.Error("the resumed state was not persisted")
+	}
+	if err := LoadSchedulerState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("a missing state file is an error: %v", err)
+	}
+}
+
+func TestSpreadOverdue(t *testing.T) {
+	resetQueue(t)
+	now := time.Now().UnixMilli()
+	for i, name := range []string{"c.example", "a.example", "b.example"} {
+		d := Domain{Record_name: name, Record_type: "A", Refresh_at: now - int64(3-i)*1000}
+		if err := dh.AddDomain(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dh.AddDomain(Domain{Record_name: "later.example", Record_type: "A", Refresh_at: now + 60000}); err != nil {
+		t.Fatal(err)
+	}
+
+	if spread := dh.SpreadOverdue(groups[DefaultGroupName], 10); spread != 3 {
+		t.Fatalf("spread %d domains, want the 3 overdue ones", spread)
+	}
+	domains := dh.QueuedDomains()
+	due := make(map[string]int64)
+	for _, d := range domains {
+		due[d.Record_name] = d.Refresh_at
+	}
+	// the order is kept, one domain every 100ms
+	if due["a.example"]-due["c.example"] != 100 || due["b.example"]-due["a.example"] != 100 || due["later.example"] != now+60000 {
+		t.Errorf("due times = %v", due)
+	}
+}
+
+func TestResumeOfATargetSpreadsTheGroupsUsingIt(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "web"})
+	withGroupLimit(t, 10)
+	// the web group sends its refreshes to another target
+	groups["web"].Targets = []*Target{{Id: "other"}}
+	now := time.Now().UnixMilli()
+	for _, d := range []Domain{
+		{Record_name: "a.example", Record_type: "A", Refresh_at: now - 2000},
+		{Record_name: "b.example", Record_type: "A", Refresh_at: now - 1000},
+		{Record_name: "a.example", Record_type: "A", Group: "web", Refresh_at: now - 2000},
+	} {
+		HeapPush(dh, d)
+	}
+
+	if spread := Resume(targets[0]); spread != 2 {
+		t.Errorf("spread %d domains, want the 2 of the default group", spread)
+	}
+	for _, d := range dh.QueuedDomains() {
+		if d.Group == "web" && d.Refresh_at != now-2000 {
+			t.Errorf("%s of a group not using the resumed target was spread", d.Key())
+		}
+		// the second one at the rate limit of the default group
+		if d.Key() == "default b.example IN A" && d.Refresh_at < now+100 {
+			t.Errorf("%s due in %dms, want 100ms after the first one", d.Key(), d.MillisUntilDue())
+		}
+	}
+}
+
+func TestResumeRate(t *testing.T) {
+	group := withGroupLimit(t, 20)
+	if qps := ResumeRate(group); qps != 20 {
+		t.Errorf("ResumeRate = %v, want the rate limit of the group", qps)
+	}
+	group.limiter.SetLimit(rate.Inf)
+	if qps := ResumeRate(group); qps != float64(resolverConfiguration.LoadDomainsFileInitialQueryLimit) {
+		t.Errorf("ResumeRate of an unlimited group = %v, want LoadDomainsFileInitialQueryLimit", qps)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	if result := Drain(context.Background(), nil); !result.Drained || result.InFlight != 0 {
+		t.Errorf("drain without in-flight refreshes = %+v", result)
+	}
+	scheduler.inFlight.Add(1)
+	defer scheduler.inFlight.Add(-1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if result := Drain(ctx, nil); result.Drained || result.Remaining != 1 {
+		t.Errorf("drain with an in-flight refresh = %+v, want it not drained", result)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const probeWorkers = 16
+
+// ProbeGroupResult - the cached fraction of the probed domains of a group
+type ProbeGroupResult struct {
+	Probed         int     `json:"probed" example:"100"`
+	Cached         int     `json:"cached" example:"93"`
+	Errors         int     `json:"errors" example:"0"`
+	CachedFraction float64 `json:"cached_fraction" example:"0.93"`
+}
+
+// ProbeResult - the outcome of a cache-snooping readiness probe of a target
+type ProbeResult struct {
+	Target    string                       `json:"target" example:"default"`
+	Threshold float64                      `json:"threshold" example:"0.9"`
+	Pass      bool                         `json:"pass" example:"true"`
+	Total     ProbeGroupResult             `json:"total"`
+	Groups    map[string]*ProbeGroupResult `json:"groups"`
+}
+
+func (r *ProbeGroupResult) add(cached bool, err error) {
+	r.Probed++
+	if err != nil {
+		r.Errors++
+	} else if cached {
+		r.Cached++
+	}
+	r.CachedFraction = float64(r.Cached) / float64(r.Probed)
+}
+
+// FindTarget returns the target with the given id
+func FindTarget(id string) (*Target, error) {
+	for _, target := range targets {
+		if target.Id == id {
+			return target, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown target %s", id)
+}
+
+// SampleDomains returns up to size randomly chosen domains (all if size is 0)
+func SampleDomains(domains []Domain, size int) []Domain {
+	if size <= 0 || size >= len(domains) {
+		return domains
+	}
+	rand.Shuffle(len(domains), func(i, j int) { domains[i], domains[j] = domains[j], domains[i] })
+	return domains[:size]
+}
+
+// ProbeTarget sends non-recursive queries for domains to target and reports
+// the fraction which is already cached. Queries respect the global rate limiter.
+func ProbeTarget(ctx context.Context, target *Target, domains []Domain, threshold float64) (*ProbeResult, error) {
+	result := &ProbeResult{
+		Target:    target.Id,
+		Threshold: threshold,
+		Groups:    make(map[string]*ProbeGroupResult),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan Domain)
+	for i := 0; i < probeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range work {
+				if err := WaitForQuerySlot(ctx); err != nil {
+					continue
+				}
+				cached, err := d.ProbeCache(target)
+				log.Trace("probe ", d.ToString(), " on target ", target.Id, " cached=", cached, " err=", err)
+
+				mu.Lock()
+				group, ok := result.Groups[d.GroupName()]
+				if !ok {
+					group = &ProbeGroupResult{}
+					result.Groups[d.GroupName()] = group
+				}
+				group.add(cached, err)
+				result.Total.add(cached, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, d := range domains {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- d
+	}
+	close(work)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result.Pass = result.Total.Probed > 0 && result.Total.CachedFraction >= threshold
+	return result, nil
+}
+
+// RunProbeCommand probes the target with the domains of the DomainsFile and
+// prints the result. Returns the exit code: 0 if the probe passed, 1 otherwise.
+func RunProbeCommand(args []string) int {
+	if len(args) != 1 {
+		log.Error("usage: syringe probe <target id> (use --ProbeSampleSize and --ProbeThreshold to adjust the probe)")
+		return 2
+	}
+	target, err := FindTarget(args[0])
+	if err != nil {
+		log.Error(err)
+		return 2
+	}
+
+	entries, err := ReadDomainsFile(resolverConfiguration.DomainsFile)
+	if err != nil {
+		log.Error(err)
+		return 2
+	}
+	var domains []Domain
+	for _, entry := range entries {
+		if IsSubscriptionEntry(entry) {
+			continue
+		}
+		expanded, err := DomainListEntryToDomains(entry)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, d := range expanded {
+			if d.Validate() {
+				domains = append(domains, d)
+			}
+		}
+	}
+
+	result, err := ProbeTarget(context.Background(), target, SampleDomains(domains, int(resolverConfiguration.ProbeSampleSize)), resolverConfiguration.ProbeThreshold)
+	if err != nil {
+		log.Error(err)
+		return 2
+	}
+	output, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Fprintln(os.Stdout, string(output))
+	if !result.Pass {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	dns "github.com/miekg/dns"
+)
+
+func TestProbeTargetReportsTheCachedFraction(t *testing.T) {
+	address := startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name != "cold.example." {
+			m.Answer = append(m.Answer, mustRR(t, r.Question[0].Name+" 300 IN A 192.0.2.1"))
+		}
+		w.WriteMsg(m)
+	})
+	target := newTestTarget(t, TargetConfiguration{Address: address})
+	domains := []Domain{
+		{Record_name: "a.example", Record_type: "A"},
+		{Record_name: "b.example", Record_type: "A"},
+		{Record_name: "c.example", Record_type: "A", Group: "critical"},
+		{Record_name: "cold.example", Record_type: "A", Group: "critical"},
+	}
+
+	result, err := ProbeTarget(context.Background(), target, domains, 0.7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total.Probed != 4 || result.Total.Cached != 3 || result.Total.CachedFraction != 0.75 || !result.Pass {
+		t.Errorf("total = %+v pass=%v, want 3 of 4 cached and passing", result.Total, result.Pass)
+	}
+	if critical := result.Groups["critical"]; critical == nil || critical.Probed != 2 || critical.Cached != 1 {
+		t.Errorf("critical group = %+v, want 1 of 2 cached", critical)
+	}
+
+	result, err = ProbeTarget(context.Background(), target, domains, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pass {
+		t.Error("the probe passed below the threshold")
+	}
+}
+
+func TestProbeTargetWithoutDomainsFails(t *testing.T) {
+	target := newTestTarget(t, TargetConfiguration{Address: "127.0.0.1:1"})
+	result, err := ProbeTarget(context.Background(), target, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pass {
+		t.Error("a probe of no domains passed")
+	}
+}
+
+func TestSampleDomains(t *testing.T) {
+	domains := []Domain{{Record_name: "a.example"}, {Record_name: "b.example"}, {Record_name: "c.example"}}
+	if sample := SampleDomains(domains, 2); len(sample) != 2 {
+		t.Errorf("sample of 2 has %d domains", len(sample))
+	}
+	if sample := SampleDomains(domains, 0); len(sample) != 3 {
+		t.Errorf("sample without size has %d domains, want all", len(sample))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// queryLimiter - the global rate limiter shared by the scheduler and probes
+var queryLimiter *rate.Limiter
+
+// NewQueryLimiter returns a limiter allowing qps queries per second with a
+// burst of 100ms worth of queries. qps=0 disables limiting.
+func NewQueryLimiter(qps uint) *rate.Limiter {
+	if qps == 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(qps), int(qps/10)+1)
+}
+
+// SetQueryLimit changes the limiter to allow qps queries per second, 0 is unlimited
+func SetQueryLimit(limiter *rate.Limiter, qps uint) {
+	if qps == 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetLimit(rate.Limit(qps))
+	limiter.SetBurst(int(qps/10) + 1)
+}
+
+// WaitForQuerySlot blocks until the global rate limiter allows another query
+func WaitForQuerySlot(ctx context.Context) error {
+	return queryLimiter.Wait(ctx)
+}
+
+// WaitForGroupQuerySlot blocks until the rate limiter of the group and the
+// global rate limiter allow another query
+func WaitForGroupQuerySlot(ctx context.Context, group *Group) error {
+	if err := group.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return WaitForQuerySlot(ctx)
+}
+
+// TokenWait returns how long until the limiter allows another event
+func TokenWait(limiter *rate.Limiter) time.Duration {
+	tokens := limiter.Tokens()
+	if limiter.Limit() == rate.Inf || tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / float64(limiter.Limit()) * float64(time.Second))
+}
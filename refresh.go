@@ -0,0 +1,169 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRefreshJobs - finished jobs beyond this number are forgotten, oldest first
+const maxRefreshJobs = 100
+
+var forcedRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "syringe",
+	Name:      "forced_refreshes_total",
+	Help:      "The total number of domains moved to the front of the queue by a refresh request",
+})
+
+func init() {
+	prometheus.Register(forcedRefreshes)
+}
+
+// RefreshSelector - selects queued domains by name. Names, Suffix and Regex
+// are alternatives, Group restricts the selection to a group (all domains of
+// the group if nothing else is set).
+type RefreshSelector struct {
+	Names  []string `json:"names,omitempty" example:"google.com"`
+	Suffix string   `json:"suffix,omitempty" example:"example.com"`
+	Regex  string   `json:"regex,omitempty" example:"^www[0-9]*\\."`
+	Group  string   `json:"group,omitempty" example:"critical"`
+}
+
+// Matcher returns a function reporting whether a domain is selected
+func (selector RefreshSelector) Matcher() (func(d *Domain) bool, error) {
+	if len(selector.Names) == 0 && selector.Suffix == "" && selector.Regex == "" && selector.Group == "" {
+		return nil, fmt.Errorf("no names, suffix, regex or group given")
+	}
+	if selector.Group != "" {
+		if _, err := FindGroup(selector.Group); err != nil {
+			return nil, err
+		}
+	}
+	var re *regexp.Regexp
+	if selector.Regex != "" {
+		var err error
+		if re, err = regexp.Compile(selector.Regex); err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	names := make([]string, len(selector.Names))
+	for i, name := range selector.Names {
+		names[i] = LookupDomainName(name)
+	}
+	suffix := LookupDomainName(strings.TrimPrefix(selector.Suffix, "."))
+	byName := len(names) > 0 || suffix != "" || re != nil
+
+	return func(d *Domain) bool {
+		if selector.Group != "" && d.GroupName() != selector.Group {
+			return false
+		}
+		if !byName {
+			return true
+		}
+		name := strings.ToLower(strings.TrimSuffix(d.Record_name, "."))
+		return slices.Contains(names, name) ||
+			(suffix != "" && (name == suffix || strings.HasSuffix(name, "."+suffix))) ||
+			(re != nil && re.MatchString(name))
+	}, nil
+}
+
+// RefreshJob - the progress of a refresh request
+type RefreshJob struct {
+	Id       string `json:"id" example:"refresh-1"`
+	Created  int64  `json:"created" example:"1700000000000"`
+	Total    int    `json:"total" example:"42"`
+	Done     int    `json:"done" example:"40"`
+	Finished int64  `json:"finished,omitempty" example:"1700000002000"`
+}
+
+// RefreshJobs - the jobs of the refresh requests, completed by the scheduler
+type RefreshJobs struct {
+	mu   sync.Mutex
+	seq  int
+	jobs []*RefreshJob
+}
+
+var refreshJobs = &RefreshJobs{}
+
+// ForceRefresh makes the matching queued domains due now and moves them to
+// the front of the heap. The scheduler dispatches them within the rate limits.
+// The domains are tagged with the job, only their refreshes complete it.
+func (dh *DomainHeap) ForceRefresh(match func(d *Domain) bool) RefreshJob {
+	refreshJobs.mu.Lock()
+	defer refreshJobs.mu.Unlock()
+	refreshJobs.seq++
+	job := &RefreshJob{
+		Id:      fmt.Sprintf("refresh-%d", refreshJobs.seq),
+		Created: time.Now().UnixMilli(),
+	}
+	HeapDo(dh, func(h *DomainHeap) {
+		now := time.Now().UnixMilli()
+		for _, d := range *h {
+			if !match(d) {
+				continue
+			}
+			d.refresh_jobs = append(slices.Clip(d.refresh_jobs), job.Id)
+			job.Total++
+			if d.Refresh_at > now {
+				d.Refresh_at = now
+				heap.Fix(h, d.index)
+			}
+		}
+	})
+	forcedRefreshes.Add(float64(job.Total))
+	log.Info("refresh job ", job.Id, " moved ", job.Total, " domains to the front of the queue")
+	if job.Total == 0 {
+		job.Finished = job.Created
+	}
+
+	refreshJobs.jobs = append(refreshJobs.jobs, job)
+	overflow := len(refreshJobs.jobs) - maxRefreshJobs
+	refreshJobs.jobs = slices.DeleteFunc(refreshJobs.jobs, func(job *RefreshJob) bool {
+		if overflow > 0 && job.Finished != 0 {
+			overflow--
+			return true
+		}
+		return false
+	})
+	return *job
+}
+
+// Complete counts the refresh (or removal) of the domain towards the jobs it
+// is tagged with and clears its tags
+func (jobs *RefreshJobs) Complete(domain *Domain) {
+	if len(domain.refresh_jobs) == 0 {
+		return
+	}
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	for _, job := range jobs.jobs {
+		if !slices.Contains(domain.refresh_jobs, job.Id) {
+			continue
+		}
+		job.Done++
+		if job.Done == job.Total {
+			job.Finished = time.Now().UnixMilli()
+			log.Info("refresh job ", job.Id, " finished after ", job.Finished-job.Created, "ms")
+		}
+	}
+	domain.refresh_jobs = nil
+}
+
+// Get returns the job with the given id
+func (jobs *RefreshJobs) Get(id string) (RefreshJob, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	for _, job := range jobs.jobs {
+		if job.Id == id {
+			return *job, true
+		}
+	}
+	return RefreshJob{}, false
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshSelector(t *testing.T) {
+	withGroups(t, GroupConfiguration{Name: "critical"})
+	domains := []*Domain{
+		{Record_name: "example.com", Record_type: "A"},
+		{Record_name: "www.example.com", Record_type: "A", Group: "critical"},
+		{Record_name: "www1.example.net", Record_type: "A"},
+	}
+	tests := []struct {
+		selector RefreshSelector
+		want     []bool
+	}{
+		{RefreshSelector{Names: []string{"Example.com."}}, []bool{true, false, false}},
+		{RefreshSelector{Suffix: ".example.com"}, []bool{true, true, false}},
+		{RefreshSelector{Regex: `^www[0-9]*\.`}, []bool{false, true, true}},
+		{RefreshSelector{Group: "critical"}, []bool{false, true, false}},
+		{RefreshSelector{Suffix: "example.com", Group: DefaultGroupName}, []bool{true, false, false}},
+	}
+	for _, test := range tests {
+		match, err := test.selector.Matcher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, d := range domains {
+			if match(d) != test.want[i] {
+				t.Errorf("%+v matches %s: %v, want %v", test.selector, d.ToString(), match(d), test.want[i])
+			}
+		}
+	}
+	for _, selector := range []RefreshSelector{{}, {Regex: "("}, {Group: "unknown"}} {
+		if _, err := selector.Matcher(); err == nil {
+			t.Errorf("selector %+v was accepted", selector)
+		}
+	}
+}
+
+func TestRefreshJobCountsOnlyTaggedRefreshes(t *testing.T) {
+	resetQueue(t)
+	later := time.Now().Add(time.Hour).UnixMilli()
+	for _, name := range []string{"a.example", "b.example", "c.example"} {
+		if err := dh.AddDomain(Domain{Record_name: name, Record_type: "A", Refresh_at: later}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job := dh.ForceRefresh(func(d *Domain) bool { return d.Record_name != "c.example" })
+	if job.Total != 2 {
+		t.Fatalf("job total = %d, want 2", job.Total)
+	}
+
+	// a refresh of the same domain which was not dispatched for the job
+	refreshJobs.Complete(&Domain{Record_name: "a.example", Record_type: "A"})
+	if current, _ := refreshJobs.Get(job.Id); current.Done != 0 {
+		t.Fatalf("job done = %d after an unrelated refresh, want 0", current.Done)
+	}
+
+	fillWithin(t, 5*time.Second)
+	ready := scheduler.ready[DefaultGroupName]
+	if len(ready) != 2 {
+		t.Fatalf("%d ready domains, want the 2 forced ones", len(ready))
+	}
+	for i := range ready {
+		refreshJobs.Complete(&ready[i])
+		// completing clears the tags, the next refresh is unrelated
+		refreshJobs.Complete(&ready[i])
+	}
+	current, _ := refreshJobs.Get(job.Id)
+	if current.Done != 2 || current.Finished == 0 {
+		t.Errorf("job = %+v, want finished with 2 done", current)
+	}
+}
+
+func TestRefreshJobCountsRemovedDomains(t *testing.T) {
+	resetQueue(t)
+	if err := dh.AddDomain(Domain{Record_name: "a.example", Record_type: "A", Refresh_at: time.Now().Add(time.Hour).UnixMilli()}); err != nil {
+		t.Fatal(err)
+	}
+	job := dh.ForceRefresh(func(d *Domain) bool { return true })
+	dh.RemoveDomains(func(d *Domain) bool { return true })
+	if current, _ := refreshJobs.Get(job.Id); current.Finished == 0 {
+		t.Errorf("job = %+v, want finished after its domain was removed", current)
+	}
+}
+
+func TestRefreshJobsForgetTheOldestFinishedJobs(t *testing.T) {
+	resetQueue(t)
+	if err := dh.AddDomain(Domain{Record_name: "a.example", Record_type: "A", Refresh_at: time.Now().Add(time.Hour).UnixMilli()}); err != nil {
+		t.Fatal(err)
+	}
+	unfinished := dh.ForceRefresh(func(d *Domain) bool { return true })
+	var first RefreshJob
+	for i := 0; i <= maxRefreshJobs; i++ {
+		job := dh.ForceRefresh(func(d *Domain) bool { return false })
+		if i == 0 {
+			first = job
+		}
+	}
+	if _, ok := refreshJobs.Get(unfinished.Id); !ok {
+		t.Error("an unfinished job was forgotten")
+	}
+	if _, ok := refreshJobs.Get(first.Id); ok {
+		t.Error("the oldest finished job was kept")
+	}
+}
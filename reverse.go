@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strings"
+
+	dns "github.com/miekg/dns"
+)
+
+// Reverse zones are delegated on octet (in-addr.arpa) and nibble (ip6.arpa) boundaries
+const (
+	reverseZoneBitsV4 = 8
+	reverseZoneBitsV6 = 4
+)
+
+// ReversePrefixDomains expands an IP prefix to reverse domains. PTR enrolls the
+// in-addr.arpa/ip6.arpa name of every address of the prefix, NS and SOA the
+// reverse zones the prefix is delegated in, e.g. the four /24 zones of a /22
+// or the /24 zone containing a /30. IPv4-mapped IPv6 prefixes expand like
+// their IPv4 prefix. Expansions of more than ReverseMaxNames names (of all
+// types) are rejected.
+func ReversePrefixDomains(prefix netip.Prefix, types []string) ([]Domain, error) {
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	prefix = prefix.Masked()
+	zoneBits := reverseZoneBitsV4
+	if prefix.Addr().Is6() {
+		zoneBits = reverseZoneBitsV6
+	}
+	// the zones start at the next boundary at or below the prefix, '10.in-addr.arpa'
+	// is the largest; the last label is the address itself, not a zone
+	boundary := max(zoneBits, min((prefix.Bits()+zoneBits-1)/zoneBits*zoneBits, prefix.Addr().BitLen()-zoneBits))
+
+	limit := uint64(resolverConfiguration.ReverseMaxNames)
+	var domains []Domain
+	for _, rr_type := range types {
+		var names []string
+		var ok bool
+		switch strings.ToUpper(rr_type) {
+		case "PTR":
+			names, ok = reverseNames(prefix, prefix.Addr().BitLen(), limit-uint64(len(domains)), func(addr netip.Addr) string {
+				name, _ := dns.ReverseAddr(addr.String())
+				return name
+			})
+		case "NS", "SOA":
+			names, ok = reverseNames(prefix, boundary, limit-uint64(len(domains)), func(addr netip.Addr) string {
+				return reverseZone(addr, boundary/zoneBits)
+			})
+		default:
+			return nil, fmt.Errorf("unsupported type %s for prefix %s (PTR, NS, SOA)", rr_type, prefix)
+		}
+		if !ok {
+			return nil, fmt.Errorf("prefix %s expands to more than %d names, use NS,SOA to warm its delegation only", prefix, limit)
+		}
+		for _, name := range names {
+			domains = append(domains, Domain{Record_name: strings.TrimSuffix(name, "."), Record_type: rr_type})
+		}
+	}
+	return domains, nil
+}
+
+// reverseNames returns the name of every block of bits length in the prefix,
+// a prefix within a block yields the name of the block. Returns false if there
+// are more than limit names.
+func reverseNames(prefix netip.Prefix, bits int, limit uint64, name func(netip.Addr) string) ([]string, bool) {
+	width := bits - prefix.Bits()
+	if width < 0 {
+		width = 0
+		prefix = netip.PrefixFrom(prefix.Addr(), bits).Masked()
+	}
+	if width >= 64 || uint64(1)<<width > limit {
+		return nil, false
+	}
+	base := new(big.Int).SetBytes(prefix.Addr().AsSlice())
+	step := new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-bits))
+	names := make([]string, 0, 1<<width)
+	raw := make([]byte, prefix.Addr().BitLen()/8)
+	for i := 0; i < 1<<width; i++ {
+		addr, _ := netip.AddrFromSlice(base.FillBytes(raw))
+		names = append(names, name(addr))
+		base.Add(base, step)
+	}
+	return names, true
+}
+
+// reverseZone returns the reverse zone of the first labels octets or nibbles of an address
+func reverseZone(addr netip.Addr, labels int) string {
+	raw := addr.AsSlice()
+	var parts []string
+	if addr.Is4() {
+		for i := labels - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprint(raw[i]))
+		}
+		return strings.Join(append(parts, "in-addr.arpa"), ".")
+	}
+	for i := labels - 1; i >= 0; i-- {
+		nibble := raw[i/2] >> 4
+		if i%2 == 1 {
+			nibble = raw[i/2] & 0x0f
+		}
+		parts = append(parts, fmt.Sprintf("%x", nibble))
+	}
+	return strings.Join(append(parts, "ip6.arpa"), ".")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// withReverseMaxNames sets ReverseMaxNames for the test
+func withReverseMaxNames(t *testing.T, limit uint) {
+	previous := resolverConfiguration.ReverseMaxNames
+	resolverConfiguration.ReverseMaxNames = limit
+	t.Cleanup(func() { resolverConfiguration.ReverseMaxNames = previous })
+}
+
+func reverseDomainNames(t *testing.T, prefix string, types ...string) []string {
+	t.Helper()
+	domains, err := ReversePrefixDomains(netip.MustParsePrefix(prefix), types)
+	if err != nil {
+		t.Fatalf("ReversePrefixDomains(%s, %v) = %v", prefix, types, err)
+	}
+	names := make([]string, 0, len(domains))
+	for _, d := range domains {
+		names = append(names, d.Record_name+" "+d.Record_type)
+	}
+	return names
+}
+
+func TestReversePrefixDomains(t *testing.T) {
+	withReverseMaxNames(t, 65536)
+	tests := []struct {
+		prefix string
+		types  []string
+		want   []string
+	}{
+		{"192.0.2.0/31", []string{"PTR"}, []string{"0.2.0.192.in-addr.arpa PTR", "1.2.0.192.in-addr.arpa PTR"}},
+		{"192.0.2.7/32", []string{"PTR"}, []string{"7.2.0.192.in-addr.arpa PTR"}},
+		{"198.51.100.0/22", []string{"NS"}, []string{"100.51.198.in-addr.arpa NS", "101.51.198.in-addr.arpa NS", "102.51.198.in-addr.arpa NS", "103.51.198.in-addr.arpa NS"}},
+		{"192.0.2.0/24", []string{"NS", "SOA"}, []string{"2.0.192.in-addr.arpa NS", "2.0.192.in-addr.arpa SOA"}},
+		// within an octet the containing zone is the delegation
+		{"192.0.2.0/30", []string{"NS"}, []string{"2.0.192.in-addr.arpa NS"}},
+		{"192.0.2.77/32", []string{"SOA"}, []string{"2.0.192.in-addr.arpa SOA"}},
+		{"10.0.0.0/8", []string{"NS"}, []string{"10.in-addr.arpa NS"}},
+		// the host bits are ignored
+		{"192.0.2.77/31", []string{"PTR"}, []string{"76.2.0.192.in-addr.arpa PTR", "77.2.0.192.in-addr.arpa PTR"}},
+		{"2001:db8::/32", []string{"NS"}, []string{"8.b.d.0.1.0.0.2.ip6.arpa NS"}},
+		{"2001:db8::/31", []string{"NS"}, []string{"8.b.d.0.1.0.0.2.ip6.arpa NS", "9.b.d.0.1.0.0.2.ip6.arpa NS"}},
+		{"2001:db8::/34", []string{"NS"}, []string{"0.8.b.d.0.1.0.0.2.ip6.arpa NS", "1.8.b.d.0.1.0.0.2.ip6.arpa NS", "2.8.b.d.0.1.0.0.2.ip6.arpa NS", "3.8.b.d.0.1.0.0.2.ip6.arpa NS"}},
+		{"2001:db8::1/128", []string{"SOA"}, []string{"0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa SOA"}},
+		{"2001:db8::/127", []string{"PTR"}, []string{
+			"0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa PTR",
+			"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa PTR",
+		}},
+		// IPv4-mapped prefixes are IPv4 prefixes
+		{"::ffff:192.0.2.0/126", []string{"NS"}, []string{"2.0.192.in-addr.arpa NS"}},
+		{"::ffff:192.0.2.0/127", []string{"PTR"}, []string{"0.2.0.192.in-addr.arpa PTR", "1.2.0.192.in-addr.arpa PTR"}},
+	}
+	for _, test := range tests {
+		if names := reverseDomainNames(t, test.prefix, test.types...); !slices.Equal(names, test.want) {
+			t.Errorf("ReversePrefixDomains(%s, %v) = %v, want %v", test.prefix, test.types, names, test.want)
+		}
+	}
+}
+
+func TestReversePrefixDomainsLimit(t *testing.T) {
+	withReverseMaxNames(t, 256)
+	if names := reverseDomainNames(t, "192.0.2.0/24", "PTR"); len(names) != 256 {
+		t.Errorf("%d PTR names for a /24, want 256", len(names))
+	}
+	for _, test := range []struct {
+		prefix string
+		types  []string
+	}{
+		{"192.0.2.0/23", []string{"PTR"}},
+		// the limit applies to the names of all types
+		{"192.0.2.0/24", []string{"PTR", "NS"}},
+		{"2001:db8::/64", []string{"PTR"}},
+		{"192.0.2.0/24", []string{"A"}},
+	} {
+		if _, err := ReversePrefixDomains(netip.MustParsePrefix(test.prefix), test.types); err == nil {
+			t.Errorf("ReversePrefixDomains(%s, %v) was accepted", test.prefix, test.types)
+		}
+	}
+}
+
+func TestDomainListEntryWithPrefix(t *testing.T) {
+	withReverseMaxNames(t, 4)
+	domains, err := DomainListEntryToDomains("192.0.2.0/31 PTR ecs=198.51.100.0/24,2001:db8::/56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 4 || domains[0].Record_name != "0.2.0.192.in-addr.arpa" || domains[0].Client_subnet != "198.51.100.0/24" {
+		t.Errorf("DomainListEntryToDomains = %v", domains)
+	}
+	// every name is queued once per client subnet
+	if _, err := DomainListEntryToDomains("192.0.2.0/30 PTR ecs=198.51.100.0/24,2001:db8::/56"); err == nil {
+		t.Error("an entry expanding to 8 domains was accepted")
+	}
+}
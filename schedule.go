@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScheduleRuleConfiguration - a recurring window in which the rate limit of
+// groups is changed or the groups are paused
+type ScheduleRuleConfiguration struct {
+	Name string `yaml:"Name"`
+	// Cron - start of the window, standard cron expression (minute hour day-of-month month day-of-week) or descriptor like @daily
+	Cron            string `yaml:"Cron"`
+	DurationSeconds uint   `yaml:"DurationSeconds"`
+	// Groups - the groups the rule applies to, defaults to all groups
+	Groups []string `yaml:"Groups"`
+	// RateLimit - refreshes per second of each group while the window is open, 0 is unlimited
+	RateLimit uint `yaml:"RateLimit"`
+	// Pause - do not refresh the groups while the window is open
+	Pause bool `yaml:"Pause"`
+}
+
+const (
+	ScheduleWindowRule  = "rule"
+	ScheduleWindowBoost = "boost"
+)
+
+// ScheduleWindow - an open window of a schedule rule or boost. If several
+// windows apply to a group, the most recently opened one wins.
+type ScheduleWindow struct {
+	Id        string   `json:"id" example:"maintenance"`
+	Kind      string   `json:"kind" example:"rule"`
+	Groups    []string `json:"groups,omitempty" example:"critical"`
+	RateLimit uint     `json:"rate_limit" example:"1000"`
+	Pause     bool     `json:"pause" example:"false"`
+	Start     int64    `json:"start" example:"1700000000"`
+	End       int64    `json:"end" example:"1700003600"`
+}
+
+// ScheduleRuleStatus - a configured schedule rule and when it opens next
+type ScheduleRuleStatus struct {
+	Name            string   `json:"name" example:"maintenance"`
+	Cron            string   `json:"cron" example:"0 2 * * 6"`
+	DurationSeconds uint     `json:"duration_seconds" example:"3600"`
+	Groups          []string `json:"groups,omitempty" example:"critical"`
+	RateLimit       uint     `json:"rate_limit" example:"1000"`
+	Pause           bool     `json:"pause" example:"false"`
+	Next            int64    `json:"next" example:"1700000000"`
+}
+
+// GroupScheduleStatus - the effective rate limit of a group
+type GroupScheduleStatus struct {
+	Name string `json:"name" example:"critical"`
+	// RateLimit - the effective refreshes per second, 0 is unlimited
+	RateLimit uint `json:"rate_limit" example:"1000"`
+	// BaseRateLimit - the configured refreshes per second outside of windows
+	BaseRateLimit uint   `json:"base_rate_limit" example:"10"`
+	Paused        bool   `json:"paused" example:"false"`
+	Window        string `json:"window,omitempty" example:"maintenance"`
+}
+
+var (
+	groupRateLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "group_rate_limit",
+		Help:      "The effective refreshes per second of a group (0 is unlimited)",
+	},
+		[]string{"group"},
+	)
+	groupPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "syringe",
+		Name:      "group_paused",
+		Help:      "1 if the group is paused by a schedule window",
+	},
+		[]string{"group"},
+	)
+)
+
+func init() {
+	prometheus.Register(groupRateLimit)
+	prometheus.Register(groupPaused)
+}
+
+type scheduleRule struct {
+	config   ScheduleRuleConfiguration
+	schedule cron.Schedule
+	next     time.Time
+}
+
+// Schedule - opens and closes the windows of the schedule rules and boosts
+// and applies them to the groups
+type Schedule struct {
+	mu       sync.Mutex
+	rules    []*scheduleRule
+	windows  []ScheduleWindow
+	boostSeq int
+	status   map[string]GroupScheduleStatus
+}
+
+// NewSchedule validates the rules and opens the windows which are already open at now
+func NewSchedule(configs []ScheduleRuleConfiguration, now time.Time) (*Schedule, error) {
+	s := &Schedule{status: make(map[string]GroupScheduleStatus)}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("schedule rule without name")
+		}
+		if slices.ContainsFunc(s.rules, func(r *scheduleRule) bool { return r.config.Name == config.Name }) {
+			return nil, fmt.Errorf("duplicate schedule rule %s", config.Name)
+		}
+		if config.DurationSeconds == 0 {
+			return nil, fmt.Errorf("schedule rule %s: DurationSeconds must be > 0", config.Name)
+		}
+		if err := validateGroupNames(config.Groups); err != nil {
+			return nil, fmt.Errorf("schedule rule %s: %w", config.Name, err)
+		}
+		schedule, err := cron.ParseStandard(config.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule rule %s: %w", config.Name, err)
+		}
+
+		rule := &scheduleRule{config: config, schedule: schedule}
+		duration := time.Duration(config.DurationSeconds) * time.Second
+		// find the last start within the duration, its window is still open
+		var last time.Time
+		for t := schedule.Next(now.Add(-duration)); !t.After(now); t = schedule.Next(t) {
+			last = t
+		}
+		if !last.IsZero() {
+			s.open(rule, last)
+		}
+		rule.next = schedule.Next(now)
+		s.rules = append(s.rules, rule)
+	}
+	s.apply()
+	return s, nil
+}
+
+func validateGroupNames(names []string) error {
+	for _, name := range names {
+		if _, err := FindGroup(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schedule) open(rule *scheduleRule, start time.Time) {
+	log.Info("opening schedule window ", rule.config.Name, " until ", start.Add(time.Duration(rule.config.DurationSeconds)*time.Second))
+	s.windows = append(s.windows, ScheduleWindow{
+		Id:        rule.config.Name,
+		Kind:      ScheduleWindowRule,
+		Groups:    rule.config.Groups,
+		RateLimit: rule.config.RateLimit,
+		Pause:     rule.config.Pause,
+		Start:     start.Unix(),
+		End:       start.Add(time.Duration(rule.config.DurationSeconds) * time.Second).Unix(),
+	})
+}
+
+// Run opens and closes windows every second
+func (s *Schedule) Run() {
+	ticker := time.NewTicker(time.Second)
+	for now := range ticker.C {
+		s.Tick(now)
+	}
+}
+
+// Tick opens the windows of rules which are due and closes expired windows
+func (s *Schedule) Tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, rule := range s.rules {
+		if !rule.next.After(now) {
+			// a window of the same rule which is still open is replaced
+			s.windows = slices.DeleteFunc(s.windows, func(w ScheduleWindow) bool {
+				return w.Kind == ScheduleWindowRule && w.Id == rule.config.Name
+			})
+			s.open(rule, rule.next)
+			rule.next = rule.schedule.Next(now)
+			changed = true
+		}
+	}
+	expired := func(w ScheduleWindow) bool { return w.End <= now.Unix() }
+	if slices.ContainsFunc(s.windows, expired) {
+		for _, w := range s.windows {
+			if expired(w) {
+				log.Info("closing schedule window ", w.Id)
+			}
+		}
+		s.windows = slices.DeleteFunc(s.windows, expired)
+		changed = true
+	}
+	if changed {
+		s.apply()
+	}
+}
+
+// apply sets the rate limit and pause state of every group according to the
+// open windows. The caller must hold s.mu.
+func (s *Schedule) apply() {
+	slices.SortStableFunc(s.windows, func(a, b ScheduleWindow) int { return int(a.Start - b.Start) })
+	for _, group := range groupsByPriority {
+		status := GroupScheduleStatus{Name: group.Name, RateLimit: group.RateLimit, BaseRateLimit: group.RateLimit}
+		for _, w := range s.windows {
+			if len(w.Groups) > 0 && !slices.Contains(w.Groups, group.Name) {
+				continue
+			}
+			status.Window = w.Id
+			status.Paused = w.Pause
+			if !w.Pause {
+				status.RateLimit = w.RateLimit
+			}
+		}
+		if previous, ok := s.status[group.Name]; !ok || previous != status {
+			log.Debug("group ", group.Name, " rate_limit=", status.RateLimit, " paused=", status.Paused, " window=", status.Window)
+		}
+		s.status[group.Name] = status
+
+		SetQueryLimit(group.limiter, status.RateLimit)
+		group.paused.Store(status.Paused)
+		labels := prometheus.Labels{"group": group.Name}
+		groupRateLimit.With(labels).Set(float64(status.RateLimit))
+		if status.Paused {
+			groupPaused.With(labels).Set(1)
+		} else {
+			groupPaused.With(labels).Set(0)
+		}
+	}
+}
+
+// PausedUntil returns the end of the window pausing the group, ok is false
+// if no window pauses it
+func (s *Schedule) PausedUntil(groupName string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pausing *ScheduleWindow
+	// the windows are ordered by start, the most recently opened one wins
+	for i, w := range s.windows {
+		if len(w.Groups) == 0 || slices.Contains(w.Groups, groupName) {
+			pausing = &s.windows[i]
+		}
+	}
+	if pausing == nil || !pausing.Pause {
+		return time.Time{}, false
+	}
+	return time.Unix(pausing.End, 0), true
+}
+
+// Boost opens an ad-hoc window with the rate limit for the groups (all groups
+// if empty) until the deadline
+func (s *Schedule) Boost(groupNames []string, rateLimit uint, deadline time.Time) (ScheduleWindow, error) {
+	if err := validateGroupNames(groupNames); err != nil {
+		return ScheduleWindow{}, err
+	}
+	now := time.Now()
+	if !deadline.After(now) {
+		return ScheduleWindow{}, fmt.Errorf("deadline %s is in the past", deadline.Format(time.RFC3339))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.boostSeq++
+	window := ScheduleWindow{
+		Id:        fmt.Sprintf("boost-%d", s.boostSeq),
+		Kind:      ScheduleWindowBoost,
+		Groups:    groupNames,
+		RateLimit: rateLimit,
+		Start:     now.Unix(),
+		End:       deadline.Unix(),
+	}
+	log.Info("opening boost window ", window.Id, " rate_limit=", rateLimit, " until ", deadline)
+	s.windows = append(s.windows, window)
+	s.apply()
+	return window, nil
+}
+
+// EndBoost closes a boost window before its deadline
+func (s *Schedule) EndBoost(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.windows)
+	s.windows = slices.DeleteFunc(s.windows, func(w ScheduleWindow) bool { return w.Kind == ScheduleWindowBoost && w.Id == id })
+	if len(s.windows) == n {
+		return false
+	}
+	log.Info("closing boost window ", id)
+	s.apply()
+	return true
+}
+
+// Status returns the effective state of the groups, the open windows and the rules
+func (s *Schedule) Status() ([]GroupScheduleStatus, []ScheduleWindow, []ScheduleRuleStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var groupStatus []GroupScheduleStatus
+	for _, group := range groupsByPriority {
+		groupStatus = append(groupStatus, s.status[group.Name])
+	}
+	var rules []ScheduleRuleStatus
+	for _, rule := range s.rules {
+		rules = append(rules, ScheduleRuleStatus{
+			Name:            rule.config.Name,
+			Cron:            rule.config.Cron,
+			DurationSeconds: rule.config.DurationSeconds,
+			Groups:          rule.config.Groups,
+			RateLimit:       rule.config.RateLimit,
+			Pause:           rule.config.Pause,
+			Next:            rule.next.Unix(),
+		})
+	}
+	return groupStatus, slices.Clone(s.windows), rules
+}
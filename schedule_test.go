@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestNewScheduleRejectsInvalidRules(t *testing.T) {
+	withGroups(t, GroupConfiguration{Name: "bulk"})
+	tests := []struct {
+		name  string
+		rules []ScheduleRuleConfiguration
+	}{
+		{"without name", []ScheduleRuleConfiguration{{Cron: "@daily", DurationSeconds: 60}}},
+		{"duplicate", []ScheduleRuleConfiguration{{Name: "a", Cron: "@daily", DurationSeconds: 60}, {Name: "a", Cron: "@hourly", DurationSeconds: 60}}},
+		{"without duration", []ScheduleRuleConfiguration{{Name: "a", Cron: "@daily"}}},
+		{"invalid cron", []ScheduleRuleConfiguration{{Name: "a", Cron: "61 * * * *", DurationSeconds: 60}}},
+		{"unknown group", []ScheduleRuleConfiguration{{Name: "a", Cron: "@daily", DurationSeconds: 60, Groups: []string{"unknown"}}}},
+	}
+	for _, test := range tests {
+		if _, err := NewSchedule(test.rules, time.Now()); err == nil {
+			t.Errorf("rule %s was accepted", test.name)
+		}
+	}
+}
+
+func TestScheduleWindowsChangeTheGroups(t *testing.T) {
+	withGroups(t, GroupConfiguration{Name: "bulk", RateLimit: 10}, GroupConfiguration{Name: "critical", RateLimit: 50})
+	bulk, critical := groups["bulk"], groups["critical"]
+	now := time.Date(2026, 1, 5, 10, 30, 0, 0, time.Local)
+	schedule, err := NewSchedule([]ScheduleRuleConfiguration{
+		// opened at 10:00, still open
+		{Name: "morning", Cron: "0 10 * * *", DurationSeconds: 3600, Groups: []string{"bulk"}, RateLimit: 100},
+		{Name: "noon", Cron: "0 12 * * *", DurationSeconds: 600, Pause: true},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bulk.limiter.Limit() != 100 || critical.limiter.Limit() != 50 {
+		t.Errorf("rate limits = %v and %v, want the window of bulk (100) and the base of critical (50)", bulk.limiter.Limit(), critical.limiter.Limit())
+	}
+
+	// the morning window closes at 11:00
+	schedule.Tick(now.Add(30 * time.Minute))
+	if bulk.limiter.Limit() != 10 {
+		t.Errorf("rate limit of bulk after its window = %v, want 10", bulk.limiter.Limit())
+	}
+
+	schedule.Tick(now.Add(90 * time.Minute))
+	if !bulk.Paused() || !critical.Paused() {
+		t.Error("the noon window did not pause all groups")
+	}
+	if until, ok := schedule.PausedUntil("bulk"); !ok || !until.Equal(time.Date(2026, 1, 5, 12, 10, 0, 0, time.Local)) {
+		t.Errorf("bulk paused until %v (%v), want the end of the noon window", until, ok)
+	}
+	status, windows, rules := schedule.Status()
+	if len(windows) != 1 || windows[0].Id != "noon" || len(status) != 3 || len(rules) != 2 {
+		t.Errorf("status = %v, windows = %v, rules = %v", status, windows, rules)
+	}
+
+	schedule.Tick(now.Add(100 * time.Minute))
+	if bulk.Paused() || critical.Paused() {
+		t.Error("the groups are still paused after the noon window")
+	}
+}
+
+func TestBoost(t *testing.T) {
+	withGroups(t, GroupConfiguration{Name: "bulk", RateLimit: 10})
+	bulk := groups["bulk"]
+	schedule, err := NewSchedule(nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedule.Boost([]string{"unknown"}, 100, time.Now().Add(time.Minute)); err == nil {
+		t.Error("a boost of an unknown group was accepted")
+	}
+	if _, err := schedule.Boost(nil, 100, time.Now().Add(-time.Minute)); err == nil {
+		t.Error("a boost with a deadline in the past was accepted")
+	}
+
+	first, err := schedule.Boost([]string{"bulk"}, 100, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unlimited, the most recent window wins
+	second, err := schedule.Boost(nil, 0, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bulk.limiter.Limit() != rate.Inf {
+		t.Errorf("rate limit = %v, want unlimited", bulk.limiter.Limit())
+	}
+	schedule.EndBoost(second.Id)
+	if bulk.limiter.Limit() != 100 {
+		t.Errorf("rate limit after the second boost = %v, want the first boost (100)", bulk.limiter.Limit())
+	}
+	if !schedule.EndBoost(first.Id) || schedule.EndBoost(first.Id) {
+		t.Error("EndBoost did not report whether the boost was open")
+	}
+	if bulk.limiter.Limit() != 10 {
+		t.Errorf("rate limit after all boosts = %v, want the base (10)", bulk.limiter.Limit())
+	}
+}
+
+func TestFillDefersPausedGroupsUntilTheWindowEnds(t *testing.T) {
+	resetQueue(t)
+	withGroups(t)
+	withGroupLimit(t, rate.Inf)
+	paused, err := NewSchedule([]ScheduleRuleConfiguration{
+		{Name: "maintenance", Cron: "* * * * *", DurationSeconds: 3600, Pause: true},
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := schedule
+	schedule = paused
+	t.Cleanup(func() { schedule = previous })
+	queueDue(t, readyQueueSize+10, DefaultGroupName)
+
+	fillWithin(t, 5*time.Second)
+	HeapDo(dh, func(h *DomainHeap) {
+		for _, d := range *h {
+			if due := d.MillisUntilDue(); due < 3500*1000 {
+				t.Errorf("%s deferred by %dms, want until the end of the window", d.ToString(), due)
+			}
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// readyQueueSize - due domains buffered per group. Further due domains of a
+// rate limited group are deferred in the heap according to the group's rate.
+const readyQueueSize = 64
+
+var (
+	dispatchedDomains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "dispatched_domains_total",
+		Help:      "The total number of dispatched refreshes by group",
+	},
+		[]string{"group"},
+	)
+	deferredDomains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "deferred_domains_total",
+		Help:      "The total number of due refreshes deferred because their group was rate limited",
+	},
+		[]string{"group"},
+	)
+)
+
+func init() {
+	prometheus.Register(dispatchedDomains)
+	prometheus.Register(deferredDomains)
+}
+
+// Scheduler - moves due domains from the heap into per-group ready lists and
+// dispatches them, highest group priority first, within the rate limits
+type Scheduler struct {
+	dh     *DomainHeap
+	config *ResolverConfiguration
+	ready  map[string][]Domain
+	// paused - no domains are dispatched, in-flight refreshes finish
+	paused   atomic.Bool
+	inFlight atomic.Int64
+	// lastLoop - unix nanoseconds of the last loop iteration
+	lastLoop atomic.Int64
+	// outside - ready and in-flight domains, which are not in the heap
+	mu      sync.Mutex
+	outside map[string]Domain
+}
+
+func NewScheduler(dh *DomainHeap, config *ResolverConfiguration) *Scheduler {
+	return &Scheduler{
+		dh:      dh,
+		config:  config,
+		ready:   make(map[string][]Domain),
+		outside: make(map[string]Domain),
+	}
+}
+
+// Run dispatches due domains forever
+func (s *Scheduler) Run() {
+	checkInterval := time.Duration(s.config.SleepLowTresholdCheckIntervalMilliseconds) * time.Millisecond
+	for {
+		s.lastLoop.Store(time.Now().UnixNano())
+		s.updateQueueSize()
+		if s.Paused() {
+			time.Sleep(checkInterval)
+			continue
+		}
+		s.fill()
+
+		cur, group, wait, ok := s.next(checkInterval)
+		if !ok {
+			if s.dh.Size() == 0 && wait == checkInterval {
+				time.Sleep(time.Duration(s.config.SleepLowTresholdMilliseconds) * time.Millisecond)
+			} else {
+				time.Sleep(wait)
+			}
+			continue
+		}
+
+		sleep_time := cur.MillisUntilDue()
+		if sleep_time >= 0 && sleep_time <= int64(s.config.SleepLowTresholdCheckIntervalMilliseconds) {
+			time.Sleep(time.Duration(sleep_time) * time.Millisecond)
+		}
+		WaitForQuerySlot(context.Background())
+		s.dispatch(cur, group)
+	}
+}
+
+// fill moves the domains due within SleepLowTresholdMilliseconds into the
+// ready list of their group. If a ready list is full, the domain is deferred
+// by the time the group needs to dispatch the domains ahead of it, or until
+// the schedule window pausing the group ends. Domains of
+// a full list which drains within SleepLowTresholdMilliseconds are held back
+// until the end of the fill, they would be popped again right away
+// otherwise, and the domains of the other groups are filled.
+func (s *Scheduler) fill() {
+	deferred := make(map[string]int)
+	var held []Domain
+	defer func() {
+		for _, d := range held {
+			HeapPush(s.dh, d)
+		}
+	}()
+	for {
+		cur, ok := HeapPop(s.dh)
+		if !ok {
+			return
+		}
+		if cur.MillisUntilDue() > int64(s.config.SleepLowTresholdMilliseconds) {
+			HeapPush(s.dh, cur)
+			return
+		}
+		if IsRemoved(cur) {
+			cur.Dropped()
+			continue
+		}
+		promote(&cur)
+		group, err := FindGroup(cur.GroupName())
+		if err != nil {
+			group = groups[DefaultGroupName]
+		}
+		if len(s.ready[group.Name]) >= readyQueueSize {
+			deferred[group.Name]++
+			defer_millis := s.config.SleepLowTresholdMilliseconds + s.config.SleepLowTresholdCheckIntervalMilliseconds
+			if group.Paused() {
+				defer_millis = max(defer_millis, pausedMillis(group))
+			} else if len(ActiveTargets(group.Targets)) > 0 {
+				defer_millis = s.deferMillis(group, readyQueueSize+deferred[group.Name])
+				if defer_millis <= s.config.SleepLowTresholdMilliseconds {
+					held = append(held, cur)
+					continue
+				}
+			}
+			cur.RefreshInMillis(defer_millis)
+			deferredDomains.With(prometheus.Labels{"group": group.Name}).Inc()
+			HeapPush(s.dh, cur)
+			continue
+		}
+		s.ready[group.Name] = append(s.ready[group.Name], cur)
+		s.mu.Lock()
+		// the copy is read by listings while cur is refreshed
+		s.outside[cur.Key()] = cur.snapshot()
+		s.mu.Unlock()
+	}
+}
+
+// pausedMillis returns the time until the schedule window pausing the group
+// ends, 0 if it is unknown
+func pausedMillis(group *Group) uint64 {
+	if schedule == nil {
+		return 0
+	}
+	until, ok := schedule.PausedUntil(group.Name)
+	if !ok {
+		return 0
+	}
+	return uint64(max(0, time.Until(until).Milliseconds()))
+}
+
+// deferMillis returns the time the group needs to dispatch queued domains,
+// 0 without rate limit
+func (s *Scheduler) deferMillis(group *Group, queued int) uint64 {
+	limit := min(group.limiter.Limit(), queryLimiter.Limit())
+	if limit == rate.Inf || limit <= 0 {
+		return 0
+	}
+	return uint64(float64(queued) * 1000 / float64(limit))
+}
+
+// next returns the first ready domain of the group with the highest priority
+// which is neither paused (by a schedule window or because all its targets
+// are paused) nor rate limited. Otherwise it returns how long to
+// wait for the next rate limit token (at most maxWait).
+func (s *Scheduler) next(maxWait time.Duration) (Domain, *Group, time.Duration, bool) {
+	wait := maxWait
+	for _, group := range groupsByPriority {
+		ready := s.ready[group.Name]
+		for len(ready) > 0 && IsRemoved(ready[0]) {
+			ready[0].Dropped()
+			s.untrack(ready[0])
+			ready = ready[1:]
+		}
+		s.ready[group.Name] = ready
+		if len(ready) == 0 || group.Paused() || len(ActiveTargets(group.Targets)) == 0 {
+			continue
+		}
+		if group.limiter.Allow() {
+			s.ready[group.Name] = ready[1:]
+			return ready[0], group, 0, true
+		}
+		if token_wait := TokenWait(group.limiter); token_wait < wait {
+			wait = token_wait
+		}
+	}
+	return Domain{}, nil, wait, false
+}
+
+// dispatch queries the domain in the background and pushes it back onto the
+// heap with its next refresh time
+func (s *Scheduler) dispatch(cur Domain, group *Group) {
+	labels := prometheus.Labels{"group": group.Name}
+	dispatchedDomains.With(labels).Inc()
+	PublishDomainEvent(EventDispatch, &cur, nil, nil)
+	// the trace of a refresh spans from the dispatch to the push back onto the heap
+	var span trace.Span
+	cur.ctx, span = tracer.Start(context.Background(), "refresh", trace.WithAttributes(
+		attributeQname.String(cur.Record_name),
+		attributeQtype.String(cur.Record_type),
+		attributeGroup.String(group.Name),
+		attributeRefreshAt.Int64(cur.Refresh_at),
+	))
+	ch := make(chan uint, 1)
+	s.inFlight.Add(1)
+	go func() {
+		defer s.inFlight.Add(-1)
+		defer span.End()
+		start := time.Now()
+		go cur.Query(group.Targets, group.Strategies, group.Config, ch)
+		var ttl = <-ch
+		cur.RefreshInSeconds(group.RefreshDelay(ttl))
+		refreshJobs.Complete(&cur)
+		// added explicitly while it was ready or in flight
+		promote(&cur)
+		// untrack before the push, once pushed the domain can be filled and
+		// tracked again under the same key
+		s.untrack(cur)
+		if !IsRemoved(cur) {
+			span.AddEvent("rescheduled", trace.WithAttributes(attributeRefreshAt.Int64(cur.Refresh_at)))
+			log.WithContext(cur.ctx).Trace("rescheduled ", cur.ToString(), " in ", cur.SecondsUntilDue(), " seconds")
+			pushed := cur
+			pushed.ctx = nil
+			HeapPush(s.dh, pushed)
+			s.dh.DeriveTargets(&cur, group)
+		} else {
+			span.AddEvent("removed")
+			log.WithContext(cur.ctx).Trace("dropped ", cur.ToString(), " removed while in flight")
+			cur.Dropped()
+		}
+		if differ != nil {
+			differ.Compare(&cur, group)
+		}
+		elapsed := time.Since(start)
+		queryResponseTimes.With(labels).Observe(elapsed.Seconds())
+		queryResponseTtl.With(labels).Observe(float64(ttl))
+	}()
+}
+
+// updateQueueSize sets the queue size by state, the ready lists are only
+// accessed by the loop of Run
+func (s *Scheduler) updateQueueSize() {
+	ready := 0
+	for _, domains := range s.ready {
+		ready += len(domains)
+	}
+	queueSize.With(prometheus.Labels{"state": "scheduled"}).Set(float64(s.dh.Size()))
+	queueSize.With(prometheus.Labels{"state": "ready"}).Set(float64(ready))
+	queueSize.With(prometheus.Labels{"state": "in_flight"}).Set(float64(s.InFlight()))
+}
+
+// Paused returns whether dispatching is paused
+func (s *Scheduler) Paused() bool {
+	return s.paused.Load()
+}
+
+func (s *Scheduler) SetPaused(paused bool) {
+	s.paused.Store(paused)
+	if paused {
+		schedulerPausedGauge.Set(1)
+	} else {
+		schedulerPausedGauge.Set(0)
+	}
+}
+
+// InFlight returns the number of dispatched refreshes which are not finished
+func (s *Scheduler) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// Outside returns the ready and in-flight domains, which are not in the heap,
+// except for removed domains
+func (s *Scheduler) Outside() []Domain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	domains := make([]Domain, 0, len(s.outside))
+	for _, d := range s.outside {
+		if !IsRemoved(d) {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// untrack forgets a domain which was pushed back onto the heap or dropped
+func (s *Scheduler) untrack(d Domain) {
+	s.mu.Lock()
+	delete(s.outside, d.Key())
+	s.mu.Unlock()
+}
+
+// LastLoop returns when the loop ran last, zero if it never ran
+func (s *Scheduler) LastLoop() time.Time {
+	last := s.lastLoop.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
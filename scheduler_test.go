@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// queueDue adds n domains of the group which are due now
+func queueDue(t *testing.T, n int, group string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		d := Domain{Record_name: fmt.Sprintf("d%d.%s.example", i, group), Record_type: "A", Group: group}
+		if group == DefaultGroupName {
+			d.Group = ""
+		}
+		if err := dh.AddDomain(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fillWithin runs one fill of the scheduler and fails if it does not return
+func fillWithin(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		scheduler.fill()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("fill did not return")
+	}
+}
+
+// withGroupLimit sets the rate limit of the default group for the test
+func withGroupLimit(t *testing.T, limit rate.Limit) *Group {
+	group := groups[DefaultGroupName]
+	previous := group.limiter
+	group.limiter = rate.NewLimiter(limit, 1)
+	t.Cleanup(func() { group.limiter = previous })
+	return group
+}
+
+func TestFillHoldsBackAFullReadyListOfAnUnlimitedGroup(t *testing.T) {
+	resetQueue(t)
+	withGroupLimit(t, rate.Inf)
+	queueDue(t, 3*readyQueueSize, DefaultGroupName)
+
+	fillWithin(t, 5*time.Second)
+	if ready := len(scheduler.ready[DefaultGroupName]); ready != readyQueueSize {
+		t.Errorf("%d ready domains, want %d", ready, readyQueueSize)
+	}
+	// the others stay due, they are filled as soon as the list drains
+	HeapDo(dh, func(h *DomainHeap) {
+		if len(*h) != 2*readyQueueSize {
+			t.Errorf("%d domains left on the heap, want %d", len(*h), 2*readyQueueSize)
+		}
+		for _, d := range *h {
+			if d.MillisUntilDue() > 0 {
+				t.Errorf("%s was deferred by %dms", d.ToString(), d.MillisUntilDue())
+				return
+			}
+		}
+	})
+}
+
+func TestFillContinuesBehindAFullReadyList(t *testing.T) {
+	resetQueue(t)
+	withGroups(t, GroupConfiguration{Name: "web"})
+	withGroupLimit(t, rate.Inf)
+	queueDue(t, 2*readyQueueSize, DefaultGroupName)
+	// due after the domains of the full default group
+	web := Domain{Record_name: "example.com", Record_type: "A", Group: "web"}
+	web.RefreshInMillis(100)
+	HeapPush(dh, web)
+
+	fillWithin(t, 5*time.Second)
+	if ready := len(scheduler.ready["web"]); ready != 1 {
+		t.Errorf("%d ready domains of the web group, want 1", ready)
+	}
+	if size := dh.Size(); size != readyQueueSize {
+		t.Errorf("%d domains left on the heap, want the %d held back", size, readyQueueSize)
+	}
+}
+
+func TestHeapPopOfAnEmptyHeap(t *testing.T) {
+	resetQueue(t)
+	queueDue(t, 1, DefaultGroupName)
+	if _, ok := HeapPop(dh); !ok {
+		t.Fatal("no domain popped from the heap")
+	}
+	// another pop, e.g. after a removal, reports the empty heap
+	if d, ok := HeapPop(dh); ok {
+		t.Errorf("popped %s from the empty heap", d.ToString())
+	}
+}
+
+func TestFillDefersBeyondTheReadyListOfARateLimitedGroup(t *testing.T) {
+	resetQueue(t)
+	withGroupLimit(t, 10)
+	queueDue(t, readyQueueSize+10, DefaultGroupName)
+
+	fillWithin(t, 5*time.Second)
+	if ready := len(scheduler.ready[DefaultGroupName]); ready != readyQueueSize {
+		t.Errorf("%d ready domains, want %d", ready, readyQueueSize)
+	}
+	// the group needs 6.5s for the ready domains at 10 per second
+	HeapDo(dh, func(h *DomainHeap) {
+		for _, d := range *h {
+			if due := d.MillisUntilDue(); due < 6000 {
+				t.Errorf("%s deferred by %dms, want at least the time to dispatch the ready list", d.ToString(), due)
+			}
+		}
+	})
+}
+
+func TestFillDefersDomainsOfAPausedGroup(t *testing.T) {
+	resetQueue(t)
+	withGroupLimit(t, rate.Inf)
+	group := groups[DefaultGroupName]
+	group.paused.Store(true)
+	t.Cleanup(func() { group.paused.Store(false) })
+	queueDue(t, readyQueueSize+10, DefaultGroupName)
+
+	fillWithin(t, 5*time.Second)
+	HeapDo(dh, func(h *DomainHeap) {
+		if len(*h) != 10 {
+			t.Errorf("%d domains left on the heap, want 10", len(*h))
+		}
+		for _, d := range *h {
+			if due := d.MillisUntilDue(); due <= int64(resolverConfiguration.SleepLowTresholdMilliseconds) {
+				t.Errorf("%s deferred by %dms, want beyond SleepLowTresholdMilliseconds", d.ToString(), due)
+			}
+		}
+	})
+}
+
+// queueCandidates returns the number of observed queue candidates and the
+// number of candidates due within the bucket bound
+func queueCandidates(t *testing.T, bound float64) (uint64, uint64) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "syringe_queue_candidate_seconds" {
+			continue
+		}
+		histogram := family.GetMetric()[0].GetHistogram()
+		for _, bucket := range histogram.GetBucket() {
+			if bucket.GetUpperBound() == bound {
+				return histogram.GetSampleCount(), bucket.GetCumulativeCount()
+			}
+		}
+	}
+	t.Fatalf("no syringe_queue_candidate_seconds bucket %v", bound)
+	return 0, 0
+}
+
+func TestQueueCandidateMetric(t *testing.T) {
+	resetQueue(t)
+	count, due := queueCandidates(t, 300)
+	for name, seconds := range map[string]uint{"example.com": 120, "example.net": 900} {
+		d := Domain{Record_name: name, Record_type: "A"}
+		d.RefreshInSeconds(seconds)
+		HeapPush(dh, d)
+	}
+	// the histogram is observed after the push, wait for the heap
+	HeapDo(dh, func(h *DomainHeap) {})
+	if total, within := queueCandidates(t, 300); total-count != 2 || within-due != 1 {
+		t.Errorf("%d candidates observed, %d due within 300 seconds, want 2 and 1", total-count, within-due)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// certificateCheckInterval - how often the certificate files are checked for changes
+const certificateCheckInterval = 5 * time.Second
+
+// ApiTlsConfiguration - serve the api via https, optionally requiring client certificates
+type ApiTlsConfiguration struct {
+	CertFile string `yaml:"CertFile"`
+	KeyFile  string `yaml:"KeyFile"`
+	// ClientCaFile - require client certificates issued by these CAs
+	ClientCaFile string `yaml:"ClientCaFile"`
+	// ClientSubjects - shell patterns (like *.ops.example.com) of which one must match the common name or a dns name of the client certificate, empty allows all
+	ClientSubjects []string `yaml:"ClientSubjects"`
+}
+
+// CertificateReloader - serves the certificate of CertFile/KeyFile and
+// reloads it once the files change
+type CertificateReloader struct {
+	certFile    string
+	keyFile     string
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modified    time.Time
+	checked     time.Time
+}
+
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *CertificateReloader) lastModified() (time.Time, error) {
+	var modified time.Time
+	for _, f := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return modified, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+func (reloader *CertificateReloader) load() error {
+	modified, err := reloader.lastModified()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.certificate = &certificate
+	reloader.modified = modified
+	return nil
+}
+
+// GetCertificate returns the current certificate. If the files changed, the
+// certificate is reloaded; on errors the previous certificate stays in use.
+func (reloader *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	if time.Since(reloader.checked) >= certificateCheckInterval {
+		reloader.checked = time.Now()
+		modified, err := reloader.lastModified()
+		if err == nil && !modified.Equal(reloader.modified) {
+			if err := reloader.load(); err != nil {
+				log.Error("unable to reload api certificate: ", err)
+			} else {
+				log.Info("reloaded api certificate ", reloader.certFile)
+			}
+		}
+	}
+	return reloader.certificate, nil
+}
+
+// MatchClientSubject returns whether the common name or a dns name of the
+// certificate matches one of the patterns
+func MatchClientSubject(certificate *x509.Certificate, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	names := append([]string{certificate.Subject.CommonName}, certificate.DNSNames...)
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if matched, _ := path.Match(pattern, name); matched && name != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewApiTlsConfig returns the tls configuration of the api server, nil if no
+// certificate is configured
+func NewApiTlsConfig(config ApiTlsConfiguration) (*tls.Config, error) {
+	if config.ClientCaFile == "" && len(config.ClientSubjects) > 0 {
+		// without ca no client certificate is requested, the subjects would never be checked
+		return nil, errors.New("ApiTls.ClientSubjects requires ApiTls.ClientCaFile")
+	}
+	if config.CertFile == "" && config.KeyFile == "" {
+		if config.ClientCaFile != "" {
+			return nil, errors.New("ApiTls.ClientCaFile requires ApiTls.CertFile and ApiTls.KeyFile")
+		}
+		return nil, nil
+	}
+	reloader, err := NewCertificateReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("api certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if config.ClientCaFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(config.ClientCaFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", config.ClientCaFile)
+	}
+	for _, pattern := range config.ClientSubjects {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid ApiTls.ClientSubjects pattern %s", pattern)
+		}
+	}
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 || !MatchClientSubject(state.PeerCertificates[0], config.ClientSubjects) {
+			subject := ""
+			if len(state.PeerCertificates) > 0 {
+				subject = state.PeerCertificates[0].Subject.String()
+			}
+			log.Warn("rejected api client certificate '", subject, "': subject not allowed")
+			apiAuthFailures.With(prometheus.Labels{"reason": "certificate"}).Inc()
+			return fmt.Errorf("client certificate subject '%s' not allowed", subject)
+		}
+		return nil
+	}
+	return tlsConfig, nil
+}
+
+// NewApiServer returns the http server of the api listening on
+// ServerListenAddress:ServerListenPort
+func NewApiServer(config *ResolverConfiguration, handler http.Handler) (*http.Server, error) {
+	tlsConfig, err := NewApiTlsConfig(config.ApiTls)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{
+		Addr:      net.JoinHostPort(config.ServerListenAddress, strconv.FormatUint(uint64(config.ServerListenPort), 10)),
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+// ServeApi serves the api until the server fails
+func ServeApi(server *http.Server) error {
+	if server.TLSConfig != nil {
+		log.Info("serving api via https on ", server.Addr)
+		return server.ListenAndServeTLS("", "")
+	}
+	log.Info("serving api via http on ", server.Addr)
+	return server.ListenAndServe()
+}
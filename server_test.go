@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// serveApiTls serves an empty api with the tls configuration and returns its url
+func serveApiTls(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})}
+	go server.Serve(tls.NewListener(listener, config))
+	t.Cleanup(func() { server.Close() })
+	return "https://localhost:" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// apiClient returns a client trusting the ca of pki, presenting the client
+// certificate if one is given
+func apiClient(t *testing.T, pki *testPki, certificate ...tls.Certificate) *http.Client {
+	t.Helper()
+	pem, err := os.ReadFile(pki.CaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificate},
+	}}
+}
+
+func clientCertificate(t *testing.T, pki *testPki, commonName string) tls.Certificate {
+	t.Helper()
+	certificate, err := tls.LoadX509KeyPair(pki.Issue(t, commonName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func TestNewApiTlsConfigWithoutCertificate(t *testing.T) {
+	if config, err := NewApiTlsConfig(ApiTlsConfiguration{}); config != nil || err != nil {
+		t.Errorf("NewApiTlsConfig without certificate = %v %v, want plain http", config, err)
+	}
+	pki := newTestPki(t)
+	invalid := []ApiTlsConfiguration{
+		{ClientCaFile: pki.CaFile},
+		{ClientSubjects: []string{"*.ops.example.com"}},
+	}
+	certFile, keyFile := pki.Issue(t, "api.test")
+	invalid = append(invalid,
+		// the subjects would never be checked without client certificates
+		ApiTlsConfiguration{CertFile: certFile, KeyFile: keyFile, ClientSubjects: []string{"*.ops.example.com"}},
+		ApiTlsConfiguration{CertFile: certFile, KeyFile: keyFile, ClientCaFile: pki.CaFile, ClientSubjects: []string{"[a-"}},
+		ApiTlsConfiguration{CertFile: certFile, KeyFile: certFile},
+	)
+	for _, config := range invalid {
+		if _, err := NewApiTlsConfig(config); err == nil {
+			t.Errorf("NewApiTlsConfig(%+v) accepted an invalid configuration", config)
+		}
+	}
+}
+
+func TestApiTlsServesTheCertificate(t *testing.T) {
+	pki := newTestPki(t)
+	certFile, keyFile := pki.Issue(t, "api.test")
+	config, err := NewApiTlsConfig(ApiTlsConfiguration{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serveApiTls(t, config)
+
+	response, err := apiClient(t, pki).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if subject := response.TLS.PeerCertificates[0].Subject.CommonName; subject != "api.test" {
+		t.Errorf("server certificate %s, want api.test", subject)
+	}
+}
+
+func TestApiTlsRequiresClientCertificates(t *testing.T) {
+	pki := newTestPki(t)
+	certFile, keyFile := pki.Issue(t, "api.test")
+	config, err := NewApiTlsConfig(ApiTlsConfiguration{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientCaFile:   pki.CaFile,
+		ClientSubjects: []string{"*.ops.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serveApiTls(t, config)
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		ok     bool
+	}{
+		{"allowed subject", apiClient(t, pki, clientCertificate(t, pki, "monitor.ops.example.com")), true},
+		{"other subject", apiClient(t, pki, clientCertificate(t, pki, "monitor.dev.example.com")), false},
+		{"untrusted ca", apiClient(t, pki, clientCertificate(t, newTestPki(t), "monitor.ops.example.com")), false},
+		{"no certificate", apiClient(t, pki), false},
+	}
+	for _, test := range tests {
+		response, err := test.client.Get(url)
+		if err == nil {
+			response.Body.Close()
+		}
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: request error %v, want success %v", test.name, err, test.ok)
+		}
+	}
+}
+
+func TestMatchClientSubject(t *testing.T) {
+	certificate := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "monitor"},
+		DNSNames: []string{"monitor.ops.example.com"},
+	}
+	tests := []struct {
+		patterns []string
+		want     bool
+	}{
+		{nil, true},
+		{[]string{"monitor"}, true},
+		{[]string{"*.ops.example.com"}, true},
+		{[]string{"*.dev.example.com", "mon*"}, true},
+		{[]string{"*.dev.example.com"}, false},
+		{[]string{"monitor.ops.example.org"}, false},
+	}
+	for _, test := range tests {
+		if got := MatchClientSubject(certificate, test.patterns); got != test.want {
+			t.Errorf("MatchClientSubject(%v) = %v, want %v", test.patterns, got, test.want)
+		}
+	}
+	if MatchClientSubject(&x509.Certificate{}, []string{"*"}) {
+		t.Error("a certificate without names matched *")
+	}
+}
+
+func TestCertificateReloaderReloadsChangedFiles(t *testing.T) {
+	pki := newTestPki(t)
+	certFile, keyFile := pki.Issue(t, "old.test")
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := reloader.GetCertificate(nil)
+
+	newCertFile, newKeyFile := pki.Issue(t, "new.test")
+	for source, destination := range map[string]string{newCertFile: certFile, newKeyFile: keyFile} {
+		content, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(destination, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		// file systems with coarse timestamps would hide the change
+		modified := time.Now().Add(time.Minute)
+		if err := os.Chtimes(destination, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if current, _ := reloader.GetCertificate(nil); current != old {
+		t.Error("the certificate was reloaded before the check interval passed")
+	}
+	reloader.checked = time.Time{}
+	current, _ := reloader.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(current.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "new.test" {
+		t.Errorf("certificate after the change is %s, want new.test", leaf.Subject.CommonName)
+	}
+
+	// a broken replacement keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(2 * time.Minute)
+	os.Chtimes(keyFile, modified, modified)
+	reloader.checked = time.Time{}
+	if broken, _ := reloader.GetCertificate(nil); broken != current {
+		t.Error("a broken certificate replaced the previous one")
+	}
+}
@@ -2,19 +2,19 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
-	"runtime"
 	"strings"
 
-	dnsresolver "github.com/Focinfi/go-dns-resolver"
 	dns "github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
 	domainsResolvedByStrategy = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "domains_resolved_by_strategy",
-		Help: "The total number of resolved domains by strategy",
+		Namespace: "syringe",
+		Name:      "domains_resolved_total",
+		Help:      "The total number of resolved domains by strategy",
 	},
 		[]string{"strategy"},
 	)
@@ -24,68 +24,106 @@ func init() {
 	prometheus.Register(domainsResolvedByStrategy)
 }
 
+// ResolverStrategy - a named function returning the delay until the next refresh.
+// Fallback strategies only pick a delay, their success still counts as failure.
+type ResolverStrategy struct {
+	Name     string
+	Fallback bool
+	Resolve  func(config *ResolverConfiguration, target *Target, domain *Domain) (uint, error)
+}
+
 type ResolverStrategies struct {
-	ResolveFunctions []func(config *ResolverConfiguration, domain *Domain) (uint, error)
+	ResolveFunctions []ResolverStrategy
+}
+
+// Strategies - all strategies by name, groups pick their pipeline from these
+var Strategies = map[string]ResolverStrategy{
+	"regular":        {Name: "regular", Resolve: TryQueryRegularDomain},
+	"soa":            {Name: "soa", Resolve: TryQuerySOADomain},
+	"flexible_delay": {Name: "flexible_delay", Fallback: true, Resolve: TryQueryFlexibleDelayDomain},
+	"static_delay":   {Name: "static_delay", Fallback: true, Resolve: TryQueryStaticDelayDomain},
 }
 
-func TryQueryRegularDomain(config *ResolverConfiguration, domain *Domain) (uint, error) {
-	resolver := dnsresolver.NewResolver(config.ResolverIp)
-	resolver.Targets(domain.Record_name).Types(dnsresolver.QueryType(domain.RecordType()))
-	// Lookup
-	res := resolver.Lookup()
-	for target := range res.ResMap {
-		for _, r := range res.ResMap[target] {
-			ttl_resolved := uint(r.Ttl.Seconds())
-			pc, _, _, _ := runtime.Caller(0)
-			f := runtime.FuncForPC(pc)
-			domainsResolvedByStrategy.With(prometheus.Labels{"strategy": f.Name()}).Inc()
-			if ttl_resolved < config.PinMinTtl {
-				return config.PinMinTtl, nil
-			} else {
-				return uint(r.Ttl.Seconds()), nil
-			}
+// DefaultStrategyPipeline - the strategies used by groups without explicit pipeline
+var DefaultStrategyPipeline = []string{"regular", "soa", "flexible_delay"}
+
+// NewResolverStrategies builds a pipeline from strategy names
+func NewResolverStrategies(names []string) (*ResolverStrategies, error) {
+	strategies := &ResolverStrategies{}
+	for _, name := range names {
+		strategy, ok := Strategies[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown strategy %s", name)
 		}
+		strategies.ResolveFunctions = append(strategies.ResolveFunctions, strategy)
 	}
-	return 0, errors.New("received no rr for regular lookup")
+	return strategies, nil
 }
 
-func TryQuerySOADomain(config *ResolverConfiguration, domain *Domain) (uint, error) {
-	resolver := dnsresolver.NewResolver(config.ResolverIp)
-	resolver.Targets(domain.Record_name).Types(dnsresolver.QueryType(domain.RecordType()))
+func TryQueryRegularDomain(config *ResolverConfiguration, target *Target, domain *Domain) (uint, error) {
+	probed, probe_hit := false, false
+	if target.CacheProbe {
+		if hit, err := domain.ProbeCache(target); err == nil {
+			probed, probe_hit = true, hit
+		}
+	}
+	m, err := target.NewDomainQuery(domain)
+	if err != nil {
+		return 0, err
+	}
+	r, rtt, err := domain.Exchange(target, m)
+	if err != nil {
+		return 0, err
+	}
+	domain.TrackDnssec(target, r)
+	domain.TrackEcs(target, r)
+	domain.TrackCache(target, r, rtt, probed, probe_hit)
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		ttl_resolved := uint(rr.Header().Ttl)
+		// don't wait for the refresh beyond the lifetime of the signatures
+		if sig_ttl, ok := domain.RrsigSecondsLeft(target); ok && sig_ttl < ttl_resolved {
+			ttl_resolved = sig_ttl
+		}
+		if ttl_resolved < config.PinMinTtl {
+			return config.PinMinTtl, nil
+		} else {
+			return ttl_resolved, nil
+		}
+	}
+	return 0, fmt.Errorf("received no rr for regular lookup (rcode %s)", dns.RcodeToString[r.Rcode])
+}
 
+func TryQuerySOADomain(config *ResolverConfiguration, target *Target, domain *Domain) (uint, error) {
 	domain_split := strings.Split(domain.Record_name, ".")
 	if len(domain_split) < 2 {
 		return 0, errors.New("received invalid soa domain")
 	}
 	soa_domain := strings.Join(strings.Split(domain.Record_name, ".")[1:], ".")
-	resolver.Targets(soa_domain).Types(dnsresolver.QueryType(dns.TypeSOA))
-	res := resolver.Lookup()
-	for target := range res.ResMap {
-		for _, r := range res.ResMap[target] {
-			ttl_resolved := uint(r.Ttl.Seconds())
-			pc, _, _, _ := runtime.Caller(0)
-			f := runtime.FuncForPC(pc)
-			domainsResolvedByStrategy.With(prometheus.Labels{"strategy": f.Name()}).Inc()
-			if ttl_resolved < config.PinMinTtl {
-				return config.PinMinTtl, nil
-			} else {
-				return uint(r.Ttl.Seconds()), nil
-			}
+	r, _, err := domain.Exchange(target, target.NewQuery(soa_domain, dns.TypeSOA))
+	if err != nil {
+		return 0, err
+	}
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		ttl_resolved := uint(rr.Header().Ttl)
+		if ttl_resolved < config.PinMinTtl {
+			return config.PinMinTtl, nil
+		} else {
+			return ttl_resolved, nil
 		}
 	}
-	return 0, errors.New("received no rr for soa lookup")
+	return 0, fmt.Errorf("received no rr for soa lookup (rcode %s)", dns.RcodeToString[r.Rcode])
 }
 
-func TryQueryFlexibleDelayDomain(config *ResolverConfiguration, domain *Domain) (uint, error) {
-	pc, _, _, _ := runtime.Caller(0)
-	f := runtime.FuncForPC(pc)
-	domainsResolvedByStrategy.With(prometheus.Labels{"strategy": f.Name()}).Inc()
+func TryQueryFlexibleDelayDomain(config *ResolverConfiguration, target *Target, domain *Domain) (uint, error) {
 	return uint(rand.Intn(int(config.FlexibleDelayMaxTtlSeconds-config.FlexibleDelayMinTtlSeconds)) + int(config.FlexibleDelayMinTtlSeconds)), nil
 }
 
-func TryQueryStaticDelayDomain(config *ResolverConfiguration, domain *Domain) (uint, error) {
-	pc, _, _, _ := runtime.Caller(0)
-	f := runtime.FuncForPC(pc)
-	domainsResolvedByStrategy.With(prometheus.Labels{"strategy": f.Name()}).Inc()
+func TryQueryStaticDelayDomain(config *ResolverConfiguration, target *Target, domain *Domain) (uint, error) {
 	return uint(config.StaticDelaySeconds), nil
 }
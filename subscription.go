@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SubscriptionSourceFile = "file"
+	SubscriptionSourceApi  = "api"
+)
+
+// Outcomes of a learned name, see LearnResult
+const (
+	LearnEnrolled  = "enrolled"
+	LearnQueued    = "queued"
+	LearnUnmatched = "unmatched"
+	LearnInvalid   = "invalid"
+	LearnExcluded  = "excluded"
+)
+
+var learnedNames = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "syringe",
+	Name:      "learned_names_total",
+	Help:      "The total number of learned names by result (enrolled, queued, unmatched, invalid, excluded)",
+},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.Register(learnedNames)
+}
+
+// SubscriptionDefinition - a rule enrolling learned names below a suffix
+type SubscriptionDefinition struct {
+	// Pattern - '*.corp.example' matches names below corp.example, '.corp.example' also corp.example itself
+	Pattern string   `json:"pattern" example:"*.corp.example"`
+	Types   []string `json:"types" example:"A,AAAA"`
+	// Group - defaults to the default group, whose client subnets apply if no subnets are given
+	Group   string   `json:"group,omitempty" example:"corp"`
+	Subnets []string `json:"subnets,omitempty" example:"192.0.2.0/24"`
+}
+
+// Subscription - a rule which does not query on its own, but enrolls the
+// learned names matching its pattern with its types and group
+type Subscription struct {
+	Id string `json:"id" example:"subscription-1"`
+	SubscriptionDefinition
+	// Source - file (DomainsFile) or api
+	Source string `json:"source" example:"file"`
+	// Enrolled - the number of names enrolled by the rule
+	Enrolled     int   `json:"enrolled" example:"42"`
+	LastEnrolled int64 `json:"last_enrolled,omitempty" example:"1700000000000"`
+	suffix       string
+	// self - the suffix itself matches, too
+	self bool
+}
+
+// IsSubscriptionEntry returns whether a domains file entry is a subscription
+func IsSubscriptionEntry(entry string) bool {
+	return strings.HasPrefix(entry, "*.") || strings.HasPrefix(entry, ".")
+}
+
+// ParseSubscriptionEntry parses a domains file entry
+// '*.<suffix> <rr type>[,<rr type>...] [ecs=<subnet>,...] [group=<name>]'
+func ParseSubscriptionEntry(entry string) (SubscriptionDefinition, error) {
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return SubscriptionDefinition{}, fmt.Errorf("malformed subscription entry '%s', syntax='*.<suffix> <rr type>[,<rr type>...] [ecs=<subnet>,...] [group=<name>]'", entry)
+	}
+	definition := SubscriptionDefinition{Pattern: fields[0], Types: strings.Split(fields[1], ",")}
+	for _, option := range fields[2:] {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "ecs":
+			definition.Subnets = strings.Split(value, ",")
+		case "group":
+			definition.Group = value
+		default:
+			return definition, fmt.Errorf("unknown option '%s' in subscription entry '%s'", key, entry)
+		}
+	}
+	return definition, nil
+}
+
+// Subscriptions - the subscription rules by suffix
+type Subscriptions struct {
+	mu       sync.Mutex
+	seq      int
+	rules    []*Subscription
+	bySuffix map[string][]*Subscription
+}
+
+var subscriptions = &Subscriptions{bySuffix: make(map[string][]*Subscription)}
+
+// Add validates and adds a rule
+func (subs *Subscriptions) Add(definition SubscriptionDefinition, source string) (Subscription, error) {
+	rule := &Subscription{SubscriptionDefinition: definition, Source: source}
+	pattern, wildcard := strings.CutPrefix(definition.Pattern, "*.")
+	if !wildcard {
+		var ok bool
+		if pattern, ok = strings.CutPrefix(definition.Pattern, "."); !ok {
+			return Subscription{}, fmt.Errorf("pattern %s must start with '*.' or '.'", definition.Pattern)
+		}
+	}
+	rule.self = !wildcard
+	suffix, err := NormalizeDomainName(pattern)
+	if err != nil || suffix == "." {
+		return Subscription{}, fmt.Errorf("invalid pattern %s: %v", definition.Pattern, err)
+	}
+	rule.suffix = suffix
+	if len(definition.Types) == 0 {
+		return Subscription{}, fmt.Errorf("no types given for pattern %s", definition.Pattern)
+	}
+	rule.Types = make([]string, len(definition.Types))
+	for i, rr_type := range definition.Types {
+		rule.Types[i] = strings.ToUpper(rr_type)
+		if _, ok := dns.StringToType[rule.Types[i]]; !ok {
+			return Subscription{}, fmt.Errorf("unknown type %s for pattern %s", rr_type, definition.Pattern)
+		}
+	}
+	group, err := FindGroup(definition.Group)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if len(rule.Subnets) == 0 {
+		rule.Subnets = group.ClientSubnets
+	}
+	for _, subnet := range rule.Subnets {
+		if err := ValidateClientSubnet(subnet); err != nil {
+			return Subscription{}, err
+		}
+	}
+
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	subs.seq++
+	rule.Id = fmt.Sprintf("subscription-%d", subs.seq)
+	subs.rules = append(subs.rules, rule)
+	subs.bySuffix[rule.suffix] = append(subs.bySuffix[rule.suffix], rule)
+	log.Info("added subscription ", rule.Id, " ", rule.Pattern, " ", strings.Join(rule.Types, ","))
+	return *rule, nil
+}
+
+// Remove removes a rule, the names it enrolled stay queued
+func (subs *Subscriptions) Remove(id string) bool {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	i := slices.IndexFunc(subs.rules, func(rule *Subscription) bool { return rule.Id == id })
+	if i < 0 {
+		return false
+	}
+	rule := subs.rules[i]
+	subs.rules = slices.Delete(subs.rules, i, i+1)
+	subs.bySuffix[rule.suffix] = slices.DeleteFunc(subs.bySuffix[rule.suffix], func(r *Subscription) bool { return r == rule })
+	if len(subs.bySuffix[rule.suffix]) == 0 {
+		delete(subs.bySuffix, rule.suffix)
+	}
+	return true
+}
+
+// List returns the rules in the order they were added
+func (subs *Subscriptions) List() []Subscription {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	list := make([]Subscription, 0, len(subs.rules))
+	for _, rule := range subs.rules {
+		list = append(list, *rule)
+	}
+	return list
+}
+
+// match returns the rules matching a normalized name
+func (subs *Subscriptions) match(name string) []*Subscription {
+	var rules []*Subscription
+	for _, rule := range subs.bySuffix[name] {
+		if rule.self {
+			rules = append(rules, rule)
+		}
+	}
+	for parent := name; ; {
+		_, rest, found := strings.Cut(parent, ".")
+		if !found {
+			break
+		}
+		parent = rest
+		rules = append(rules, subs.bySuffix[parent]...)
+	}
+	return rules
+}
+
+// LearnResult - the number of learned names by outcome
+type LearnResult struct {
+	// Enrolled - names for which a matching rule queued at least one entry
+	Enrolled int `json:"enrolled" example:"12"`
+	// Queued - names matching a rule whose entries were all queued already
+	Queued    int `json:"queued" example:"300"`
+	Unmatched int `json:"unmatched" example:"5000"`
+	Invalid   int `json:"invalid" example:"3"`
+	// Excluded - names matching an exclusion rule
+	Excluded int `json:"excluded" example:"40"`
+}
+
+// Learn enrolls the names matching a rule with the types, group and subnets
+// of the rule. Entries which are queued already are skipped, removed entries
+// are enrolled again once their name is learned again.
+func (dh *DomainHeap) Learn(names []string) LearnResult {
+	var result LearnResult
+	// enrolling - the entries enrolled by this call, they are queued below
+	enrolling := make(map[string]bool)
+	var enroll []Domain
+
+	subscriptions.mu.Lock()
+	defer subscriptions.mu.Unlock()
+	for _, learned := range names {
+		name, err := NormalizeDomainName(learned)
+		if err != nil {
+			log.Debug("ignoring learned name: ", err)
+			result.Invalid++
+			continue
+		}
+		rules := subscriptions.match(name)
+		if len(rules) == 0 {
+			result.Unmatched++
+			continue
+		}
+		if CheckExcluded(name) != nil {
+			result.Excluded++
+			continue
+		}
+		enrolled := false
+		for _, rule := range rules {
+			added := false
+			for _, rr_type := range rule.Types {
+				for _, d := range ExpandClientSubnets(Domain{Record_name: name, Record_type: rr_type, Group: rule.Group}, rule.Subnets) {
+					if enrolling[d.Key()] || IsQueued(d.Key()) {
+						continue
+					}
+					enrolling[d.Key()] = true
+					enroll = append(enroll, d)
+					added = true
+				}
+			}
+			if added {
+				enrolled = true
+				rule.Enrolled++
+				rule.LastEnrolled = time.Now().UnixMilli()
+			}
+		}
+		if enrolled {
+			result.Enrolled++
+		} else {
+			result.Queued++
+		}
+	}
+
+	// queued while holding the lock, a concurrent call sees them as queued
+	for _, d := range enroll {
+		dh.AddDomain(d)
+	}
+	learnedNames.With(prometheus.Labels{"result": LearnEnrolled}).Add(float64(result.Enrolled))
+	learnedNames.With(prometheus.Labels{"result": LearnQueued}).Add(float64(result.Queued))
+	learnedNames.With(prometheus.Labels{"result": LearnUnmatched}).Add(float64(result.Unmatched))
+	learnedNames.With(prometheus.Labels{"result": LearnInvalid}).Add(float64(result.Invalid))
+	learnedNames.With(prometheus.Labels{"result": LearnExcluded}).Add(float64(result.Excluded))
+	return result
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// withSubscriptions starts the test without subscription rules
+func withSubscriptions(t *testing.T, definitions ...SubscriptionDefinition) {
+	t.Helper()
+	previous := subscriptions
+	subscriptions = &Subscriptions{bySuffix: make(map[string][]*Subscription)}
+	t.Cleanup(func() { subscriptions = previous })
+	for _, definition := range definitions {
+		if _, err := subscriptions.Add(definition, SubscriptionSourceApi); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// withExclusions replaces the exclusion rules for the test
+func withExclusions(t *testing.T, patterns ...string) {
+	t.Helper()
+	config := *resolverConfiguration
+	config.Exclusions = patterns
+	config.ExclusionFiles = nil
+	list, err := SetupExclusions(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := exclusions
+	exclusions = list
+	t.Cleanup(func() { exclusions = previous })
+}
+
+func TestParseSubscriptionEntry(t *testing.T) {
+	definition, err := ParseSubscriptionEntry("*.corp.example A,AAAA ecs=192.0.2.0/24 group=corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition.Pattern != "*.corp.example" || len(definition.Types) != 2 || len(definition.Subnets) != 1 || definition.Group != "corp" {
+		t.Errorf("ParseSubscriptionEntry = %+v", definition)
+	}
+	for _, entry := range []string{"*.corp.example", "*.corp.example A ttl=5"} {
+		if _, err := ParseSubscriptionEntry(entry); err == nil {
+			t.Errorf("ParseSubscriptionEntry(%s) accepted an invalid entry", entry)
+		}
+	}
+}
+
+func TestSubscriptionsAddRejectsInvalidRules(t *testing.T) {
+	withSubscriptions(t)
+	for _, definition := range []SubscriptionDefinition{
+		{Pattern: "corp.example", Types: []string{"A"}},
+		{Pattern: "*.", Types: []string{"A"}},
+		{Pattern: "*.corp.example"},
+		{Pattern: "*.corp.example", Types: []string{"AAA"}},
+		{Pattern: "*.corp.example", Types: []string{"A"}, Group: "unknown"},
+		{Pattern: "*.corp.example", Types: []string{"A"}, Subnets: []string{"192.0.2.1"}},
+	} {
+		if _, err := subscriptions.Add(definition, SubscriptionSourceApi); err == nil {
+			t.Errorf("Add(%+v) accepted an invalid rule", definition)
+		}
+	}
+	if len(subscriptions.List()) != 0 {
+		t.Errorf("rules = %+v, want none", subscriptions.List())
+	}
+}
+
+func TestLearn(t *testing.T) {
+	resetQueue(t)
+	withExclusions(t, "*.lab.corp.example")
+	withSubscriptions(t,
+		SubscriptionDefinition{Pattern: "*.corp.example", Types: []string{"A", "AAAA"}},
+		SubscriptionDefinition{Pattern: ".shop.example", Types: []string{"A"}},
+	)
+
+	result := dh.Learn([]string{"Host1.Corp.Example", "corp.example", "shop.example", "www.other.example", "in valid", "printer.lab.corp.example"})
+	if result != (LearnResult{Enrolled: 2, Unmatched: 2, Invalid: 1, Excluded: 1}) {
+		t.Errorf("Learn = %+v", result)
+	}
+	want := []string{"default host1.corp.example IN A", "default host1.corp.example IN AAAA", "default shop.example IN A"}
+	if keys := queuedKeyList(); !slices.Equal(keys, want) {
+		t.Errorf("queue = %v, want %v", keys, want)
+	}
+	if rules := subscriptions.List(); rules[0].Enrolled != 1 || rules[0].LastEnrolled == 0 || rules[1].Enrolled != 1 {
+		t.Errorf("rules = %+v, want one enrolled name each", rules)
+	}
+
+	// names learned twice, within a call or again, are queued once
+	if result := dh.Learn([]string{"host1.corp.example", "host2.corp.example", "host2.corp.example"}); result.Enrolled != 1 || result.Queued != 2 {
+		t.Errorf("Learn of queued names = %+v, want 1 enrolled and 2 queued", result)
+	}
+	if keys := queuedKeyList(); len(keys) != 5 {
+		t.Errorf("queue = %v, want 5 entries", keys)
+	}
+}
+
+func TestLearnEnrollsRemovedNamesAgain(t *testing.T) {
+	resetQueue(t)
+	withSubscriptions(t, SubscriptionDefinition{Pattern: "*.corp.example", Types: []string{"A"}})
+	dh.Learn([]string{"host1.corp.example"})
+
+	dh.RemoveDomains(func(d *Domain) bool { return d.Record_name == "host1.corp.example" })
+	if keys := queuedKeyList(); len(keys) != 0 {
+		t.Fatalf("queue = %v after the removal", keys)
+	}
+	if result := dh.Learn([]string{"host1.corp.example"}); result.Enrolled != 1 {
+		t.Errorf("Learn of a removed name = %+v, want it enrolled again", result)
+	}
+	if keys := queuedKeyList(); len(keys) != 1 {
+		t.Errorf("queue = %v, want the enrolled name", keys)
+	}
+}
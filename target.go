@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	queriesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "queries_sent_total",
+		Help:      "The total number of queries sent to a target by group and qtype, retries included",
+	},
+		[]string{"target", "group", "qtype"},
+	)
+	queriesAnswered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "syringe",
+		Name:      "queries_answered_total",
+		Help:      "The total number of responses of a target by group, qtype and rcode",
+	},
+		[]string{"target", "group", "qtype", "rcode"},
+	)
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "syringe",
+		Name:      "query_duration_seconds",
+		Help:      "The round trip time of the answered queries of a target",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	},
+		[]string{"target"},
+	)
+)
+
+func init() {
+	prometheus.Register(queriesSent)
+	prometheus.Register(queriesAnswered)
+	prometheus.Register(queryDuration)
+}
+
+// TargetConfiguration - a resolver to which preheat queries are sent
+type TargetConfiguration struct {
+	Id      string `yaml:"Id"`
+	Address string `yaml:"Address"`
+	Dnssec  bool   `yaml:"Dnssec"`
+	// tls settings for tls://, https:// and quic:// targets
+	CaFile         string `yaml:"CaFile"`
+	ServerName     string `yaml:"ServerName"`
+	ClientCertFile string `yaml:"ClientCertFile"`
+	ClientKeyFile  string `yaml:"ClientKeyFile"`
+	// CacheProbe - send a non-recursive query before each refresh to check whether the answer is cached
+	CacheProbe bool `yaml:"CacheProbe"`
+	// CacheHitLatencyMilliseconds - responses faster than this are considered cache hits (defaults to the global value)
+	CacheHitLatencyMilliseconds uint `yaml:"CacheHitLatencyMilliseconds"`
+}
+
+// Target - a configured resolver with its transport
+type Target struct {
+	Id              string
+	Protocol        string
+	Address         string
+	Dnssec          bool
+	CacheProbe      bool
+	CacheHitLatency time.Duration
+	retries         uint
+	transport       Transport
+	cacheWindow     *CacheWindow
+	paused          atomic.Bool
+	inFlight        atomic.Int64
+}
+
+// SetupTargets builds the targets from the configuration. If no targets are
+// configured, a single target named "default" pointing to ResolverIp is used.
+func SetupTargets(config *ResolverConfiguration) []*Target {
+	targetConfigurations := config.Targets
+	if len(targetConfigurations) == 0 {
+		targetConfigurations = []TargetConfiguration{{Id: "default", Address: config.ResolverIp}}
+	}
+
+	var result []*Target
+	for i, tc := range targetConfigurations {
+		if tc.Id == "" {
+			tc.Id = tc.Address
+		}
+		if tc.Address == "" {
+			log.Fatal("target ", i, " (", tc.Id, ") has no address")
+		}
+		target, err := NewTarget(tc, config)
+		if err != nil {
+			log.Fatal("target ", i, " (", tc.Id, "): ", err)
+		}
+		result = append(result, target)
+	}
+	return result
+}
+
+func NewTarget(tc TargetConfiguration, config *ResolverConfiguration) (*Target, error) {
+	protocol, address, err := ParseTargetAddress(tc.Address)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := NewTransport(protocol, address, tc, time.Duration(config.TimeoutMillisecons)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	cacheHitLatency := tc.CacheHitLatencyMilliseconds
+	if cacheHitLatency == 0 {
+		cacheHitLatency = config.CacheHitLatencyMilliseconds
+	}
+	return &Target{
+		Id:              tc.Id,
+		Protocol:        protocol,
+		Address:         address,
+		Dnssec:          tc.Dnssec,
+		CacheProbe:      tc.CacheProbe,
+		CacheHitLatency: time.Duration(cacheHitLatency) * time.Millisecond,
+		retries:         config.RetryTimes,
+		transport:       transport,
+		cacheWindow:     NewCacheWindow(config.CacheHitRatioWindow),
+	}, nil
+}
+
+// CacheHitRatio returns the share of cache hits within the last classified
+// responses and the number of responses it is based on
+func (target *Target) CacheHitRatio() (float64, int) {
+	return target.cacheWindow.Ratio()
+}
+
+// NewQuery builds a recursive query for name/qtype. If the target is
+// dnssec-aware, EDNS0 is added with the DO bit set.
+func (target *Target) NewQuery(name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	if target.Dnssec {
+		m.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	return m
+}
+
+// NewDomainQuery builds the query for domain, including its client subnet
+func (target *Target) NewDomainQuery(domain *Domain) (*dns.Msg, error) {
+	m := target.NewQuery(domain.Record_name, domain.RecordType())
+	if domain.Client_subnet != "" {
+		if err := SetEcs(m, domain.Client_subnet); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Exchange sends m of a group to the target and retries up to RetryTimes on error
+func (target *Target) Exchange(ctx context.Context, group string, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	ctx, span := startExchangeSpan(ctx, target, group, m)
+	var r *dns.Msg
+	var rtt time.Duration
+	var err error
+	qtype := ""
+	if len(m.Question) > 0 {
+		qtype = dns.TypeToString[m.Question[0].Qtype]
+	}
+	for attempt := uint(0); attempt <= target.retries; attempt++ {
+		queriesSent.With(prometheus.Labels{"target": target.Id, "group": group, "qtype": qtype}).Inc()
+		r, rtt, err = target.transport.Exchange(m)
+		if err == nil {
+			queriesAnswered.With(prometheus.Labels{"target": target.Id, "group": group, "qtype": qtype, "rcode": dns.RcodeToString[r.Rcode]}).Inc()
+			queryDuration.With(prometheus.Labels{"target": target.Id}).Observe(rtt.Seconds())
+			span.SetAttributes(attributeRcode.String(dns.RcodeToString[r.Rcode]))
+			endSpan(span, nil)
+			return r, rtt, nil
+		}
+		log.WithContext(ctx).Trace("exchange with target ", target.Id, " failed (attempt ", attempt+1, "): ", err)
+	}
+	endSpan(span, err)
+	return r, rtt, err
+}
+
+// Query is a shorthand for Exchange(NewQuery(name, qtype)) without group
+func (target *Target) Query(name string, qtype uint16) (*dns.Msg, time.Duration, error) {
+	return target.Exchange(context.Background(), "", target.NewQuery(name, qtype))
+}
+
+// Paused returns whether refreshes to the target are paused
+func (target *Target) Paused() bool {
+	return target.paused.Load()
+}
+
+func (target *Target) SetPaused(paused bool) {
+	target.paused.Store(paused)
+	if paused {
+		targetPausedGauge.With(prometheus.Labels{"target": target.Id}).Set(1)
+	} else {
+		targetPausedGauge.With(prometheus.Labels{"target": target.Id}).Set(0)
+	}
+}
+
+// InFlight returns the number of refreshes currently sent to the target
+func (target *Target) InFlight() int64 {
+	return target.inFlight.Load()
+}
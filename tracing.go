@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dns "github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingConfiguration - export the spans of the refreshes via OTLP/HTTP
+type TracingConfiguration struct {
+	// Endpoint - the url of the collector, e.g. http://localhost:4318 (empty=tracing disabled)
+	Endpoint string `yaml:"Endpoint"`
+	// Headers - sent with every export, e.g. for authentication
+	Headers map[string]string `yaml:"Headers"`
+	// SampleRatio - the fraction of the refreshes which are traced (default 1)
+	SampleRatio float64 `yaml:"SampleRatio"`
+	ServiceName string  `yaml:"ServiceName"`
+}
+
+// Span attributes
+const (
+	attributeQname     = attribute.Key("dns.qname")
+	attributeQtype     = attribute.Key("dns.qtype")
+	attributeRcode     = attribute.Key("dns.rcode")
+	attributeTarget    = attribute.Key("syringe.target")
+	attributeGroup     = attribute.Key("syringe.group")
+	attributeStrategy  = attribute.Key("syringe.strategy")
+	attributeRefreshAt = attribute.Key("syringe.refresh_at")
+)
+
+// tracer - a no-op tracer unless tracing is set up
+var tracer = otel.Tracer("github.com/TCMPK/syringe")
+
+// tracerProvider - exports the batched spans, nil unless tracing is set up
+var tracerProvider *sdktrace.TracerProvider
+
+// tracingShutdownTimeout - how long to wait for the export of the remaining spans on exit
+const tracingShutdownTimeout = 5 * time.Second
+
+// SetupTracing installs the OTLP exporter and the sampler, and adds the
+// trace and span id of the context of a log entry to its fields
+func SetupTracing(config TracingConfiguration) error {
+	if config.Endpoint == "" {
+		return nil
+	}
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return fmt.Errorf("tracing SampleRatio %f is not between 0 and 1", config.SampleRatio)
+	}
+	if config.SampleRatio == 0 {
+		config.SampleRatio = 1
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "syringe"
+	}
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(config.Endpoint),
+		otlptracehttp.WithHeaders(config.Headers),
+	)
+	if err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(config.ServiceName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	tracerProvider = provider
+	tracer = provider.Tracer("github.com/TCMPK/syringe")
+	// log.Fatal exits without returning to main, export the spans first
+	log.RegisterExitHandler(func() { ShutdownTracing(tracingShutdownTimeout) })
+	log.AddHook(traceLogHook{})
+	log.Info("exporting traces to ", config.Endpoint, " (sample ratio ", config.SampleRatio, ")")
+	return nil
+}
+
+// ShutdownTracing exports the spans which are not exported yet and stops
+// the exporter, waiting at most timeout for the collector
+func ShutdownTracing(timeout time.Duration) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	return nil
+}
+
+// traceLogHook - adds trace_id and span_id to entries logged WithContext
+type traceLogHook struct{}
+
+func (traceLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (traceLogHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	span := trace.SpanContextFromContext(entry.Context)
+	if span.IsValid() {
+		entry.Data["trace_id"] = span.TraceID().String()
+		entry.Data["span_id"] = span.SpanID().String()
+	}
+	return nil
+}
+
+// traceContext returns the context of the refresh of the domain
+func (domain *Domain) traceContext() context.Context {
+	if domain.ctx == nil {
+		return context.Background()
+	}
+	return domain.ctx
+}
+
+// startExchangeSpan starts the span of a query sent to a target
+func startExchangeSpan(ctx context.Context, target *Target, group string, m *dns.Msg) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{attributeTarget.String(target.Id), attributeGroup.String(group)}
+	if len(m.Question) > 0 {
+		attributes = append(attributes,
+			attributeQname.String(m.Question[0].Name),
+			attributeQtype.String(dns.TypeToString[m.Question[0].Qtype]),
+		)
+	}
+	return tracer.Start(ctx, "dns.exchange", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+// endSpan records err as status of the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
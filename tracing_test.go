@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// withLogHooks restores the hooks and the level of the standard logger after the test
+func withLogHooks(t *testing.T) {
+	logger := log.StandardLogger()
+	hooks, level := maps.Clone(logger.Hooks), logger.GetLevel()
+	t.Cleanup(func() {
+		logger.ReplaceHooks(hooks)
+		logger.SetLevel(level)
+	})
+}
+
+// withTracing sets up tracing with the endpoint for the test
+func withTracing(t *testing.T, config TracingConfiguration) {
+	withLogHooks(t)
+	previousTracer, previousProvider, previousGlobal := tracer, tracerProvider, otel.GetTracerProvider()
+	t.Cleanup(func() {
+		ShutdownTracing(tracingShutdownTimeout)
+		tracer, tracerProvider = previousTracer, previousProvider
+		otel.SetTracerProvider(previousGlobal)
+	})
+	if err := SetupTracing(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownTracingExportsTheRemainingSpans(t *testing.T) {
+	var exports atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			exports.Add(1)
+		}
+	}))
+	defer collector.Close()
+	withTracing(t, TracingConfiguration{Endpoint: collector.URL})
+
+	_, span := tracer.Start(context.Background(), "refresh")
+	span.End()
+	// the batch is not due yet, the shutdown exports it
+	if err := ShutdownTracing(tracingShutdownTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if exports.Load() != 1 {
+		t.Errorf("%d exports after the shutdown, want 1", exports.Load())
+	}
+	if err := ShutdownTracing(tracingShutdownTimeout); err != nil {
+		t.Errorf("second shutdown: %v", err)
+	}
+}
+
+func TestShutdownTracingWithoutTracing(t *testing.T) {
+	if err := ShutdownTracing(tracingShutdownTimeout); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshLogsCarryTheTraceId(t *testing.T) {
+	withLogHooks(t)
+	log.SetLevel(log.DebugLevel)
+	log.AddHook(traceLogHook{})
+	entries := logtest.NewGlobal()
+
+	span := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01, 0x02},
+		SpanID:     trace.SpanID{0x03},
+		TraceFlags: trace.FlagsSampled,
+	})
+	domain := &Domain{Record_name: "example.com", Record_type: "A"}
+	domain.ctx = trace.ContextWithSpanContext(context.Background(), span)
+	differ := newTestDiffer(t, answer(t, "example.com. 300 IN A 192.0.2.99"))
+	differ.Compare(domain, &Group{Name: "diff", limiter: NewQueryLimiter(0)})
+
+	entry := entries.LastEntry()
+	if entry == nil {
+		t.Fatal("the mismatch was not logged")
+	}
+	if entry.Data["trace_id"] != span.TraceID().String() || entry.Data["span_id"] != span.SpanID().String() {
+		t.Errorf("log entry %q has fields %v, want the trace and span id of the refresh", entry.Message, entry.Data)
+	}
+}
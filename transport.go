@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	ProtocolUdp   = "udp"
+	ProtocolTcp   = "tcp"
+	ProtocolTls   = "tls"
+	ProtocolHttps = "https"
+	ProtocolQuic  = "quic"
+
+	// maximum number of idle tcp/tls connections kept per target
+	maxIdleConnections = 8
+)
+
+// Transport - sends a single dns message to a target
+type Transport interface {
+	Exchange(m *dns.Msg) (*dns.Msg, time.Duration, error)
+	Close()
+}
+
+// ParseTargetAddress splits a target address into protocol and address.
+// Plain addresses ('10.0.0.5', '10.0.0.5:5353') use udp, otherwise the url
+// scheme selects the protocol: udp://, tcp://, tls://, https:// or quic://.
+// For https the full url is returned as address.
+func ParseTargetAddress(address string) (string, string, error) {
+	if !strings.Contains(address, "://") {
+		return ProtocolUdp, withDefaultPort(address, "53"), nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case ProtocolUdp, ProtocolTcp:
+		return u.Scheme, withDefaultPort(u.Host, "53"), nil
+	case ProtocolTls, ProtocolQuic:
+		return u.Scheme, withDefaultPort(u.Host, "853"), nil
+	case ProtocolHttps:
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		return u.Scheme, u.String(), nil
+	}
+	return "", "", fmt.Errorf("unsupported protocol %s in target address %s", u.Scheme, address)
+}
+
+func withDefaultPort(address string, port string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(strings.Trim(address, "[]"), port)
+	}
+	return address
+}
+
+// NewTransport creates the transport for the protocol of a target
+func NewTransport(protocol string, address string, tc TargetConfiguration, timeout time.Duration) (Transport, error) {
+	switch protocol {
+	case ProtocolUdp:
+		return &dnsTransport{
+			address: address,
+			client:  &dns.Client{Net: "udp", UDPSize: dns.DefaultMsgSize, Timeout: timeout},
+			tcp:     &dns.Client{Net: "tcp", Timeout: timeout},
+		}, nil
+	case ProtocolTcp:
+		return newStreamTransport(address, &dns.Client{Net: "tcp", Timeout: timeout}), nil
+	case ProtocolTls:
+		tlsConfig, err := tc.TlsConfig(address)
+		if err != nil {
+			return nil, err
+		}
+		return newStreamTransport(address, &dns.Client{Net: "tcp-tls", Timeout: timeout, TLSConfig: tlsConfig}), nil
+	case ProtocolHttps:
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := tc.TlsConfig(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return &httpsTransport{
+			url: address,
+			client: &http.Client{
+				Timeout: timeout,
+				Transport: &http.Transport{
+					TLSClientConfig:     tlsConfig,
+					ForceAttemptHTTP2:   true,
+					MaxIdleConnsPerHost: maxIdleConnections,
+					IdleConnTimeout:     90 * time.Second,
+				},
+			},
+		}, nil
+	case ProtocolQuic:
+		tlsConfig, err := tc.TlsConfig(address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.NextProtos = []string{"doq"}
+		return &quicTransport{address: address, tlsConfig: tlsConfig, timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("unsupported protocol %s", protocol)
+}
+
+// TlsConfig builds the client tls configuration of the target. The server
+// name defaults to the host of address.
+func (tc TargetConfiguration) TlsConfig(address string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if tc.ServerName != "" {
+		config.ServerName = tc.ServerName
+	}
+	if tc.CaFile != "" {
+		pem, err := os.ReadFile(tc.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CaFile %s", tc.CaFile)
+		}
+		config.RootCAs = pool
+	}
+	if tc.ClientCertFile != "" || tc.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.ClientCertFile, tc.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// dnsTransport - plain udp, a new socket per query. Truncated responses are
+// retried over tcp.
+type dnsTransport struct {
+	address string
+	client  *dns.Client
+	tcp     *dns.Client
+}
+
+func (t *dnsTransport) Exchange(m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	r, rtt, err := t.client.Exchange(m, t.address)
+	if err != nil || !r.Truncated {
+		return r, rtt, err
+	}
+	// large answers, e.g. signed ones, don't fit into a udp response (RFC 7766 5)
+	r, tcp_rtt, err := t.tcp.Exchange(m, t.address)
+	return r, rtt + tcp_rtt, err
+}
+
+func (t *dnsTransport) Close() {}
+
+// streamTransport - tcp and tls, keeps a pool of idle connections
+type streamTransport struct {
+	address string
+	client  *dns.Client
+	idle    chan *dns.Conn
+}
+
+func newStreamTransport(address string, client *dns.Client) *streamTransport {
+	return &streamTransport{
+		address: address,
+		client:  client,
+		idle:    make(chan *dns.Conn, maxIdleConnections),
+	}
+}
+
+func (t *streamTransport) Exchange(m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	var conn *dns.Conn
+	reused := false
+	select {
+	case conn = <-t.idle:
+		reused = true
+	default:
+	}
+	if conn == nil {
+		var err error
+		conn, err = t.client.Dial(t.address)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	r, rtt, err := t.client.ExchangeWithConn(m, conn)
+	if err != nil {
+		conn.Close()
+		if reused {
+			// the server may have closed the idle connection, retry on a fresh one
+			return t.Exchange(m)
+		}
+		return nil, rtt, err
+	}
+
+	select {
+	case t.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return r, rtt, nil
+}
+
+func (t *streamTransport) Close() {
+	for {
+		select {
+		case conn := <-t.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// httpsTransport - dns over https (RFC 8484), the http client reuses connections
+type httpsTransport struct {
+	url    string
+	client *http.Client
+}
+
+func (t *httpsTransport) Exchange(m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	// use id 0 for cache friendliness (RFC 8484 4.1)
+	query := m.Copy()
+	query.Id = 0
+	buf, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, rtt, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, rtt, fmt.Errorf("doh request to %s failed with status %s", t.url, resp.Status)
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, rtt, err
+	}
+	r.Id = m.Id
+	return r, rtt, nil
+}
+
+func (t *httpsTransport) Close() {
+	t.client.CloseIdleConnections()
+}
+
+// quicTransport - dns over quic (RFC 9250), one stream per query on a shared connection
+type quicTransport struct {
+	address   string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	mu        sync.Mutex
+	conn      quic.Connection
+}
+
+func (t *quicTransport) connection(ctx context.Context) (quic.Connection, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil && t.conn.Context().Err() == nil {
+		return t.conn, nil
+	}
+	conn, err := quic.DialAddr(ctx, t.address, t.tlsConfig, &quic.Config{
+		KeepAlivePeriod: t.timeout,
+		MaxIdleTimeout:  4 * t.timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+func (t *quicTransport) Exchange(m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	// the message id must be 0 (RFC 9250 4.2.1)
+	query := m.Copy()
+	query.Id = 0
+	buf, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	conn, err := t.connection(ctx)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(buf)))
+	if _, err := stream.Write(append(length, buf...)); err != nil {
+		stream.CancelRead(0)
+		return nil, time.Since(start), err
+	}
+	// signal the end of the query by closing the sending direction
+	stream.Close()
+
+	if _, err := io.ReadFull(stream, length); err != nil {
+		return nil, time.Since(start), err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, time.Since(start), err
+	}
+	rtt := time.Since(start)
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, rtt, err
+	}
+	r.Id = m.Id
+	return r, rtt, nil
+}
+
+func (t *quicTransport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		t.conn.CloseWithError(0, "")
+		t.conn = nil
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+func TestUdpTransportRetriesTruncatedResponsesOverTcp(t *testing.T) {
+	address := startDnsServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if w.LocalAddr().Network() == "udp" {
+			m.Truncated = true
+		} else {
+			m.Answer = append(m.Answer, mustRR(t, "example.com. 300 IN A 192.0.2.1"))
+		}
+		w.WriteMsg(m)
+	})
+	target := newTestTarget(t, TargetConfiguration{Address: address, Dnssec: true})
+
+	r, _, err := target.transport.Exchange(target.NewQuery("example.com", dns.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Truncated || len(r.Answer) != 1 {
+		t.Errorf("response truncated=%v with %d answers, want the complete tcp response", r.Truncated, len(r.Answer))
+	}
+}
+
+func TestParseTargetAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		protocol string
+		want     string
+	}{
+		{"10.0.0.5", ProtocolUdp, "10.0.0.5:53"},
+		{"10.0.0.5:5353", ProtocolUdp, "10.0.0.5:5353"},
+		{"2001:db8::1", ProtocolUdp, "[2001:db8::1]:53"},
+		{"tcp://10.0.0.5", ProtocolTcp, "10.0.0.5:53"},
+		{"tls://dns.example", ProtocolTls, "dns.example:853"},
+		{"quic://[2001:db8::1]:8853", ProtocolQuic, "[2001:db8::1]:8853"},
+		{"https://dns.example", ProtocolHttps, "https://dns.example/dns-query"},
+		{"https://dns.example/resolve", ProtocolHttps, "https://dns.example/resolve"},
+	}
+	for _, test := range tests {
+		protocol, address, err := ParseTargetAddress(test.address)
+		if err != nil {
+			t.Errorf("ParseTargetAddress(%s) = %v", test.address, err)
+			continue
+		}
+		if protocol != test.protocol || address != test.want {
+			t.Errorf("ParseTargetAddress(%s) = %s %s, want %s %s", test.address, protocol, address, test.protocol, test.want)
+		}
+	}
+	if _, _, err := ParseTargetAddress("sctp://10.0.0.5"); err == nil {
+		t.Error("an unsupported protocol was accepted")
+	}
+}
+
+// reply answers r with an A record
+func reply(t *testing.T, r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = append(m.Answer, mustRR(t, r.Question[0].Name+" 300 IN A 192.0.2.1"))
+	return m
+}
+
+// exchangeA sends an A query for example.com through the transport of target
+func exchangeA(t *testing.T, target *Target) {
+	t.Helper()
+	m := target.NewQuery("example.com", dns.TypeA)
+	r, _, err := target.transport.Exchange(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Id != m.Id || len(r.Answer) != 1 {
+		t.Fatalf("response id %d with %d answers, want id %d with 1 answer", r.Id, len(r.Answer), m.Id)
+	}
+}
+
+// countingListener counts the accepted connections
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestTcpTransportReusesConnections(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &countingListener{Listener: tcp}
+	serve(t, &dns.Server{
+		Listener:    listener,
+		Handler:     dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) { w.WriteMsg(reply(t, r)) }),
+		IdleTimeout: func() time.Duration { return 200 * time.Millisecond },
+	})
+	target := newTestTarget(t, TargetConfiguration{Address: "tcp://" + listener.Addr().String()})
+
+	exchangeA(t, target)
+	exchangeA(t, target)
+	if accepted := listener.accepted.Load(); accepted != 1 {
+		t.Errorf("%d connections for two queries, want 1", accepted)
+	}
+	// the server closes the idle connection, the transport redials
+	time.Sleep(400 * time.Millisecond)
+	exchangeA(t, target)
+	if accepted := listener.accepted.Load(); accepted != 2 {
+		t.Errorf("%d connections after the idle one was closed, want 2", accepted)
+	}
+}
+
+func TestTlsTransport(t *testing.T) {
+	pki := newTestPki(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", pki.ServerConfig(t, "dns.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, &dns.Server{
+		Listener: listener,
+		Net:      "tcp-tls",
+		Handler:  dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) { w.WriteMsg(reply(t, r)) }),
+	})
+	address := "tls://" + listener.Addr().String()
+
+	exchangeA(t, newTestTarget(t, TargetConfiguration{Address: address, CaFile: pki.CaFile}))
+
+	untrusted := newTestTarget(t, TargetConfiguration{Address: address})
+	if _, _, err := untrusted.transport.Exchange(untrusted.NewQuery("example.com", dns.TypeA)); err == nil {
+		t.Error("the certificate of the server was accepted without its ca")
+	}
+}
+
+func TestHttpsTransport(t *testing.T) {
+	pki := newTestPki(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/dns-query" || req.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "not a dns query", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		r := new(dns.Msg)
+		if err := r.Unpack(body); err != nil || r.Id != 0 {
+			http.Error(w, "malformed dns query", http.StatusBadRequest)
+			return
+		}
+		buf, _ := reply(t, r).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(buf)
+	}))
+	server.TLS = pki.ServerConfig(t, "dns.test")
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	exchangeA(t, newTestTarget(t, TargetConfiguration{Address: server.URL, CaFile: pki.CaFile}))
+
+	failing := newTestTarget(t, TargetConfiguration{Address: server.URL + "/other", CaFile: pki.CaFile})
+	if _, _, err := failing.transport.Exchange(failing.NewQuery("example.com", dns.TypeA)); err == nil {
+		t.Error("an http error status was not reported")
+	}
+}
+
+func TestQuicTransport(t *testing.T) {
+	pki := newTestPki(t)
+	tlsConfig := pki.ServerConfig(t, "dns.test")
+	tlsConfig.NextProtos = []string{"doq"}
+	listener, err := quic.ListenAddr("127.0.0.1:0", tlsConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var accepted atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					length := make([]byte, 2)
+					if _, err := io.ReadFull(stream, length); err != nil {
+						stream.Close()
+						continue
+					}
+					body := make([]byte, binary.BigEndian.Uint16(length))
+					if _, err := io.ReadFull(stream, body); err != nil {
+						stream.Close()
+						continue
+					}
+					r := new(dns.Msg)
+					if err := r.Unpack(body); err != nil || r.Id != 0 {
+						stream.Close()
+						continue
+					}
+					buf, _ := reply(t, r).Pack()
+					binary.BigEndian.PutUint16(length, uint16(len(buf)))
+					stream.Write(append(length, buf...))
+					stream.Close()
+				}
+			}()
+		}
+	}()
+	target := newTestTarget(t, TargetConfiguration{Address: "quic://" + listener.Addr().String(), CaFile: pki.CaFile})
+
+	exchangeA(t, target)
+	exchangeA(t, target)
+	if accepted.Load() != 1 {
+		t.Errorf("%d connections for two queries, want 1", accepted.Load())
+	}
+}
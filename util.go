@@ -4,8 +4,12 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func SetStructMemberFromEnvVariables(c *ResolverConfiguration) {
@@ -55,3 +59,17 @@ func ConvertToNumberIfNumeric(data string, t reflect.Type) any {
 	}
 	return data
 }
+
+// BuildInfoLabels returns the version, the vcs revision (unknown without vcs
+// stamping) and the go version of the binary
+func BuildInfoLabels() prometheus.Labels {
+	labels := prometheus.Labels{"version": version, "revision": "unknown", "goversion": runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				labels["revision"] = setting.Value
+			}
+		}
+	}
+	return labels
+}